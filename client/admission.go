@@ -0,0 +1,105 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/containerd/ttrpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// AdmissionReview is what the admission hook reads on stdin for every
+// mutating RPC.
+type AdmissionReview struct {
+	Service string `json:"service"`
+	Method  string `json:"method"`
+
+	// Request is the request as JSON, Spec the container or process spec it
+	// carries, when the decoder knows them. Payload is the request as sent.
+	Request json.RawMessage `json:"request,omitempty"`
+	Spec    json.RawMessage `json:"spec,omitempty"`
+	Payload []byte          `json:"payload"`
+}
+
+// AdmissionDecoder renders the protobuf payload of a request as JSON, and
+// the spec it carries if any. It returns nil for what it doesn't know.
+type AdmissionDecoder func(service, method string, payload []byte) (request, spec []byte)
+
+// AdmissionError is returned for RPCs the admission hook rejected. It
+// carries codes.PermissionDenied, so callers retrying on transient errors
+// give up on it.
+type AdmissionError struct {
+	Service string
+	Method  string
+	Message string
+}
+
+func (e *AdmissionError) Error() string {
+	if len(e.Message) <= 0 {
+		return fmt.Sprintf("%s/%s was rejected by the admission hook", e.Service, e.Method)
+	}
+	return fmt.Sprintf("%s/%s was rejected by the admission hook: %s", e.Service, e.Method, e.Message)
+}
+
+// GRPCStatus lets status.FromError and status.Code see the rejection.
+func (e *AdmissionError) GRPCStatus() *status.Status {
+	return status.New(codes.PermissionDenied, e.Error())
+}
+
+// Admission runs hook with an AdmissionReview on stdin before every mutating
+// RPC, and the method as its argument. The RPC is only sent when the hook
+// exits with status 0, otherwise it fails with an AdmissionError carrying
+// what the hook wrote to stderr. decode may be nil.
+func Admission(hook string, decode AdmissionDecoder) ttrpc.UnaryClientInterceptor {
+	return func(ctx context.Context, req *ttrpc.Request, resp *ttrpc.Response, _ *ttrpc.UnaryClientInfo, invoker ttrpc.Invoker) error {
+		if IsReadOnlyMethod(req.Service, req.Method) {
+			return invoker(ctx, req, resp)
+		}
+
+		review := AdmissionReview{
+			Service: req.Service,
+			Method:  req.Method,
+			Payload: req.Payload,
+		}
+
+		if decode != nil {
+			request, spec := decode(req.Service, req.Method, req.Payload)
+			if json.Valid(request) {
+				review.Request = request
+			}
+			if json.Valid(spec) {
+				review.Spec = spec
+			}
+		}
+
+		in, err := json.Marshal(review)
+		if err != nil {
+			return err
+		}
+
+		var stderr bytes.Buffer
+
+		cmd := exec.CommandContext(ctx, hook, req.Service+"/"+req.Method)
+		cmd.Stdin = bytes.NewReader(in)
+		cmd.Stderr = &stderr
+
+		if err := cmd.Run(); err != nil {
+			if _, ok := err.(*exec.ExitError); !ok {
+				// failing closed, a hook that can't run admits nothing
+				return status.Errorf(codes.FailedPrecondition, "running admission hook %s: %s", hook, err)
+			}
+			return &AdmissionError{
+				Service: req.Service,
+				Method:  req.Method,
+				Message: strings.TrimSpace(stderr.String()),
+			}
+		}
+
+		return invoker(ctx, req, resp)
+	}
+}
@@ -0,0 +1,69 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os/user"
+	"sync"
+	"time"
+
+	"github.com/containerd/ttrpc"
+	"google.golang.org/grpc/status"
+)
+
+// AuditRecord is written for every mutating RPC issued.
+type AuditRecord struct {
+	Time   time.Time `json:"time"`
+	User   string    `json:"user"`
+	CID    uint32    `json:"cid"`
+	Method string    `json:"method"`
+	Digest string    `json:"request_sha256"`
+	Result string    `json:"result"`
+}
+
+// Audit writes an AuditRecord, one JSON object per line, to w for every
+// mutating RPC sent to the agent at cid. Read-only RPCs are not recorded.
+func Audit(w io.Writer, cid uint32) ttrpc.UnaryClientInterceptor {
+	var mu sync.Mutex
+	username := ""
+	if u, err := user.Current(); err == nil {
+		username = u.Username
+	}
+
+	return func(ctx context.Context, req *ttrpc.Request, resp *ttrpc.Response, _ *ttrpc.UnaryClientInfo, invoker ttrpc.Invoker) error {
+		if IsReadOnlyMethod(req.Service, req.Method) {
+			return invoker(ctx, req, resp)
+		}
+
+		digest := sha256.Sum256(req.Payload)
+		record := AuditRecord{
+			Time:   time.Now().UTC(),
+			User:   username,
+			CID:    cid,
+			Method: req.Service + "/" + req.Method,
+			Digest: hex.EncodeToString(digest[:]),
+			Result: "ok",
+		}
+
+		// the agent's own failures are only turned into errors by Call,
+		// after the interceptors ran
+		err := invoker(ctx, req, resp)
+		if err != nil {
+			record.Result = err.Error()
+		} else if resp.Status != nil && resp.Status.Code != 0 {
+			record.Result = status.ErrorProto(resp.Status).Error()
+		}
+
+		b, _ := json.Marshal(record)
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		w.Write(append(b, '\n'))
+
+		return err
+	}
+}
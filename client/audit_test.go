@@ -0,0 +1,61 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/containerd/ttrpc"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+)
+
+func TestAuditResult(t *testing.T) {
+	tests := []struct {
+		name    string
+		invoker ttrpc.Invoker
+		want    string
+	}{
+		{
+			name: "ok",
+			invoker: func(context.Context, *ttrpc.Request, *ttrpc.Response) error {
+				return nil
+			},
+			want: "ok",
+		},
+		{
+			name: "agent status",
+			invoker: func(_ context.Context, _ *ttrpc.Request, resp *ttrpc.Response) error {
+				resp.Status = &spb.Status{Code: int32(codes.NotFound), Message: "container c1 not found"}
+				return nil
+			},
+			want: "rpc error: code = NotFound desc = container c1 not found",
+		},
+		{
+			name: "transport error",
+			invoker: func(context.Context, *ttrpc.Request, *ttrpc.Response) error {
+				return errors.New("connection reset")
+			},
+			want: "connection reset",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+
+			req := &ttrpc.Request{Service: "containerd.task.v2.Task", Method: "Kill"}
+			Audit(&out, 3)(context.Background(), req, &ttrpc.Response{}, &ttrpc.UnaryClientInfo{}, tt.invoker)
+
+			record := AuditRecord{}
+			if err := json.Unmarshal(out.Bytes(), &record); err != nil {
+				t.Fatalf("parsing audit record %q: %v", out.String(), err)
+			}
+			if record.Result != tt.want {
+				t.Errorf("result = %q, want %q", record.Result, tt.want)
+			}
+		})
+	}
+}
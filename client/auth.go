@@ -0,0 +1,36 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/containerd/ttrpc"
+)
+
+// AuthTokenMetadataKey is the ttrpc metadata key the token is sent under
+const AuthTokenMetadataKey = "auth-token"
+
+// Handshake runs on a freshly dialed connection before any RPC is issued,
+// for agents that expect something on the wire before they accept requests.
+type Handshake func(conn net.Conn) error
+
+// TokenPreamble sends the token, terminated by a newline, as the first bytes
+// on the connection.
+func TokenPreamble(token string) Handshake {
+	return func(conn net.Conn) error {
+		_, err := fmt.Fprintf(conn, "%s\n", token)
+		return err
+	}
+}
+
+// TokenMetadata attaches the token to the metadata of every request.
+func TokenMetadata(token string) ttrpc.UnaryClientInterceptor {
+	return func(ctx context.Context, req *ttrpc.Request, resp *ttrpc.Response, _ *ttrpc.UnaryClientInfo, invoker ttrpc.Invoker) error {
+		req.Metadata = append(req.Metadata, &ttrpc.KeyValue{
+			Key:   AuthTokenMetadataKey,
+			Value: token,
+		})
+		return invoker(ctx, req, resp)
+	}
+}
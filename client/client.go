@@ -1,22 +1,40 @@
 package client
 
 import (
-	"log"
+	"fmt"
 
 	"github.com/containerd/ttrpc"
-	"github.com/dehydr8/firecracker-containerd-agent-client/util"
 )
 
-func New(cid, port uint32, opts ...ttrpc.ClientOpts) (*ttrpc.Client, func()) {
-	conn, err := util.VSockDial(cid, port)
+func New(cid, port uint32, opts ...ttrpc.ClientOpts) (*ttrpc.Client, func(), error) {
+	return NewWithTransport(VSockTransport{}, cid, port, nil, opts...)
+}
+
+// NewWithHandshake is like New but runs handshake on the connection before
+// the ttrpc client starts using it.
+func NewWithHandshake(cid, port uint32, handshake Handshake, opts ...ttrpc.ClientOpts) (*ttrpc.Client, func(), error) {
+	return NewWithTransport(VSockTransport{}, cid, port, handshake, opts...)
+}
+
+// NewWithTransport dials the agent through transport, runs the optional
+// handshake and layers a ttrpc client on top of the connection.
+func NewWithTransport(transport Transport, cid, port uint32, handshake Handshake, opts ...ttrpc.ClientOpts) (*ttrpc.Client, func(), error) {
+	conn, err := transport.Dial(cid, port)
 
 	if err != nil {
-		log.Fatalf("Failure dialing: %s", err)
+		return nil, nil, fmt.Errorf("dialing: %w", err)
+	}
+
+	if handshake != nil {
+		if err := handshake(conn); err != nil {
+			conn.Close()
+			return nil, nil, fmt.Errorf("handshake: %w", err)
+		}
 	}
 
 	client := ttrpc.NewClient(conn, opts...)
 	return client, func() {
 		conn.Close()
 		client.Close()
-	}
+	}, nil
 }
@@ -0,0 +1,23 @@
+package client
+
+import (
+	"context"
+
+	"github.com/containerd/ttrpc"
+)
+
+// ChainUnaryClientInterceptors combines interceptors into one, ttrpc only
+// accepts a single interceptor per client. The first interceptor is the
+// outermost one.
+func ChainUnaryClientInterceptors(interceptors ...ttrpc.UnaryClientInterceptor) ttrpc.UnaryClientInterceptor {
+	return func(ctx context.Context, req *ttrpc.Request, resp *ttrpc.Response, info *ttrpc.UnaryClientInfo, invoker ttrpc.Invoker) error {
+		chained := invoker
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, next := interceptors[i], chained
+			chained = func(ctx context.Context, req *ttrpc.Request, resp *ttrpc.Response) error {
+				return interceptor(ctx, req, resp, info, next)
+			}
+		}
+		return chained(ctx, req, resp)
+	}
+}
@@ -0,0 +1,39 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	shim "github.com/containerd/containerd/api/runtime/task/v2"
+	"github.com/containerd/ttrpc"
+)
+
+// KeepAlive calls Task/Connect for the container every interval until ctx is
+// done. vsock gives no timely notice of a dead peer, so a call that fails or
+// doesn't answer within the interval is taken as the agent being gone and
+// its error is returned.
+func KeepAlive(ctx context.Context, c *ttrpc.Client, id string, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+
+		callCtx, cancel := context.WithTimeout(ctx, interval)
+		err := c.Call(callCtx, "containerd.task.v2.Task", "Connect", &shim.ConnectRequest{ID: id}, &shim.ConnectResponse{})
+		cancel()
+
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		if err != nil {
+			return fmt.Errorf("keepalive failed: %w", err)
+		}
+	}
+}
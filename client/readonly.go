@@ -0,0 +1,54 @@
+package client
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/containerd/ttrpc"
+)
+
+// readOnlyMethods are the RPCs that don't change any state in the guest.
+var readOnlyMethods = map[string]bool{
+	"aws.firecracker.containerd.eventbridge.getter/GetEvent": true,
+
+	"containerd.task.v2.Task/State":   true,
+	"containerd.task.v2.Task/Pids":    true,
+	"containerd.task.v2.Task/Wait":    true,
+	"containerd.task.v2.Task/Stats":   true,
+	"containerd.task.v2.Task/Connect": true,
+
+	"IOProxy/State": true,
+}
+
+// IsReadOnlyMethod reports whether the method is allowed in read-only mode.
+func IsReadOnlyMethod(service, method string) bool {
+	return readOnlyMethods[service+"/"+method]
+}
+
+// ReadOnlyError is returned for mutating RPCs rejected in read-only mode.
+type ReadOnlyError struct {
+	Service string
+	Method  string
+}
+
+func (e *ReadOnlyError) Error() string {
+	return fmt.Sprintf("%s/%s is not allowed in read-only mode", e.Service, e.Method)
+}
+
+// CheckReadOnly returns a ReadOnlyError if the method would mutate state.
+func CheckReadOnly(service, method string) error {
+	if IsReadOnlyMethod(service, method) {
+		return nil
+	}
+	return &ReadOnlyError{Service: service, Method: method}
+}
+
+// ReadOnly rejects every mutating RPC locally, before it reaches the agent.
+func ReadOnly() ttrpc.UnaryClientInterceptor {
+	return func(ctx context.Context, req *ttrpc.Request, resp *ttrpc.Response, _ *ttrpc.UnaryClientInfo, invoker ttrpc.Invoker) error {
+		if err := CheckReadOnly(req.Service, req.Method); err != nil {
+			return err
+		}
+		return invoker(ctx, req, resp)
+	}
+}
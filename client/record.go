@@ -0,0 +1,167 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/containerd/ttrpc"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+)
+
+// RecordedCall is one RPC of a recorded session, stored as a JSON file.
+type RecordedCall struct {
+	Seq      int    `json:"seq"`
+	Service  string `json:"service"`
+	Method   string `json:"method"`
+	Request  []byte `json:"request"`
+	Response []byte `json:"response,omitempty"`
+	Code     int32  `json:"code"`
+	Message  string `json:"message,omitempty"`
+
+	// Error is set when the call failed before the agent answered.
+	Error string `json:"error,omitempty"`
+
+	// RequestJSON and ResponseJSON are only there for humans reading the
+	// recording, replay uses the protobuf payloads.
+	RequestJSON  json.RawMessage `json:"request_json,omitempty"`
+	ResponseJSON json.RawMessage `json:"response_json,omitempty"`
+}
+
+// Decoder renders the protobuf payloads of a call as JSON, it returns nil for
+// payloads it doesn't know.
+type Decoder func(service, method string, req, res []byte) (reqJSON, resJSON []byte)
+
+// Record writes every call to dir, one JSON file per call, for later use with
+// Replay. decode may be nil.
+func Record(dir string, decode Decoder) (ttrpc.UnaryClientInterceptor, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	// commands may dial the agent more than once, continue the numbering
+	// of the calls already in dir
+	existing, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var mu sync.Mutex
+	seq := len(existing)
+
+	return func(ctx context.Context, req *ttrpc.Request, resp *ttrpc.Response, _ *ttrpc.UnaryClientInfo, invoker ttrpc.Invoker) error {
+		err := invoker(ctx, req, resp)
+
+		mu.Lock()
+		seq++
+		call := &RecordedCall{
+			Seq:      seq,
+			Service:  req.Service,
+			Method:   req.Method,
+			Request:  req.Payload,
+			Response: resp.Payload,
+		}
+		mu.Unlock()
+
+		if err != nil {
+			call.Error = err.Error()
+		} else if resp.Status != nil {
+			call.Code = resp.Status.Code
+			call.Message = resp.Status.Message
+		}
+
+		if decode != nil {
+			call.RequestJSON, call.ResponseJSON = decode(req.Service, req.Method, req.Payload, resp.Payload)
+		}
+
+		b, _ := json.MarshalIndent(call, "", "  ")
+		name := fmt.Sprintf("%04d-%s-%s.json", call.Seq, req.Service, req.Method)
+		if werr := os.WriteFile(filepath.Join(dir, name), b, 0644); werr != nil {
+			return fmt.Errorf("recording call: %w", werr)
+		}
+
+		return err
+	}, nil
+}
+
+// Replay answers calls from a directory written by Record, without invoking
+// the agent. Calls to a method are answered in the order they were recorded.
+func Replay(dir string) (ttrpc.UnaryClientInterceptor, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	calls := map[string][]*RecordedCall{}
+	var recorded []*RecordedCall
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+
+		b, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		call := &RecordedCall{}
+		if err := json.Unmarshal(b, call); err != nil {
+			return nil, fmt.Errorf("%s: %w", entry.Name(), err)
+		}
+		recorded = append(recorded, call)
+	}
+
+	sort.Slice(recorded, func(i, j int) bool { return recorded[i].Seq < recorded[j].Seq })
+	for _, call := range recorded {
+		key := call.Service + "/" + call.Method
+		calls[key] = append(calls[key], call)
+	}
+
+	var mu sync.Mutex
+
+	return func(ctx context.Context, req *ttrpc.Request, resp *ttrpc.Response, _ *ttrpc.UnaryClientInfo, _ ttrpc.Invoker) error {
+		key := req.Service + "/" + req.Method
+
+		mu.Lock()
+		queue := calls[key]
+		if len(queue) <= 0 {
+			mu.Unlock()
+			return fmt.Errorf("no recorded call left for %s", key)
+		}
+		call := queue[0]
+		calls[key] = queue[1:]
+		mu.Unlock()
+
+		if len(call.Error) > 0 {
+			return fmt.Errorf("recorded error: %s", call.Error)
+		}
+
+		resp.Payload = call.Response
+		resp.Status = &spb.Status{
+			Code:    call.Code,
+			Message: call.Message,
+		}
+		return nil
+	}, nil
+}
+
+// ReplayTransport hands out connections that lead nowhere, for clients whose
+// calls are all answered by Replay.
+type ReplayTransport struct{}
+
+func (ReplayTransport) Dial(cid, port uint32) (net.Conn, error) {
+	conn, peer := net.Pipe()
+	go func() {
+		io.Copy(io.Discard, peer)
+		peer.Close()
+	}()
+	return conn, nil
+}
@@ -0,0 +1,104 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/containerd/ttrpc"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// ResponseType returns a new message of the response type of a method, nil
+// when the method isn't known.
+type ResponseType func(service, method string) proto.Message
+
+// UnknownFieldsError is returned in strict mode for responses carrying
+// fields the client's protos don't have, a sign the agent was built from
+// other protos.
+type UnknownFieldsError struct {
+	Service string
+	Method  string
+	Fields  []string
+}
+
+func (e *UnknownFieldsError) Error() string {
+	return fmt.Sprintf("response of %s/%s has unknown fields %s, the agent's protos may differ from the client's",
+		e.Service, e.Method, strings.Join(e.Fields, ", "))
+}
+
+// Strict fails calls whose response has fields that would otherwise be
+// dropped silently when unmarshalling it. Responses of methods responseType
+// doesn't know aren't checked.
+func Strict(responseType ResponseType) ttrpc.UnaryClientInterceptor {
+	return func(ctx context.Context, req *ttrpc.Request, resp *ttrpc.Response, _ *ttrpc.UnaryClientInfo, invoker ttrpc.Invoker) error {
+		if err := invoker(ctx, req, resp); err != nil {
+			return err
+		}
+
+		if resp.Status != nil && resp.Status.Code != 0 {
+			return nil
+		}
+
+		m := responseType(req.Service, req.Method)
+		if m == nil {
+			return nil
+		}
+
+		if err := proto.Unmarshal(resp.Payload, m); err != nil {
+			return err
+		}
+
+		if fields := UnknownFields(m.ProtoReflect(), ""); len(fields) > 0 {
+			return &UnknownFieldsError{
+				Service: req.Service,
+				Method:  req.Method,
+				Fields:  fields,
+			}
+		}
+
+		return nil
+	}
+}
+
+// UnknownFields lists the unknown fields of m and the messages in it, as
+// dotted paths ending in the field number.
+func UnknownFields(m protoreflect.Message, prefix string) []string {
+	var fields []string
+
+	unknown := m.GetUnknown()
+	for len(unknown) > 0 {
+		num, _, n := protowire.ConsumeField(unknown)
+		if n < 0 {
+			fields = append(fields, prefix+"<malformed>")
+			break
+		}
+		fields = append(fields, fmt.Sprintf("%s%d", prefix, num))
+		unknown = unknown[n:]
+	}
+
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		path := prefix + string(fd.Name())
+
+		switch {
+		case fd.IsList() && fd.Message() != nil:
+			list := v.List()
+			for i := 0; i < list.Len(); i++ {
+				fields = append(fields, UnknownFields(list.Get(i).Message(), fmt.Sprintf("%s[%d].", path, i))...)
+			}
+		case fd.IsMap() && fd.MapValue().Message() != nil:
+			v.Map().Range(func(k protoreflect.MapKey, mv protoreflect.Value) bool {
+				fields = append(fields, UnknownFields(mv.Message(), fmt.Sprintf("%s[%v].", path, k.Interface()))...)
+				return true
+			})
+		case !fd.IsList() && !fd.IsMap() && fd.Message() != nil:
+			fields = append(fields, UnknownFields(v.Message(), path+".")...)
+		}
+
+		return true
+	})
+
+	return fields
+}
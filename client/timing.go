@@ -0,0 +1,120 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/containerd/ttrpc"
+	"github.com/dehydr8/firecracker-containerd-agent-client/util"
+	"github.com/sirupsen/logrus"
+)
+
+// longPollMethods block until something happens in the guest, how long
+// they take says nothing about the agent being slow.
+var longPollMethods = map[string]bool{
+	"aws.firecracker.containerd.eventbridge.getter/GetEvent": true,
+
+	"containerd.task.v2.Task/Wait": true,
+}
+
+// MethodTiming adds up the calls of one method.
+type MethodTiming struct {
+	Method string
+	Calls  int
+	Errors int
+	Total  time.Duration
+	Max    time.Duration
+}
+
+// Timings measures how long the RPCs of a session take.
+type Timings struct {
+	mu      sync.Mutex
+	methods map[string]*MethodTiming
+}
+
+func NewTimings() *Timings {
+	return &Timings{
+		methods: map[string]*MethodTiming{},
+	}
+}
+
+// Interceptor times every RPC, and warns about those taking longer than
+// slow, unless it is 0, on the logger of their context. Long polls are
+// timed but never reported as slow.
+func (t *Timings) Interceptor(slow time.Duration) ttrpc.UnaryClientInterceptor {
+	return func(ctx context.Context, req *ttrpc.Request, resp *ttrpc.Response, _ *ttrpc.UnaryClientInfo, invoker ttrpc.Invoker) error {
+		method := req.Service + "/" + req.Method
+
+		started := time.Now()
+		err := invoker(ctx, req, resp)
+		took := time.Since(started)
+
+		// the agent's own failures only become errors in Call
+		failed := err != nil || (resp.Status != nil && resp.Status.Code != 0)
+
+		t.add(method, took, failed)
+
+		if slow > 0 && took > slow && !longPollMethods[method] {
+			util.ProxyLogger(ctx).WithFields(logrus.Fields{
+				"method":    method,
+				"duration":  took,
+				"threshold": slow,
+				"failed":    failed,
+			}).Warn("slow RPC")
+		}
+
+		return err
+	}
+}
+
+func (t *Timings) add(method string, took time.Duration, failed bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	m, ok := t.methods[method]
+	if !ok {
+		m = &MethodTiming{Method: method}
+		t.methods[method] = m
+	}
+
+	m.Calls++
+	m.Total += took
+	if took > m.Max {
+		m.Max = took
+	}
+	if failed {
+		m.Errors++
+	}
+}
+
+// Methods returns the timings of each method called, the one that took the
+// longest in total first.
+func (t *Timings) Methods() []MethodTiming {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	methods := make([]MethodTiming, 0, len(t.methods))
+	for _, m := range t.methods {
+		methods = append(methods, *m)
+	}
+
+	sort.Slice(methods, func(i, j int) bool {
+		return methods[i].Total > methods[j].Total
+	})
+
+	return methods
+}
+
+// WriteSummary writes a table of the timings to w.
+func (t *Timings) WriteSummary(w io.Writer) {
+	fmt.Fprintf(w, "%-50s %6s %6s %12s %12s %12s\n", "METHOD", "CALLS", "ERRORS", "TOTAL", "AVG", "MAX")
+	for _, m := range t.Methods() {
+		avg := m.Total / time.Duration(m.Calls)
+		fmt.Fprintf(w, "%-50s %6d %6d %12s %12s %12s\n", m.Method, m.Calls, m.Errors,
+			m.Total.Round(time.Microsecond), avg.Round(time.Microsecond), m.Max.Round(time.Microsecond))
+	}
+}
@@ -0,0 +1,42 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/containerd/ttrpc"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+)
+
+func TestTimingsErrors(t *testing.T) {
+	timings := NewTimings()
+	interceptor := timings.Interceptor(0)
+	req := &ttrpc.Request{Service: "containerd.task.v2.Task", Method: "Kill"}
+
+	invokers := []ttrpc.Invoker{
+		func(context.Context, *ttrpc.Request, *ttrpc.Response) error {
+			return nil
+		},
+		func(_ context.Context, _ *ttrpc.Request, resp *ttrpc.Response) error {
+			resp.Status = &spb.Status{Code: int32(codes.NotFound)}
+			return nil
+		},
+		func(context.Context, *ttrpc.Request, *ttrpc.Response) error {
+			return errors.New("connection reset")
+		},
+	}
+
+	for _, invoker := range invokers {
+		interceptor(context.Background(), req, &ttrpc.Response{}, &ttrpc.UnaryClientInfo{}, invoker)
+	}
+
+	methods := timings.Methods()
+	if len(methods) != 1 {
+		t.Fatalf("got %d methods, want 1", len(methods))
+	}
+	if methods[0].Calls != 3 || methods[0].Errors != 2 {
+		t.Errorf("got %d calls and %d errors, want 3 and 2", methods[0].Calls, methods[0].Errors)
+	}
+}
@@ -0,0 +1,43 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"time"
+
+	"github.com/containerd/ttrpc"
+)
+
+// Trace gives every RPC a random trace ID and logs it with the outcome of
+// the RPC to logger, so the lines of one RPC can be told apart from those of
+// the others in flight.
+func Trace(logger *slog.Logger) ttrpc.UnaryClientInterceptor {
+	return func(ctx context.Context, req *ttrpc.Request, resp *ttrpc.Response, _ *ttrpc.UnaryClientInfo, invoker ttrpc.Invoker) error {
+		b := make([]byte, 16)
+		rand.Read(b)
+		traceID := hex.EncodeToString(b)
+
+		started := time.Now()
+		err := invoker(ctx, req, resp)
+
+		attrs := []slog.Attr{
+			slog.String("trace_id", traceID),
+			slog.String("method", req.Service+"/"+req.Method),
+			slog.Duration("duration", time.Since(started)),
+		}
+
+		switch {
+		case err != nil:
+			logger.LogAttrs(ctx, slog.LevelError, "rpc failed", append(attrs, slog.String("error", err.Error()))...)
+		case resp.Status != nil && resp.Status.Code != 0:
+			logger.LogAttrs(ctx, slog.LevelWarn, "rpc returned an error", append(attrs,
+				slog.Int("code", int(resp.Status.Code)), slog.String("message", resp.Status.Message))...)
+		default:
+			logger.LogAttrs(ctx, slog.LevelInfo, "rpc", attrs...)
+		}
+
+		return err
+	}
+}
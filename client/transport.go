@@ -0,0 +1,127 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"os"
+
+	"github.com/dehydr8/firecracker-containerd-agent-client/util"
+)
+
+// Transport establishes the connection the ttrpc client runs over.
+type Transport interface {
+	Dial(cid, port uint32) (net.Conn, error)
+}
+
+// VSockTransport dials the agent directly over vsock.
+type VSockTransport struct{}
+
+func (VSockTransport) Dial(cid, port uint32) (net.Conn, error) {
+	return util.VSockDial(cid, port)
+}
+
+// TLSTransport wraps the connections of another Transport in TLS, for agent
+// ports that are tunneled through a TLS terminating wrapper in the guest.
+type TLSTransport struct {
+	Transport Transport
+	Config    *tls.Config
+}
+
+func (t *TLSTransport) Dial(cid, port uint32) (net.Conn, error) {
+	conn, err := t.Transport.Dial(cid, port)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConn := tls.Client(conn, t.Config)
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return tlsConn, nil
+}
+
+// NewTLSConfig builds a client TLS config from PEM encoded files. An empty
+// certFile skips client authentication and an empty caFile uses the system
+// roots. vsock peers have no hostname, so when serverName is empty only the
+// certificate chain is verified.
+func NewTLSConfig(certFile, keyFile, caFile, serverName string) (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName: serverName,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if len(certFile) > 0 {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if len(caFile) > 0 {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates found in CA file")
+		}
+	}
+
+	if len(serverName) <= 0 {
+		config.InsecureSkipVerify = true
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			return verifyChain(state, config.RootCAs)
+		}
+	}
+
+	return config, nil
+}
+
+func verifyChain(state tls.ConnectionState, roots *x509.CertPool) error {
+	if len(state.PeerCertificates) <= 0 {
+		return errors.New("no peer certificates presented")
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	_, err := state.PeerCertificates[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+	})
+	return err
+}
+
+// UnixTransport dials a unix socket instead of vsock, cid and port are
+// ignored. It is meant for agents served on the host, like the mock agent.
+type UnixTransport struct {
+	Path string
+}
+
+func (t UnixTransport) Dial(cid, port uint32) (net.Conn, error) {
+	return net.Dial("unix", t.Path)
+}
+
+// ChaosTransport randomly delays and severs the connections of another
+// Transport, for resilience testing.
+type ChaosTransport struct {
+	Transport Transport
+	Chaos     *util.Chaos
+}
+
+func (t *ChaosTransport) Dial(cid, port uint32) (net.Conn, error) {
+	conn, err := t.Transport.Dial(cid, port)
+	if err != nil {
+		return nil, err
+	}
+	return t.Chaos.Conn(conn), nil
+}
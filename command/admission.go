@@ -0,0 +1,53 @@
+package command
+
+import (
+	shim "github.com/containerd/containerd/api/runtime/task/v2"
+	"github.com/dehydr8/firecracker-containerd-agent-client/proto"
+	"google.golang.org/protobuf/encoding/protojson"
+	gproto "google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// decodeAdmission renders a request for the admission hook. Create and Exec
+// carry their spec in an ExtraData Any protojson can't resolve, it is taken
+// out and passed on by itself.
+func decodeAdmission(service, method string, payload []byte) ([]byte, []byte) {
+	var msg gproto.Message
+	var options **anypb.Any
+
+	switch service + "/" + method {
+	case serviceName + "/" + createMethodName:
+		req := &shim.CreateTaskRequest{}
+		msg, options = req, &req.Options
+	case serviceName + "/" + execMethodName:
+		req := &shim.ExecProcessRequest{}
+		msg, options = req, &req.Spec
+	default:
+		req, _ := decodeRecorded(service, method, payload, nil)
+		return req, nil
+	}
+
+	if err := gproto.Unmarshal(payload, msg); err != nil {
+		return nil, nil
+	}
+
+	var spec []byte
+	if *options != nil {
+		data := &proto.ExtraData{}
+		if err := gproto.Unmarshal((*options).Value, data); err == nil {
+			// Exec only sends its process spec as RuncOptions
+			spec = data.JsonSpec
+			if len(spec) <= 0 && data.RuncOptions != nil {
+				spec = data.RuncOptions.Value
+			}
+		}
+		*options = nil
+	}
+
+	req, err := protojson.Marshal(msg)
+	if err != nil {
+		return nil, spec
+	}
+
+	return req, spec
+}
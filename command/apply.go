@@ -0,0 +1,268 @@
+package command
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"slices"
+
+	shim "github.com/containerd/containerd/api/runtime/task/v2"
+	"github.com/containerd/containerd/api/types/task"
+	"github.com/containerd/ttrpc"
+	"github.com/google/subcommands"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	applyCreated   = "created"
+	applyStarted   = "started"
+	applyRecreated = "recreated"
+	applyUnchanged = "unchanged"
+	applyDeleted   = "deleted"
+)
+
+// applyResult is reported for each container of the manifest.
+type applyResult struct {
+	ID     string `json:"id"`
+	Pid    uint32 `json:"pid"`
+	Action string `json:"action"`
+}
+
+type ApplyCmd struct {
+	baseCmd
+
+	manifest  string
+	reconcile bool
+}
+
+func (*ApplyCmd) Name() string     { return "apply" }
+func (*ApplyCmd) Synopsis() string { return "Create and start the containers of a manifest" }
+func (*ApplyCmd) Usage() string {
+	return `apply -f manifest.yaml [-reconcile]:
+	Create and start the containers declared in the manifest, each one after
+	the containers it depends on. With -reconcile, containers that already
+	run are left alone, created ones are started and stopped ones are
+	deleted and created again. Containers apply or up created from the
+	manifest and recorded in the -store, that it no longer declares, are
+	killed and deleted.
+  `
+}
+
+func (p *ApplyCmd) SetFlags(f *flag.FlagSet) {
+	p.baseCmd.SetFlags(f)
+	f.StringVar(&p.manifest, "f", "", "Manifest file")
+	f.BoolVar(&p.reconcile, "reconcile", false, "Only act on containers that aren't already running, and delete those no longer declared")
+}
+
+func (p *ApplyCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	ctx, cancel := p.context(ctx)
+	defer cancel()
+
+	if len(p.manifest) <= 0 {
+		logf(ctx, "No manifest defined")
+		return subcommands.ExitFailure
+	}
+
+	methods := []string{createMethodName, startMethodName}
+	if p.reconcile {
+		methods = append(methods, killMethodName, deleteMethodName)
+	}
+
+	for _, method := range methods {
+		if err := checkReadOnly(ctx, serviceName, method); err != nil {
+			logf(ctx, "%s\n", err)
+			return subcommands.ExitFailure
+		}
+	}
+
+	m, err := loadManifest(p.manifest)
+	if err != nil {
+		logf(ctx, "Failure loading manifest: %s\n", err)
+		return subcommands.ExitFailure
+	}
+
+	containers, err := m.ordered()
+	if err != nil {
+		logf(ctx, "Failure ordering manifest: %s\n", err)
+		return subcommands.ExitFailure
+	}
+
+	client, cleanup, err := p.newClient(ctx)
+	if err != nil {
+		logf(ctx, "Failure creating client: %s\n", err)
+		return subcommands.ExitFailure
+	}
+	defer cleanup()
+
+	apply := applyContainer
+	if p.reconcile {
+		apply = reconcileContainer
+	}
+
+	results := []*applyResult{}
+
+	for _, c := range containers {
+		result, err := apply(ctx, client, c)
+		if err != nil {
+			logf(ctx, "Failure applying container %s: %s\n", c.ID, err)
+			return subcommands.ExitFailure
+		}
+
+		logf(ctx, "Container %s %s, PID: %d\n", c.ID, result.Action, result.Pid)
+
+		if result.Action == applyCreated || result.Action == applyRecreated {
+			recordDeclaredContainer(ctx, &p.baseCmd, c, p.manifest)
+		}
+
+		results = append(results, result)
+	}
+
+	if p.reconcile {
+		pruned, err := p.prune(ctx, client, containers)
+		results = append(results, pruned...)
+		if err != nil {
+			logf(ctx, "Failure deleting containers no longer declared: %s\n", err)
+			return subcommands.ExitFailure
+		}
+	}
+
+	if err := p.report(ctx, results, "Applied %d containers from %s\n", len(results), p.manifest); err != nil {
+		logf(ctx, "Failure reporting result: %s\n", err)
+		return subcommands.ExitFailure
+	}
+
+	return subcommands.ExitSuccess
+}
+
+// prune deletes the containers recorded as created from the manifest that
+// aren't among declared anymore, returning those deleted.
+func (p *ApplyCmd) prune(ctx context.Context, client *ttrpc.Client, declared []*manifestContainer) ([]*applyResult, error) {
+	store := storeFrom(ctx)
+	if store == nil {
+		logf(ctx, "No -store, containers no longer declared aren't deleted\n")
+		return nil, nil
+	}
+
+	manifest, err := filepath.Abs(p.manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	recorded, err := store.list(p.target(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", store.path, err)
+	}
+
+	var results []*applyResult
+	for _, r := range recorded {
+		if r.Manifest != manifest || r.ExitedAt != nil || slices.ContainsFunc(declared, func(c *manifestContainer) bool { return c.ID == r.ID }) {
+			continue
+		}
+
+		logf(ctx, "Container %s is no longer declared\n", r.ID)
+
+		if _, err := deleteContainer(ctx, client, r.ID); err != nil {
+			return results, fmt.Errorf("container %s: %w", r.ID, err)
+		}
+		forgetContainer(ctx, &p.baseCmd, r.ID)
+
+		results = append(results, &applyResult{
+			ID:     r.ID,
+			Action: applyDeleted,
+		})
+	}
+
+	return results, nil
+}
+
+// applyContainer creates and starts the container.
+func applyContainer(ctx context.Context, client *ttrpc.Client, c *manifestContainer) (*applyResult, error) {
+	logf(ctx, "Creating container: %s\n", c.ID)
+
+	req, err := c.request()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := client.Call(ctx, serviceName, createMethodName, req, &shim.CreateTaskResponse{}); err != nil {
+		return nil, err
+	}
+
+	pid, err := startContainer(ctx, client, c.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &applyResult{
+		ID:     c.ID,
+		Pid:    pid,
+		Action: applyCreated,
+	}, nil
+}
+
+func startContainer(ctx context.Context, client *ttrpc.Client, id string) (uint32, error) {
+	startReq := &shim.StartRequest{
+		ID: id,
+	}
+
+	startRes := &shim.StartResponse{}
+
+	if err := client.Call(ctx, serviceName, startMethodName, startReq, startRes); err != nil {
+		return 0, err
+	}
+
+	return startRes.Pid, nil
+}
+
+// reconcileContainer brings the container to running from whatever state the
+// agent has it in. The agent doesn't give the spec of a container back, so a
+// running container whose declaration changed is left alone; delete it to
+// have it created again.
+func reconcileContainer(ctx context.Context, client *ttrpc.Client, c *manifestContainer) (*applyResult, error) {
+	stateReq := &shim.StateRequest{
+		ID: c.ID,
+	}
+
+	stateRes := &shim.StateResponse{}
+
+	if err := client.Call(ctx, serviceName, stateMethodName, stateReq, stateRes); err != nil {
+		if status.Code(err) == codes.NotFound {
+			return applyContainer(ctx, client, c)
+		}
+		return nil, err
+	}
+
+	switch stateRes.Status {
+	case task.Status_CREATED:
+		pid, err := startContainer(ctx, client, c.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		return &applyResult{
+			ID:     c.ID,
+			Pid:    pid,
+			Action: applyStarted,
+		}, nil
+	case task.Status_STOPPED:
+		if _, err := deleteContainer(ctx, client, c.ID); err != nil {
+			return nil, err
+		}
+
+		result, err := applyContainer(ctx, client, c)
+		if err != nil {
+			return nil, err
+		}
+
+		result.Action = applyRecreated
+		return result, nil
+	default:
+		return &applyResult{
+			ID:     c.ID,
+			Pid:    stateRes.Pid,
+			Action: applyUnchanged,
+		}, nil
+	}
+}
@@ -0,0 +1,47 @@
+package command
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// argFlags let the command of a process be given without going through a
+// shell, for arguments with spaces or quotes that would get mangled on the
+// way.
+type argFlags struct {
+	arg0    string
+	argFile string
+}
+
+func (a *argFlags) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&a.arg0, "arg0", "", "Program to run, put before the other arguments")
+	f.StringVar(&a.argFile, "arg-file", "", "File with one argument per line, taken as is and put before the command line arguments")
+}
+
+// args is the command of the process, arg0 followed by the arguments of the
+// arg file and then those left on the command line.
+func (a *argFlags) args(cmdline []string) ([]string, error) {
+	var args []string
+
+	if len(a.arg0) > 0 {
+		args = append(args, a.arg0)
+	}
+
+	if len(a.argFile) > 0 {
+		b, err := os.ReadFile(a.argFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading arg file: %w", err)
+		}
+
+		// only the newline ending the last argument is dropped, blank
+		// lines are empty arguments
+		content := strings.TrimSuffix(string(b), "\n")
+		if len(content) > 0 {
+			args = append(args, strings.Split(content, "\n")...)
+		}
+	}
+
+	return append(args, cmdline...), nil
+}
@@ -0,0 +1,63 @@
+package command
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/containerd/ttrpc"
+	"github.com/dehydr8/firecracker-containerd-agent-client/client"
+)
+
+const (
+	authModeMetadata = "metadata"
+	authModePreamble = "preamble"
+)
+
+// authFlags holds the token used by hardened agents that require one before
+// accepting RPCs.
+type authFlags struct {
+	token     string
+	tokenFile string
+	mode      string
+}
+
+func (a *authFlags) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&a.token, "auth-token", "", "Token sent to the agent before any RPC")
+	f.StringVar(&a.tokenFile, "auth-token-file", "", "File to read the auth token from")
+	f.StringVar(&a.mode, "auth-mode", authModeMetadata, "How the auth token is sent (metadata, preamble)")
+}
+
+// sendsMetadata tells whether the token goes in the metadata of the
+// requests, which ttrpc streams don't carry.
+func (a *authFlags) sendsMetadata() bool {
+	return (len(a.token) > 0 || len(a.tokenFile) > 0) && a.mode == authModeMetadata
+}
+
+// setup returns the handshake or interceptor needed to send the token, both
+// are nil when no token was given.
+func (a *authFlags) setup() (client.Handshake, ttrpc.UnaryClientInterceptor, error) {
+	token := a.token
+
+	if len(a.tokenFile) > 0 {
+		b, err := os.ReadFile(a.tokenFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading auth token file: %w", err)
+		}
+		token = strings.TrimSpace(string(b))
+	}
+
+	if len(token) <= 0 {
+		return nil, nil, nil
+	}
+
+	switch a.mode {
+	case authModeMetadata:
+		return nil, client.TokenMetadata(token), nil
+	case authModePreamble:
+		return client.TokenPreamble(token), nil, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown auth mode: %s", a.mode)
+	}
+}
@@ -0,0 +1,170 @@
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/containerd/ttrpc"
+	"github.com/dehydr8/firecracker-containerd-agent-client/client"
+	"github.com/dehydr8/firecracker-containerd-agent-client/util"
+	"golang.org/x/term"
+)
+
+const (
+	defaultAgentPort = 10789
+
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// baseCmd holds the flags and setup shared by every subcommand that talks to
+// the agent. Subcommands embed it and call its SetFlags from their own.
+type baseCmd struct {
+	cid     int
+	port    int
+	timeout time.Duration
+	output  string
+	conn    connFlags
+	extract extractFlags
+
+	// unixSocket replaces vsock with a unix socket, for agents served on
+	// the host like the mock agent
+	unixSocket string
+
+	// IDs and Ports can be set by embedders to use their own allocation
+	// policies, random UUIDs and ports are used when they are nil.
+	IDs   util.IDGenerator
+	Ports util.PortAllocator
+}
+
+func (b *baseCmd) SetFlags(f *flag.FlagSet) {
+	b.setAgentFlags(f)
+	f.StringVar(&b.output, "output", outputText, "Output format (text, json)")
+	f.StringVar(&b.output, "o", outputText, "Shorthand for -output")
+	b.extract.SetFlags(f)
+}
+
+// setAgentFlags sets only the flags reaching the agent, for subcommands
+// that use -o for something else than the output format.
+func (b *baseCmd) setAgentFlags(f *flag.FlagSet) {
+	f.IntVar(&b.cid, "cid", 0, "Vsock Context ID")
+	f.IntVar(&b.port, "port", defaultAgentPort, "Vsock Port")
+	f.DurationVar(&b.timeout, "timeout", 0, "Timeout for the whole command, 0 for none")
+	f.StringVar(&b.unixSocket, "unix-socket", "", "Reach the agent through a unix socket instead of vsock")
+	b.conn.SetFlags(f)
+}
+
+// confirmTarget asks the user to go ahead with action on the agent b points
+// at. Without a terminal to ask on it fails, -yes is the way to go ahead then.
+func (b *baseCmd) confirmTarget(name, action string) (bool, error) {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return false, fmt.Errorf("%s needs confirmation, use -yes when not running interactively", name)
+	}
+
+	target := fmt.Sprintf("VM cid %d", b.cid)
+	if len(b.unixSocket) > 0 {
+		target = fmt.Sprintf("the agent at %s", b.unixSocket)
+	}
+
+	return util.Confirm(os.Stdin, os.Stderr, fmt.Sprintf("About to %s on %s. Continue?", action, target))
+}
+
+// context applies the timeout, if any, to ctx. The returned cancel func must
+// always be called.
+func (b *baseCmd) context(ctx context.Context) (context.Context, context.CancelFunc) {
+	if b.timeout > 0 {
+		return context.WithTimeout(ctx, b.timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// newClient dials the agent, the returned cleanup must be called once the
+// client is no longer needed.
+func (b *baseCmd) newClient(ctx context.Context) (*ttrpc.Client, func(), error) {
+	return b.conn.newClient(ctx, b.transport(), b.cid, b.port)
+}
+
+func (b *baseCmd) transport() client.Transport {
+	if len(b.unixSocket) > 0 {
+		return client.UnixTransport{Path: b.unixSocket}
+	}
+	return client.VSockTransport{}
+}
+
+// ioConnector connects to an IO port the agent listens on for a process.
+func (b *baseCmd) ioConnector(ctx context.Context, port uint32) util.IOConnector {
+	connector := util.VSockDialConnector(uint32(b.cid), port)
+	if len(b.unixSocket) > 0 {
+		connector = util.UnixDialConnector(b.unixSocket, port)
+	}
+
+	if chaos := &globalsFrom(ctx).Chaos; chaos.Enabled() {
+		connector = chaos.Connector(connector)
+	}
+
+	return connector
+}
+
+// logf logs through the logger of ctx, see util.WithLogger.
+func logf(ctx context.Context, format string, args ...interface{}) {
+	util.LoggerFrom(ctx).Printf(format, args...)
+}
+
+// newID makes an ID for a container, process or IO stream.
+func (b *baseCmd) newID() string {
+	if b.IDs == nil {
+		return util.UUIDGenerator{}.NewID()
+	}
+	return b.IDs.NewID()
+}
+
+// vsockPorts allocates the stdin, stdout and stderr ports of a process.
+func (b *baseCmd) vsockPorts() (uint32, uint32, uint32, error) {
+	var ports util.PortAllocator = util.RandomPortAllocator{}
+	if b.Ports != nil {
+		ports = b.Ports
+	}
+
+	p, err := ports.AllocatePorts(3)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("allocating vsock ports: %w", err)
+	}
+	return p, p + 1, p + 2, nil
+}
+
+// report writes v as JSON to stdout with -output json, otherwise the format
+// and args are logged. -jsonpath and -go-template take precedence over both,
+// the command should fail when they can't be applied to v.
+func (b *baseCmd) report(ctx context.Context, v interface{}, format string, args ...interface{}) error {
+	if printed, err := b.printExtract(v); printed || err != nil {
+		return err
+	}
+
+	if b.output == outputJSON {
+		out, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stdout, string(out))
+		return nil
+	}
+
+	logf(ctx, format, args...)
+	return nil
+}
+
+// printExtract prints what -jsonpath or -go-template pick out of v to
+// stdout, it returns false when neither was given.
+func (b *baseCmd) printExtract(v interface{}) (bool, error) {
+	out, ok, err := b.extract.extract(v)
+	if !ok || err != nil {
+		return false, err
+	}
+
+	fmt.Fprintln(os.Stdout, out)
+	return true, nil
+}
@@ -0,0 +1,243 @@
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	shim "github.com/containerd/containerd/api/runtime/task/v2"
+	"github.com/containerd/ttrpc"
+	"github.com/google/subcommands"
+)
+
+const (
+	connectMethodName = "Connect"
+)
+
+// benchResult is reported once the benchmark is done.
+type benchResult struct {
+	RPC        *latencyResult    `json:"rpc,omitempty"`
+	Throughput *throughputResult `json:"throughput,omitempty"`
+}
+
+// latencyResult is the round-trip time distribution of Connect calls, in
+// milliseconds.
+type latencyResult struct {
+	Calls int     `json:"calls"`
+	Min   float64 `json:"min_ms"`
+	Mean  float64 `json:"mean_ms"`
+	P50   float64 `json:"p50_ms"`
+	P90   float64 `json:"p90_ms"`
+	P99   float64 `json:"p99_ms"`
+	Max   float64 `json:"max_ms"`
+}
+
+// throughputResult is the rate data made the round trip through an echo
+// process, from the first byte written to its stdin to the last one read
+// from its stdout.
+type throughputResult struct {
+	Bytes           int64   `json:"bytes"`
+	Seconds         float64 `json:"seconds"`
+	MegabytesPerSec float64 `json:"mb_per_sec"`
+}
+
+type BenchCmd struct {
+	baseCmd
+
+	containerId string
+	rpcs        int
+	sizeMB      int
+}
+
+func (*BenchCmd) Name() string     { return "bench" }
+func (*BenchCmd) Synopsis() string { return "Measure vsock throughput and RPC latency" }
+func (*BenchCmd) Usage() string {
+	return `bench -container_id id [-rpcs n] [-size-mb n] [<echo command>]:
+	Time Connect round trips, then stream data through an echo process
+	(cat by default) exec'd in the container, and print the results as JSON.
+  `
+}
+
+func (p *BenchCmd) SetFlags(f *flag.FlagSet) {
+	p.baseCmd.SetFlags(f)
+	f.StringVar(&p.containerId, "container_id", "", "Container ID")
+	f.IntVar(&p.rpcs, "rpcs", 100, "Number of RPC round trips to time, 0 to skip")
+	f.IntVar(&p.sizeMB, "size-mb", 16, "Megabytes to stream through the echo process, 0 to skip")
+}
+
+func (p *BenchCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	ctx, cancel := p.context(ctx)
+	defer cancel()
+
+	if len(p.containerId) <= 0 {
+		logf(ctx, "No container ID defined")
+		return subcommands.ExitFailure
+	}
+
+	containerId, err := resolveContainerID(ctx, &p.baseCmd, p.containerId)
+	if err != nil {
+		logf(ctx, "%s\n", err)
+		return subcommands.ExitFailure
+	}
+	p.containerId = containerId
+
+	args := f.Args()
+	if len(args) <= 0 {
+		args = []string{"cat"}
+	}
+
+	if p.sizeMB > 0 {
+		if err := checkReadOnly(ctx, serviceName, execMethodName); err != nil {
+			logf(ctx, "%s\n", err)
+			return subcommands.ExitFailure
+		}
+	}
+
+	client, cleanup, err := p.newClient(ctx)
+	if err != nil {
+		logf(ctx, "Failure creating client: %s\n", err)
+		return subcommands.ExitFailure
+	}
+	defer cleanup()
+
+	result := &benchResult{}
+
+	if p.rpcs > 0 {
+		if result.RPC, err = p.benchRPC(ctx, client); err != nil {
+			logf(ctx, "Failure in RPC benchmark: %s\n", err)
+			return subcommands.ExitFailure
+		}
+	}
+
+	if p.sizeMB > 0 {
+		if result.Throughput, err = p.benchThroughput(ctx, client, args); err != nil {
+			logf(ctx, "Failure in throughput benchmark: %s\n", err)
+			return subcommands.ExitFailure
+		}
+	}
+
+	printed, err := p.printExtract(result)
+	if err != nil {
+		logf(ctx, "Failure extracting from result: %s\n", err)
+		return subcommands.ExitFailure
+	}
+
+	if !printed {
+		out, _ := json.Marshal(result)
+		fmt.Fprintln(os.Stdout, string(out))
+	}
+
+	return subcommands.ExitSuccess
+}
+
+// benchRPC times Connect, the cheapest call the agent answers.
+func (p *BenchCmd) benchRPC(ctx context.Context, client *ttrpc.Client) (*latencyResult, error) {
+	req := &shim.ConnectRequest{
+		ID: p.containerId,
+	}
+
+	samples := make([]time.Duration, 0, p.rpcs)
+	var total time.Duration
+
+	for i := 0; i < p.rpcs; i++ {
+		start := time.Now()
+		if err := client.Call(ctx, serviceName, connectMethodName, req, &shim.ConnectResponse{}); err != nil {
+			return nil, err
+		}
+		elapsed := time.Since(start)
+
+		samples = append(samples, elapsed)
+		total += elapsed
+	}
+
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+
+	percentile := func(q float64) float64 {
+		return millis(samples[int(q*float64(len(samples)-1))])
+	}
+
+	return &latencyResult{
+		Calls: len(samples),
+		Min:   millis(samples[0]),
+		Mean:  millis(total / time.Duration(len(samples))),
+		P50:   percentile(0.5),
+		P90:   percentile(0.9),
+		P99:   percentile(0.99),
+		Max:   millis(samples[len(samples)-1]),
+	}, nil
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// benchThroughput execs args in the container and streams sizeMB through its
+// stdin and back from its stdout. The time is taken from the first byte sent
+// to the last one echoed, leaving out setting up the exec.
+func (p *BenchCmd) benchThroughput(ctx context.Context, client *ttrpc.Client, args []string) (*throughputResult, error) {
+	size := int64(p.sizeMB) * 1024 * 1024
+
+	source := &benchSource{remaining: size}
+	sink := &benchSink{}
+
+	exit, err := execProcess(ctx, &p.baseCmd, client, p.containerId, args, source, sink)
+	if err != nil {
+		return nil, err
+	}
+
+	if exit.ExitStatus != 0 {
+		return nil, fmt.Errorf("%s exited with status %d", strings.Join(args, " "), exit.ExitStatus)
+	}
+
+	if sink.n != size {
+		return nil, fmt.Errorf("echoed %d of %d bytes", sink.n, size)
+	}
+
+	elapsed := sink.last.Sub(source.first)
+
+	return &throughputResult{
+		Bytes:           sink.n,
+		Seconds:         elapsed.Seconds(),
+		MegabytesPerSec: float64(sink.n) / (1024 * 1024) / elapsed.Seconds(),
+	}, nil
+}
+
+// benchSource reads as remaining zero bytes, noting when the first was read.
+type benchSource struct {
+	remaining int64
+	first     time.Time
+}
+
+func (s *benchSource) Read(b []byte) (int, error) {
+	if s.remaining <= 0 {
+		return 0, io.EOF
+	}
+
+	if s.first.IsZero() {
+		s.first = time.Now()
+	}
+
+	n := min(int64(len(b)), s.remaining)
+	clear(b[:n])
+	s.remaining -= n
+
+	return int(n), nil
+}
+
+// benchSink counts the bytes echoed, noting when the last came.
+type benchSink struct {
+	n    int64
+	last time.Time
+}
+
+func (s *benchSink) Write(b []byte) (int, error) {
+	s.n += int64(len(b))
+	s.last = time.Now()
+	return len(b), nil
+}
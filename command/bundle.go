@@ -0,0 +1,187 @@
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/google/subcommands"
+	"github.com/google/uuid"
+)
+
+const bundleConfigName = "config.json"
+
+// BundleCmd groups the commands working on bundle directories.
+type BundleCmd struct{}
+
+func (*BundleCmd) Name() string     { return "bundle" }
+func (*BundleCmd) Synopsis() string { return "Work with OCI bundle directories" }
+func (*BundleCmd) Usage() string {
+	return `bundle prepare -rootfs dir -dir dir [-id id] <command>:
+	Write an OCI bundle without containerd on the host.
+  `
+}
+
+func (*BundleCmd) SetFlags(*flag.FlagSet) {}
+
+func (*BundleCmd) Execute(ctx context.Context, f *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	commander := subcommands.NewCommander(f, "bundle")
+	commander.Register(&bundlePrepareCmd{}, "")
+	return commander.Execute(ctx, args...)
+}
+
+type bundlePrepareCmd struct {
+	spec specFlags
+
+	rootfs string
+	dir    string
+	id     string
+}
+
+func (*bundlePrepareCmd) Name() string     { return "prepare" }
+func (*bundlePrepareCmd) Synopsis() string { return "Write a bundle from an extracted rootfs" }
+func (*bundlePrepareCmd) Usage() string {
+	return `prepare -rootfs dir -dir dir [-id id] <command>:
+	Copy the rootfs into dir and write the config.json create would send for
+	the command. The spec flags are the same as create's.
+  `
+}
+
+func (p *bundlePrepareCmd) SetFlags(f *flag.FlagSet) {
+	p.spec.SetFlags(f)
+	f.StringVar(&p.rootfs, "rootfs", "", "Extracted rootfs directory")
+	f.StringVar(&p.dir, "dir", "", "Bundle directory to write")
+	f.StringVar(&p.id, "id", "", "Container ID the cgroup path is derived from, random if empty")
+}
+
+func (p *bundlePrepareCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	if len(p.rootfs) <= 0 || len(p.dir) <= 0 {
+		logf(ctx, "Both -rootfs and -dir are required")
+		return subcommands.ExitFailure
+	}
+
+	if len(f.Args()) <= 0 {
+		logf(ctx, "No command defined")
+		return subcommands.ExitFailure
+	}
+
+	if len(p.id) <= 0 {
+		p.id = uuid.NewString()
+	}
+
+	spec, err := p.spec.spec(p.id, f.Args())
+	if err != nil {
+		logf(ctx, "Failure building spec: %s\n", err)
+		return subcommands.ExitFailure
+	}
+
+	if err := p.spec.mutate(spec); err != nil {
+		logf(ctx, "%s\n", err)
+		return subcommands.ExitFailure
+	}
+
+	rootfs := filepath.Join(p.dir, spec.Root.Path)
+
+	if err := copyTree(ctx, p.rootfs, rootfs); err != nil {
+		logf(ctx, "Failure copying rootfs: %s\n", err)
+		return subcommands.ExitFailure
+	}
+
+	config, err := json.MarshalIndent(spec, "", "\t")
+	if err != nil {
+		logf(ctx, "Failure encoding %s: %s\n", bundleConfigName, err)
+		return subcommands.ExitFailure
+	}
+
+	if err := os.WriteFile(filepath.Join(p.dir, bundleConfigName), config, 0644); err != nil {
+		logf(ctx, "Failure writing %s: %s\n", bundleConfigName, err)
+		return subcommands.ExitFailure
+	}
+
+	logf(ctx, "Bundle for container %s written to %s\n", p.id, p.dir)
+
+	return subcommands.ExitSuccess
+}
+
+// copyTree copies the directories, regular files and symlinks under src to
+// dst, keeping their modes and, when permitted, their owners. Other file
+// types, like device nodes, are skipped since runc creates those itself.
+func copyTree(ctx context.Context, src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case d.IsDir():
+			if err := os.MkdirAll(target, info.Mode().Perm()); err != nil {
+				return err
+			}
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			os.Remove(target)
+			if err := os.Symlink(link, target); err != nil {
+				return err
+			}
+		case d.Type().IsRegular():
+			if err := copyFile(path, target, info.Mode().Perm()); err != nil {
+				return err
+			}
+		default:
+			logf(ctx, "Skipping %s, unsupported file type %s\n", path, d.Type())
+			return nil
+		}
+
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			// only root can give files away, a rootless copy keeps its own
+			os.Lchown(target, int(stat.Uid), int(stat.Gid))
+		}
+
+		if d.Type()&fs.ModeSymlink != 0 {
+			return nil
+		}
+
+		// set the mode last, the umask and chown both strip bits of it
+		return os.Chmod(target, info.Mode())
+	})
+}
+
+func copyFile(src, dst string, perm fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return fmt.Errorf("copying %s: %w", src, err)
+	}
+
+	return out.Close()
+}
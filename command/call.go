@@ -2,15 +2,19 @@ package command
 
 import (
 	"context"
+	"encoding/hex"
 	"encoding/json"
 	"flag"
 	"fmt"
-	"log"
+	"os"
 
 	shim "github.com/containerd/containerd/api/runtime/task/v2"
 	events "github.com/containerd/containerd/api/services/events/v1"
-	"github.com/dehydr8/firecracker-containerd-agent-client/client"
 	"github.com/dehydr8/firecracker-containerd-agent-client/proto"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/prototext"
+	gproto "google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/dynamicpb"
 	"google.golang.org/protobuf/types/known/emptypb"
 
 	"github.com/google/subcommands"
@@ -48,11 +52,32 @@ var requestMapping = map[string]Pair{
 	"DriveMounter/UnmountDrive": {&proto.UnmountDriveRequest{}, &emptypb.Empty{}},
 }
 
+const (
+	encodingJSON      = "json"
+	encodingProtoText = "prototext"
+	encodingHex       = "hex"
+)
+
+// destructiveMethods need confirmation before they are called, unless -yes
+// was given.
+var destructiveMethods = map[string]bool{
+	"containerd.task.v2.Task/Kill":     true,
+	"containerd.task.v2.Task/Delete":   true,
+	"containerd.task.v2.Task/Shutdown": true,
+}
+
 type CallCmd struct {
-	cid     int
-	port    int
+	baseCmd
+
 	service string
 	method  string
+	yes     bool
+
+	responseEncoding string
+	descriptorSets   stringList
+	stream           bool
+	skeleton         bool
+	sets             stringList
 }
 
 func (*CallCmd) Name() string     { return "call" }
@@ -64,58 +89,283 @@ func (*CallCmd) Usage() string {
 }
 
 func (p *CallCmd) SetFlags(f *flag.FlagSet) {
-	f.IntVar(&p.cid, "cid", 0, "Vsock Context ID")
-	f.IntVar(&p.port, "port", 10789, "Vsock Port")
+	p.baseCmd.SetFlags(f)
 	f.StringVar(&p.service, "service", "", "Service name")
 	f.StringVar(&p.method, "method", "", "Method name")
+	f.BoolVar(&p.yes, "yes", false, "Don't ask for confirmation of destructive methods")
+	f.Var(&p.descriptorSets, "descriptor-set", "Compiled FileDescriptorSet with services missing from the request mapping (repeatable)")
+	f.Var(&p.sets, "set", "Set a field of the request, as dotted.path=value, after the JSON argument (repeatable)")
+	f.BoolVar(&p.skeleton, "skeleton", false, "Print a JSON skeleton of the request with every field instead of calling the method")
+	f.BoolVar(&p.stream, "stream", false, "Call a streaming method of the -descriptor-set, with requests and responses as JSON lines on stdin and stdout")
+	f.StringVar(&p.responseEncoding, "response-encoding", encodingJSON, "How the response is shown (json, prototext, hex)")
 }
 
-func (p *CallCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+func (p *CallCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	ctx, cancel := p.context(ctx)
+	defer cancel()
+
 	if len(p.service) <= 0 {
-		log.Printf("No service defined")
+		logf(ctx, "No service defined")
 		return subcommands.ExitFailure
 	}
 
 	if len(p.method) <= 0 {
-		log.Printf("No method defined")
+		logf(ctx, "No method defined")
 		return subcommands.ExitFailure
 	}
 
+	switch p.responseEncoding {
+	case encodingJSON:
+	case encodingProtoText, encodingHex:
+		if p.output == outputJSON {
+			logf(ctx, "-response-encoding %s can't be used with -output json\n", p.responseEncoding)
+			return subcommands.ExitFailure
+		}
+	default:
+		logf(ctx, "Unknown response encoding: %s\n", p.responseEncoding)
+		return subcommands.ExitFailure
+	}
+
+	if p.stream {
+		return p.executeStream(ctx, f)
+	}
+
 	serviceKey := fmt.Sprintf("%s/%s", p.service, p.method)
 
 	val, ok := requestMapping[serviceKey]
 
+	if !ok && len(p.descriptorSets) > 0 {
+		files, err := loadDescriptorSets(p.descriptorSets)
+		if err != nil {
+			logf(ctx, "Failure loading descriptor sets: %s\n", err)
+			return subcommands.ExitFailure
+		}
+
+		if val, err = dynamicPair(files, p.service, p.method); err != nil {
+			logf(ctx, "%s\n", err)
+			return subcommands.ExitFailure
+		}
+		ok = true
+	}
+
 	if !ok {
-		log.Printf("No request mapping defined for: %s\n", serviceKey)
+		logf(ctx, "No request mapping defined for: %s\n", serviceKey)
 		return subcommands.ExitFailure
 	}
 
-	c, cleanup := client.New(uint32(p.cid), uint32(p.port))
-	defer cleanup()
+	if p.skeleton {
+		m, ok := val.req.(gproto.Message)
+		if !ok {
+			logf(ctx, "%T is not a protobuf message\n", val.req)
+			return subcommands.ExitFailure
+		}
+
+		writeSkeleton(os.Stdout, m.ProtoReflect().Descriptor())
+		return subcommands.ExitSuccess
+	}
+
+	if err := checkReadOnly(ctx, p.service, p.method); err != nil {
+		logf(ctx, "%s\n", err)
+		return subcommands.ExitFailure
+	}
 
 	req := val.req
 
-	if len(f.Args()) > 0 {
-		input := f.Arg(0)
-		e := json.Unmarshal([]byte(input), req)
+	input := []byte(f.Arg(0))
+
+	if len(p.sets) > 0 {
+		m, ok := req.(gproto.Message)
+		if !ok {
+			logf(ctx, "%T is not a protobuf message\n", req)
+			return subcommands.ExitFailure
+		}
+
+		applied, err := applySets(input, m.ProtoReflect().Descriptor(), p.sets)
+		if err != nil {
+			logf(ctx, "Failure applying -set: %s\n", err)
+			return subcommands.ExitFailure
+		}
+		input = applied
+	}
+
+	if len(input) > 0 {
+		e := unmarshalRequest(input, req)
 		if e != nil {
-			log.Printf("Failure unmarshalling input: %s\n", e)
+			logf(ctx, "Failure unmarshalling input: %s\n", e)
+			return subcommands.ExitFailure
+		}
+	}
+
+	if destructiveMethods[serviceKey] && !p.yes {
+		ok, err := p.confirmTarget(serviceKey, callAction(serviceKey, req))
+		if err != nil {
+			logf(ctx, "Failure asking for confirmation: %s\n", err)
+			return subcommands.ExitFailure
+		}
+
+		if !ok {
+			logf(ctx, "Aborted\n")
 			return subcommands.ExitFailure
 		}
 	}
 
+	c, cleanup, err := p.newClient(ctx)
+	if err != nil {
+		logf(ctx, "Failure creating client: %s\n", err)
+		return subcommands.ExitFailure
+	}
+	defer cleanup()
+
 	res := val.res
 
-	err := c.Call(context.Background(), p.service, p.method, req, res)
+	err = c.Call(ctx, p.service, p.method, req, res)
+
+	if err != nil {
+		logf(ctx, "Failure in Call: %s\n", err)
+		return subcommands.ExitFailure
+	}
+
+	a, err := encodeResponse(res, p.responseEncoding)
+	if err != nil {
+		logf(ctx, "Failure encoding response: %s\n", err)
+		return subcommands.ExitFailure
+	}
+
+	if _, ok := res.(*dynamicpb.Message); ok {
+		// encoding/json can't see the fields of dynamic messages
+		res = json.RawMessage(a)
+	}
+
+	if err := p.report(ctx, res, "%s\n", a); err != nil {
+		logf(ctx, "Failure reporting result: %s\n", err)
+		return subcommands.ExitFailure
+	}
+
+	return subcommands.ExitSuccess
+}
+
+// callAction summarizes what a destructive call affects, for confirmTarget.
+func callAction(serviceKey string, req interface{}) string {
+	action := "call " + serviceKey
+
+	if r, ok := req.(interface{ GetID() string }); ok {
+		action += fmt.Sprintf(" for container ID %q", r.GetID())
+	}
+
+	if r, ok := req.(interface{ GetExecID() string }); ok && len(r.GetExecID()) > 0 {
+		action += fmt.Sprintf(", exec ID %q", r.GetExecID())
+	}
+
+	return action
+}
+
+// executeStream is Execute for -stream.
+func (p *CallCmd) executeStream(ctx context.Context, f *flag.FlagSet) subcommands.ExitStatus {
+	if len(p.descriptorSets) <= 0 {
+		logf(ctx, "-stream needs the method in a -descriptor-set\n")
+		return subcommands.ExitFailure
+	}
+
+	if err := checkReadOnly(ctx, p.service, p.method); err != nil {
+		logf(ctx, "%s\n", err)
+		return subcommands.ExitFailure
+	}
+
+	if err := p.checkStream(ctx); err != nil {
+		logf(ctx, "%s\n", err)
+		return subcommands.ExitFailure
+	}
+
+	files, err := loadDescriptorSets(p.descriptorSets)
+	if err != nil {
+		logf(ctx, "Failure loading descriptor sets: %s\n", err)
+		return subcommands.ExitFailure
+	}
 
+	md, err := findMethod(files, p.service, p.method)
 	if err != nil {
-		log.Printf("Failure in Call: %s\n", err)
+		logf(ctx, "%s\n", err)
 		return subcommands.ExitFailure
 	}
 
-	a, _ := json.Marshal(res)
+	c, cleanup, err := p.newClient(ctx)
+	if err != nil {
+		logf(ctx, "Failure creating client: %s\n", err)
+		return subcommands.ExitFailure
+	}
+	defer cleanup()
 
-	log.Println(string(a))
+	if err := callStream(ctx, c, md, f.Arg(0), os.Stdin, os.Stdout); err != nil {
+		logf(ctx, "Failure in stream: %s\n", err)
+		return subcommands.ExitFailure
+	}
 
 	return subcommands.ExitSuccess
 }
+
+// checkStream refuses -stream along with what only applies to unary calls:
+// ttrpc has no stream interceptors, and its streams carry no metadata, so
+// the call would silently go without them.
+func (p *CallCmd) checkStream(ctx context.Context) error {
+	globals := globalsFrom(ctx)
+
+	for _, unary := range []struct {
+		flag string
+		set  bool
+	}{
+		{"-audit-log", len(globals.AuditLog) > 0},
+		{"-record-rpc", len(globals.RecordRPC) > 0},
+		{"-replay-rpc", len(globals.ReplayRPC) > 0},
+		{"-admission-hook", len(globals.AdmissionHook) > 0},
+		{"-strict", globals.Strict},
+	} {
+		if unary.set {
+			return fmt.Errorf("-stream can't be used with %s, streams don't go through it", unary.flag)
+		}
+	}
+
+	if p.conn.auth.sendsMetadata() {
+		return fmt.Errorf("-stream needs -auth-mode %s for the auth token, streams carry no metadata", authModePreamble)
+	}
+
+	return nil
+}
+
+// unmarshalRequest fills req from its JSON form. The generated types take
+// their json tags, messages built from descriptor sets the protobuf mapping.
+func unmarshalRequest(input []byte, req interface{}) error {
+	if m, ok := req.(*dynamicpb.Message); ok {
+		return protojson.Unmarshal(input, m)
+	}
+	return json.Unmarshal(input, req)
+}
+
+// encodeResponse renders res for the -response-encoding. prototext and hex
+// also show the fields the agent sent that this client's protos don't know,
+// which JSON drops.
+func encodeResponse(res interface{}, encoding string) ([]byte, error) {
+	if encoding == encodingJSON {
+		if m, ok := res.(*dynamicpb.Message); ok {
+			return protojson.Marshal(m)
+		}
+		return json.Marshal(res)
+	}
+
+	m, ok := res.(gproto.Message)
+	if !ok {
+		return nil, fmt.Errorf("%T is not a protobuf message", res)
+	}
+
+	switch encoding {
+	case encodingProtoText:
+		return prototext.MarshalOptions{Multiline: true, EmitUnknown: true}.Marshal(m)
+	case encodingHex:
+		b, err := gproto.Marshal(m)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(hex.Dump(b)), nil
+	default:
+		return nil, fmt.Errorf("unknown encoding %s", encoding)
+	}
+}
@@ -0,0 +1,78 @@
+package command
+
+import (
+	"context"
+	"flag"
+
+	shim "github.com/containerd/containerd/api/runtime/task/v2"
+	"github.com/google/subcommands"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+const closeIOMethodName = "CloseIO"
+
+type CloseIOCmd struct {
+	baseCmd
+
+	containerId string
+	execId      string
+}
+
+func (*CloseIOCmd) Name() string     { return "close-io" }
+func (*CloseIOCmd) Synopsis() string { return "Close the stdin of a process" }
+func (*CloseIOCmd) Usage() string {
+	return `close-io -container_id id [-exec_id id]:
+	Close the stdin of the process, which then reads EOF. Meant for
+	processes exec'd with -stdin-keep-open.
+  `
+}
+
+func (p *CloseIOCmd) SetFlags(f *flag.FlagSet) {
+	p.baseCmd.SetFlags(f)
+	f.StringVar(&p.containerId, "container_id", "", "Container ID")
+	f.StringVar(&p.execId, "exec_id", "", "Execution ID")
+}
+
+func (p *CloseIOCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	ctx, cancel := p.context(ctx)
+	defer cancel()
+
+	if len(p.containerId) <= 0 {
+		logf(ctx, "No container ID defined")
+		return subcommands.ExitFailure
+	}
+
+	containerId, err := resolveContainerID(ctx, &p.baseCmd, p.containerId)
+	if err != nil {
+		logf(ctx, "%s\n", err)
+		return subcommands.ExitFailure
+	}
+	p.containerId = containerId
+
+	if err := checkReadOnly(ctx, serviceName, closeIOMethodName); err != nil {
+		logf(ctx, "%s\n", err)
+		return subcommands.ExitFailure
+	}
+
+	client, cleanup, err := p.newClient(ctx)
+	if err != nil {
+		logf(ctx, "Failure creating client: %s\n", err)
+		return subcommands.ExitFailure
+	}
+	defer cleanup()
+
+	req := &shim.CloseIORequest{
+		ID:     p.containerId,
+		ExecID: p.execId,
+		Stdin:  true,
+	}
+
+	if err := client.Call(ctx, serviceName, closeIOMethodName, req, &emptypb.Empty{}); err != nil {
+		logf(ctx, "Failure in close-io call: %s\n", err)
+		return subcommands.ExitFailure
+	}
+
+	logf(ctx, "Stdin of %s closed\n", p.containerId)
+
+	return subcommands.ExitSuccess
+}
@@ -0,0 +1,151 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/containerd/ttrpc"
+	"github.com/dehydr8/firecracker-containerd-agent-client/util"
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+const (
+	// collectDir is the tmpfs the command's exit status is recorded on
+	collectDir = "/run/collect"
+
+	// collectWrapper runs the command, records its exit status and keeps
+	// the container up for the paths to be copied out, exec'ing into a
+	// container whose init exited isn't possible
+	collectWrapper = `"$@"; echo $? > ` + collectDir + `/status.tmp && mv ` + collectDir + `/status.tmp ` + collectDir + `/status; while :; do sleep 1; done`
+
+	// collectWaiter blocks until the wrapper recorded the exit status
+	collectWaiter = `while [ ! -e ` + collectDir + `/status ]; do sleep 1; done; cat ` + collectDir + `/status`
+)
+
+type collectPath struct {
+	src string
+	dst string
+}
+
+// collectFlags collects repeated -collect /path/in/container:/local/dir
+// flags.
+type collectFlags []collectPath
+
+func (c *collectFlags) String() string {
+	paths := make([]string, 0, len(*c))
+	for _, p := range *c {
+		paths = append(paths, p.src+":"+p.dst)
+	}
+	return strings.Join(paths, ",")
+}
+
+func (c *collectFlags) Set(value string) error {
+	src, dst, ok := strings.Cut(value, ":")
+	if !ok || !path.IsAbs(src) || len(dst) <= 0 {
+		return fmt.Errorf("expected /path/in/container:/local/dir, got %q", value)
+	}
+
+	*c = append(*c, collectPath{
+		src: path.Clean(src),
+		dst: dst,
+	})
+	return nil
+}
+
+// wrap has the command of spec run by collectWrapper, on the tmpfs its
+// exit status is recorded on, if there are paths to collect.
+func (c collectFlags) wrap(spec *specs.Spec) {
+	if len(c) <= 0 {
+		return
+	}
+
+	spec.Process.Args = append([]string{"sh", "-c", collectWrapper, "sh"}, spec.Process.Args...)
+
+	spec.Mounts = append(spec.Mounts, specs.Mount{
+		Destination: collectDir,
+		Type:        "tmpfs",
+		Source:      "tmpfs",
+		Options:     []string{"nosuid", "noexec", "nodev", "mode=755", "size=64k"},
+	})
+}
+
+// waitAndCollect waits for the command of container id to exit, copies the
+// paths out of the container and then stops it. The failures to collect
+// are returned with the exit of the command. When the container goes down
+// before the command's exit status is recorded, by a probe or a kill, its
+// own exit is returned and nothing is collected.
+func (c collectFlags) waitAndCollect(ctx context.Context, b *baseCmd, client *ttrpc.Client, id string) (*waitResult, []string, error) {
+	var out bytes.Buffer
+
+	waiter, err := execProcess(ctx, b, client, id, []string{"sh", "-c", collectWaiter}, nil, &out)
+	if ctx.Err() != nil {
+		return nil, nil, ctx.Err()
+	}
+
+	status, parseErr := strconv.ParseUint(strings.TrimSpace(out.String()), 10, 32)
+	if err != nil || waiter.ExitStatus != 0 || parseErr != nil {
+		logf(ctx, "Container %s went down before its command exited, nothing collected\n", id)
+
+		exit, err := waitForExit(ctx, client, id, "")
+		return exit, nil, err
+	}
+
+	exit := &waitResult{
+		ContainerID: id,
+		ExitStatus:  uint32(status),
+		ExitedAt:    time.Now().UTC(),
+	}
+
+	var failures []string
+	for _, p := range c {
+		logf(ctx, "Collecting %s of container %s to %s\n", p.src, id, p.dst)
+
+		if err := collect(ctx, b, client, id, p); err != nil {
+			logf(ctx, "Failure collecting %s: %s\n", p.src, err)
+			failures = append(failures, fmt.Sprintf("%s: %s", p.src, err))
+		}
+	}
+
+	if _, err := terminate(ctx, client, id, "", 0); err != nil {
+		return nil, nil, fmt.Errorf("stopping container once collected: %w", err)
+	}
+
+	return exit, failures, nil
+}
+
+// collect copies p.src out of container id into p.dst, as a tar stream
+// staged in a temporary file.
+func collect(ctx context.Context, b *baseCmd, client *ttrpc.Client, id string, p collectPath) error {
+	stream, err := os.CreateTemp("", "collect")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(stream.Name())
+	defer stream.Close()
+
+	exit, err := execProcess(ctx, b, client, id, []string{"tar", "-C", path.Dir(p.src), "-cf", "-", path.Base(p.src)}, nil, stream)
+	if err != nil {
+		return err
+	}
+
+	if exit.ExitStatus != 0 {
+		return fmt.Errorf("tar exited with status %d", exit.ExitStatus)
+	}
+
+	if _, err := stream.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(p.dst, 0755); err != nil {
+		return err
+	}
+
+	return util.ExtractTar(stream, p.dst)
+}
@@ -0,0 +1,118 @@
+package command
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/containerd/ttrpc"
+	"github.com/dehydr8/firecracker-containerd-agent-client/client"
+	"github.com/dehydr8/firecracker-containerd-agent-client/util"
+)
+
+// connFlags holds everything needed to reach the agent besides its address.
+type connFlags struct {
+	auth authFlags
+	tls  tlsFlags
+}
+
+func (cf *connFlags) SetFlags(f *flag.FlagSet) {
+	cf.auth.SetFlags(f)
+	cf.tls.SetFlags(f)
+}
+
+func (cf *connFlags) newClient(ctx context.Context, base client.Transport, cid, port int) (*ttrpc.Client, func(), error) {
+	transport, err := cf.tls.transport(base)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	handshake, authInterceptor, err := cf.auth.setup()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var interceptors []ttrpc.UnaryClientInterceptor
+	var closers []io.Closer
+
+	globals := globalsFrom(ctx)
+
+	if globals.ReadOnly {
+		interceptors = append(interceptors, client.ReadOnly())
+	}
+
+	if len(globals.AuditLog) > 0 {
+		auditLog, err := util.OpenAuditLog(globals.AuditLog)
+		if err != nil {
+			return nil, nil, fmt.Errorf("opening audit log: %w", err)
+		}
+		closers = append(closers, auditLog)
+		interceptors = append(interceptors, client.Audit(auditLog, uint32(cid)))
+	}
+
+	// inside the audit log, so rejected calls are recorded too
+	if len(globals.AdmissionHook) > 0 {
+		interceptors = append(interceptors, client.Admission(globals.AdmissionHook, decodeAdmission))
+	}
+
+	if globals.Strict {
+		interceptors = append(interceptors, client.Strict(responseType))
+	}
+
+	if authInterceptor != nil {
+		interceptors = append(interceptors, authInterceptor)
+	}
+
+	if len(globals.RecordRPC) > 0 {
+		record, err := client.Record(globals.RecordRPC, decodeRecorded)
+		if err != nil {
+			return nil, nil, fmt.Errorf("setting up RPC recording: %w", err)
+		}
+		interceptors = append(interceptors, record)
+	}
+
+	if len(globals.ReplayRPC) > 0 {
+		replay, err := client.Replay(globals.ReplayRPC)
+		if err != nil {
+			return nil, nil, fmt.Errorf("loading RPC recording: %w", err)
+		}
+		interceptors = append(interceptors, replay)
+		transport = client.ReplayTransport{}
+	}
+
+	if logger, ok := util.LoggerFrom(ctx).(util.SlogLogger); ok {
+		interceptors = append(interceptors, client.Trace(logger.Logger))
+	}
+
+	// innermost, so only the round trip to the agent is timed
+	if globals.Timings != nil && (globals.ShowTimings || globals.SlowRPC > 0) {
+		interceptors = append(interceptors, globals.Timings.Interceptor(globals.SlowRPC))
+	}
+
+	if globals.Chaos.Enabled() {
+		transport = &client.ChaosTransport{
+			Transport: transport,
+			Chaos:     &globals.Chaos,
+		}
+	}
+
+	var opts []ttrpc.ClientOpts
+	if len(interceptors) > 0 {
+		opts = append(opts, ttrpc.WithUnaryClientInterceptor(client.ChainUnaryClientInterceptors(interceptors...)))
+	}
+
+	c, cleanup, err := client.NewWithTransport(transport, uint32(cid), uint32(port), handshake, opts...)
+	if err != nil {
+		for _, closer := range closers {
+			closer.Close()
+		}
+		return nil, nil, err
+	}
+	return c, func() {
+		cleanup()
+		for _, closer := range closers {
+			closer.Close()
+		}
+	}, nil
+}
@@ -3,36 +3,58 @@ package command
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
-	"log"
-	"path/filepath"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"time"
 
 	shim "github.com/containerd/containerd/api/runtime/task/v2"
 	"github.com/containerd/containerd/api/types"
-	"github.com/dehydr8/firecracker-containerd-agent-client/client"
+	"github.com/containerd/ttrpc"
 	"github.com/dehydr8/firecracker-containerd-agent-client/proto"
 	ptypes "github.com/gogo/protobuf/types"
 	"github.com/google/subcommands"
-	"github.com/google/uuid"
 	"github.com/opencontainers/runtime-spec/specs-go"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/anypb"
 )
 
 const (
 	createMethodName  = "Create"
+	stateMethodName   = "State"
 	rwm               = "rwm"
 	defaultRootfsPath = "rootfs"
+
+	createRetryBackoff = time.Second
 )
 
+// createResult is reported once the container was created.
+type createResult struct {
+	ID  string `json:"id"`
+	Pid uint32 `json:"pid"`
+
+	// SpecDifferences are filled in with -verify-spec
+	SpecDifferences []specDifference `json:"spec_differences,omitempty"`
+}
+
 type CreateCmd struct {
-	cid          int
-	port         int
+	baseCmd
+
+	spec specFlags
+	args argFlags
+	ids  idFlags
+
+	verify  bool
+	secrets secretFlags
+
 	bundle       string
 	rootFSConfig string
-	mountsConfig string
-	namespace    string
-	pid          string
-	priv         bool
+	idempotent   bool
+	retries      int
 }
 
 func (*CreateCmd) Name() string     { return "create" }
@@ -44,15 +66,16 @@ func (*CreateCmd) Usage() string {
 }
 
 func (p *CreateCmd) SetFlags(f *flag.FlagSet) {
-	f.IntVar(&p.cid, "cid", 0, "Vsock Context ID")
-	f.IntVar(&p.port, "port", 10789, "Vsock Port")
+	p.baseCmd.SetFlags(f)
+	p.spec.SetFlags(f)
+	p.args.SetFlags(f)
+	p.ids.SetFlags(f)
 	f.StringVar(&p.rootFSConfig, "rootfs-config", "{}", "RootFS Config JSON")
-	f.StringVar(&p.mountsConfig, "mounts-config", "[]", "Mounts Config JSON")
 	f.StringVar(&p.bundle, "bundle", "", "Bundle")
-	f.StringVar(&p.namespace, "examplens", "", "cgroup Namespace")
-	f.StringVar(&p.pid, "pid", "", "PID NS Path")
-	f.BoolVar(&p.priv, "priv", false, "All Capabilities")
-
+	f.BoolVar(&p.idempotent, "idempotent", false, "Retry transient failures without creating the container twice")
+	f.IntVar(&p.retries, "retries", 3, "Number of retries with -idempotent")
+	f.BoolVar(&p.verify, "verify-spec", false, "Compare what the agent reports about the container with what was sent")
+	f.Var(&p.secrets, "secret", "Push a file into "+secretsDir+" of the container, as name=/local/path[:mode] (repeatable)")
 }
 
 func defaultUnixCaps() []string {
@@ -117,7 +140,7 @@ func privUnixCaps() []string {
 	}
 }
 
-func populateDefaultUnixSpec(ns, id, pidNsPath string, caps []string) *specs.Spec {
+func populateDefaultUnixSpec(cgroupsPath, pidNsPath string, caps []string) *specs.Spec {
 	return &specs.Spec{
 		Version: specs.Version,
 		Root: &specs.Root{
@@ -137,7 +160,7 @@ func populateDefaultUnixSpec(ns, id, pidNsPath string, caps []string) *specs.Spe
 			},
 		},
 		Linux: &specs.Linux{
-			CgroupsPath: filepath.Join("/", ns, id),
+			CgroupsPath: cgroupsPath,
 			Resources: &specs.LinuxResources{
 				Devices: []specs.LinuxDeviceCgroup{
 					{
@@ -213,73 +236,259 @@ func populateDefaultUnixSpec(ns, id, pidNsPath string, caps []string) *specs.Spe
 }
 
 func (p *CreateCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
-	id := uuid.NewString()
-	caps := defaultUnixCaps()
-
-	log.Printf("Creating container: %s\n", id)
+	ctx, cancel := p.context(ctx)
+	defer cancel()
 
-	if p.priv {
-		caps = privUnixCaps()
+	if err := checkReadOnly(ctx, serviceName, createMethodName); err != nil {
+		logf(ctx, "%s\n", err)
+		return subcommands.ExitFailure
 	}
 
-	spec := populateDefaultUnixSpec(p.namespace, id, p.pid, caps)
+	if err := p.ids.check(&p.baseCmd, false); err != nil {
+		logf(ctx, "%s\n", err)
+		return subcommands.ExitFailure
+	}
 
-	spec.Process.Args = f.Args()
-	spec.Process.Env = []string{
-		"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
+	if len(p.secrets) > 0 {
+		if err := checkReadOnly(ctx, serviceName, execMethodName); err != nil {
+			logf(ctx, "%s\n", err)
+			return subcommands.ExitFailure
+		}
 	}
 
-	var inputMounts []specs.Mount
-	if err := json.Unmarshal([]byte(p.mountsConfig), &inputMounts); err != nil {
-		log.Printf("Failure parsing mounts JSON config: %s\n", err)
+	args, err := p.args.args(f.Args())
+	if err != nil {
+		logf(ctx, "%s\n", err)
 		return subcommands.ExitFailure
 	}
 
-	// join it with the defaults
-	spec.Mounts = append(spec.Mounts, inputMounts...)
+	id := p.ids.generate(&p.baseCmd, args)
 
-	a, _ := json.Marshal(spec)
+	logf(ctx, "Creating container: %s\n", id)
 
-	// Firecracker agent expects the spec to be wrapped in ExtraData
-	wrapped := &proto.ExtraData{
-		RuncOptions: &anypb.Any{
-			TypeUrl: "",
-			Value:   a,
-		},
-		JsonSpec: a,
+	spec, err := p.spec.spec(id, args)
+	if err != nil {
+		logf(ctx, "Failure building spec: %s\n", err)
+		return subcommands.ExitFailure
 	}
 
-	marshalled_spec, _ := ptypes.MarshalAny(wrapped)
+	p.secrets.mount(spec)
+
+	if err := p.spec.mutate(spec); err != nil {
+		logf(ctx, "%s\n", err)
+		return subcommands.ExitFailure
+	}
 
 	var rootFSMount types.Mount
 	if err := json.Unmarshal([]byte(p.rootFSConfig), &rootFSMount); err != nil {
-		log.Printf("Failure parsing RootFS JSON config: %s\n", err)
+		logf(ctx, "Failure parsing RootFS JSON config: %s\n", err)
 		return subcommands.ExitFailure
 	}
 
-	req := &shim.CreateTaskRequest{
-		ID:     id,
-		Bundle: p.bundle,
-		Rootfs: []*types.Mount{&rootFSMount},
-		Options: &anypb.Any{
-			TypeUrl: "type.googleapis.com/ExtraData",
-			Value:   marshalled_spec.Value,
-		},
+	req, err := newCreateTaskRequest(id, p.bundle, spec, &rootFSMount, &proto.ExtraData{})
+	if err != nil {
+		logf(ctx, "%s\n", err)
+		return subcommands.ExitFailure
 	}
 
-	client, cleanup := client.New(uint32(p.cid), uint32(p.port))
-	defer cleanup()
-
-	res := &shim.CreateTaskResponse{}
+	var pid uint32
 
-	err := client.Call(ctx, serviceName, createMethodName, req, res)
+	if p.idempotent {
+		pid, err = p.createIdempotent(ctx, req)
+	} else {
+		pid, err = p.create(ctx, req, false)
+	}
 
 	if err != nil {
-		log.Printf("Failure in create call: %s\n", err)
+		logf(ctx, "Failure in create call: %s\n", err)
 		return subcommands.ExitFailure
 	}
 
-	log.Printf("Create call successfull, started with PID: %d...\n", res.Pid)
+	if len(p.secrets) > 0 {
+		if err := p.pushSecrets(ctx, id); err != nil {
+			logf(ctx, "Failure pushing secrets: %s\n", err)
+			return subcommands.ExitFailure
+		}
+	}
+
+	recordContainer(ctx, &p.baseCmd, id, labelsOf(spec))
+
+	result := &createResult{
+		ID:  id,
+		Pid: pid,
+	}
+
+	if p.verify {
+		diffs, err := p.verifySpec(ctx, req)
+		if err != nil {
+			logf(ctx, "Failure verifying spec: %s\n", err)
+			return subcommands.ExitFailure
+		}
+
+		for _, diff := range diffs {
+			logf(ctx, "Agent changed %s: sent %q, effective %q\n", diff.Field, diff.Sent, diff.Effective)
+		}
+
+		result.SpecDifferences = diffs
+	}
+
+	if err := p.report(ctx, result, "Create call successfull, started with PID: %d...\n", pid); err != nil {
+		logf(ctx, "Failure reporting result: %s\n", err)
+		return subcommands.ExitFailure
+	}
+
+	if err := p.ids.emit(id); err != nil {
+		logf(ctx, "%s\n", err)
+		return subcommands.ExitFailure
+	}
 
 	return subcommands.ExitSuccess
 }
+
+// pushSecrets pushes the secrets into container id, which is deleted again if
+// that fails so it isn't started without them.
+func (p *CreateCmd) pushSecrets(ctx context.Context, id string) error {
+	client, cleanup, err := p.newClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	if err := p.secrets.push(ctx, &p.baseCmd, client, id); err != nil {
+		if _, err := deleteContainer(ctx, client, id); err != nil {
+			logf(ctx, "Failure deleting container %s: %s\n", id, err)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// newCreateTaskRequest builds the request creating container id from spec.
+// The spec is added to wrapped, which carries the IO ports if any.
+func newCreateTaskRequest(id, bundle string, spec *specs.Spec, rootfs *types.Mount, wrapped *proto.ExtraData) (*shim.CreateTaskRequest, error) {
+	a, err := json.Marshal(spec)
+	if err != nil {
+		return nil, fmt.Errorf("marshalling spec: %w", err)
+	}
+
+	// Firecracker agent expects the spec to be wrapped in ExtraData
+	wrapped.RuncOptions = &anypb.Any{
+		TypeUrl: "",
+		Value:   a,
+	}
+	wrapped.JsonSpec = a
+
+	options, err := marshalExtraData(wrapped)
+	if err != nil {
+		return nil, err
+	}
+
+	return &shim.CreateTaskRequest{
+		ID:      id,
+		Bundle:  bundle,
+		Rootfs:  []*types.Mount{rootfs},
+		Options: options,
+	}, nil
+}
+
+// marshalExtraData packs data into the Any of the Create and Exec requests.
+func marshalExtraData(data *proto.ExtraData) (*anypb.Any, error) {
+	marshalled, err := ptypes.MarshalAny(data)
+	if err != nil {
+		return nil, fmt.Errorf("marshalling ExtraData: %w", err)
+	}
+
+	return &anypb.Any{
+		// force TypeUrl as we're using a different proto impl
+		TypeUrl: "type.googleapis.com/ExtraData",
+		Value:   marshalled.Value,
+	}, nil
+}
+
+// create dials the agent and creates the container. With checkState, the
+// agent is first asked whether the container already exists, in which case
+// its PID is returned without creating it again.
+func (p *CreateCmd) create(ctx context.Context, req *shim.CreateTaskRequest, checkState bool) (uint32, error) {
+	client, cleanup, err := p.newClient(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer cleanup()
+
+	if checkState {
+		stateReq := &shim.StateRequest{
+			ID: req.ID,
+		}
+
+		stateRes := &shim.StateResponse{}
+
+		if err := client.Call(ctx, serviceName, stateMethodName, stateReq, stateRes); err == nil {
+			logf(ctx, "Container %s already exists, the earlier create went through\n", req.ID)
+			return stateRes.Pid, nil
+		}
+	}
+
+	res := &shim.CreateTaskResponse{}
+
+	if err := client.Call(ctx, serviceName, createMethodName, req, res); err != nil {
+		return 0, err
+	}
+
+	return res.Pid, nil
+}
+
+// createIdempotent retries create on transient failures. The response of a
+// create that went through can get lost on the vsock link, so retries check
+// whether the container exists before creating it again.
+func (p *CreateCmd) createIdempotent(ctx context.Context, req *shim.CreateTaskRequest) (uint32, error) {
+	var lastErr error
+
+	for attempt := 0; attempt <= p.retries; attempt++ {
+		if attempt > 0 {
+			logf(ctx, "Retrying create after transient failure: %s\n", lastErr)
+
+			select {
+			case <-time.After(createRetryBackoff << (attempt - 1)):
+			case <-ctx.Done():
+				return 0, ctx.Err()
+			}
+		}
+
+		pid, err := p.create(ctx, req, attempt > 0)
+		if err == nil {
+			return pid, nil
+		}
+
+		if ctx.Err() != nil || !isTransient(err) {
+			return 0, err
+		}
+
+		lastErr = err
+	}
+
+	return 0, lastErr
+}
+
+// isTransient reports whether err may go away on retry: failures of the
+// connection, and the agent's own transient ones. Other errors without a
+// status are the client's, like -strict or an admission hook refusing,
+// retrying them would only hide them.
+func isTransient(err error) bool {
+	if s, ok := status.FromError(err); ok {
+		switch s.Code() {
+		case codes.Unavailable, codes.DeadlineExceeded, codes.Aborted:
+			return true
+		default:
+			return false
+		}
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) ||
+		errors.Is(err, ttrpc.ErrClosed) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE)
+}
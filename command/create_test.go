@@ -0,0 +1,41 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/containerd/ttrpc"
+	"github.com/dehydr8/firecracker-containerd-agent-client/client"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"unavailable", status.Error(codes.Unavailable, "agent busy"), true},
+		{"deadline", status.Error(codes.DeadlineExceeded, "timeout"), true},
+		{"aborted", status.Error(codes.Aborted, "aborted"), true},
+		{"already exists", status.Error(codes.AlreadyExists, "exists"), false},
+		{"invalid argument", status.Error(codes.InvalidArgument, "bad spec"), false},
+		{"dial", &net.OpError{Op: "dial", Net: "vsock", Err: syscall.ECONNREFUSED}, true},
+		{"closed", fmt.Errorf("calling Create: %w", ttrpc.ErrClosed), true},
+		{"reset", fmt.Errorf("reading: %w", syscall.ECONNRESET), true},
+		{"strict", &client.UnknownFieldsError{}, false},
+		{"admission", errors.New("admission hook refused the request"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isTransient(tt.err); got != tt.want {
+				t.Errorf("isTransient(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
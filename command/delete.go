@@ -0,0 +1,277 @@
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	shim "github.com/containerd/containerd/api/runtime/task/v2"
+	"github.com/containerd/containerd/api/types/task"
+	"github.com/containerd/ttrpc"
+	"github.com/google/subcommands"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	killMethodName   = "Kill"
+	deleteMethodName = "Delete"
+)
+
+type DeleteCmd struct {
+	baseCmd
+
+	containerId string
+	manifest    string
+	filters     labelFilters
+	yes         bool
+}
+
+func (*DeleteCmd) Name() string     { return "delete" }
+func (*DeleteCmd) Synopsis() string { return "Kill and delete containers" }
+func (*DeleteCmd) Usage() string {
+	return `delete [-yes] -container_id id | -f manifest.yaml | -filter label=key[=value]...:
+	Kill and delete the container, or the containers of the manifest in the
+	reverse of the order apply creates them in.
+
+	With -filter, the containers list shows with the same filters are
+	deleted concurrently. Failing to delete some doesn't stop the others,
+	how each went is printed, and the command fails if any did.
+  `
+}
+
+func (p *DeleteCmd) SetFlags(f *flag.FlagSet) {
+	p.baseCmd.SetFlags(f)
+	f.StringVar(&p.containerId, "container_id", "", "Container ID")
+	f.StringVar(&p.manifest, "f", "", "Manifest file")
+	f.Var(&p.filters, "filter", "Delete the recorded containers with the label key, set to value if given, as label=key[=value] (repeatable)")
+	f.BoolVar(&p.yes, "yes", false, "Don't ask for confirmation")
+}
+
+func (p *DeleteCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	ctx, cancel := p.context(ctx)
+	defer cancel()
+
+	var ids []string
+	bulk := false
+
+	switch {
+	case len(p.containerId) > 0:
+		containerId, err := resolveContainerID(ctx, &p.baseCmd, p.containerId)
+		if err != nil {
+			logf(ctx, "%s\n", err)
+			return subcommands.ExitFailure
+		}
+		ids = []string{containerId}
+	case len(p.manifest) > 0:
+		m, err := loadManifest(p.manifest)
+		if err != nil {
+			logf(ctx, "Failure loading manifest: %s\n", err)
+			return subcommands.ExitFailure
+		}
+
+		containers, err := m.ordered()
+		if err != nil {
+			logf(ctx, "Failure ordering manifest: %s\n", err)
+			return subcommands.ExitFailure
+		}
+
+		// dependents go first
+		for i := len(containers) - 1; i >= 0; i-- {
+			ids = append(ids, containers[i].ID)
+		}
+	case len(p.filters) > 0:
+		store := storeFrom(ctx)
+		if store == nil {
+			logf(ctx, "No -store to select containers from")
+			return subcommands.ExitFailure
+		}
+
+		containers, err := store.list(p.target(), p.filters)
+		if err != nil {
+			logf(ctx, "Failure reading %s: %s\n", store.path, err)
+			return subcommands.ExitFailure
+		}
+
+		if len(containers) <= 0 {
+			logf(ctx, "No containers match the filters\n")
+			return subcommands.ExitSuccess
+		}
+
+		for _, c := range containers {
+			ids = append(ids, c.ID)
+		}
+		bulk = true
+	default:
+		logf(ctx, "No container ID, manifest or filter defined")
+		return subcommands.ExitFailure
+	}
+
+	for _, method := range []string{killMethodName, deleteMethodName} {
+		if err := checkReadOnly(ctx, serviceName, method); err != nil {
+			logf(ctx, "%s\n", err)
+			return subcommands.ExitFailure
+		}
+	}
+
+	if !p.yes {
+		ok, err := p.confirmTarget(p.Name(), fmt.Sprintf("kill and delete %s %s", plural(len(ids), "container"), strings.Join(ids, ", ")))
+		if err != nil {
+			logf(ctx, "Failure asking for confirmation: %s\n", err)
+			return subcommands.ExitFailure
+		}
+
+		if !ok {
+			logf(ctx, "Aborted\n")
+			return subcommands.ExitFailure
+		}
+	}
+
+	client, cleanup, err := p.newClient(ctx)
+	if err != nil {
+		logf(ctx, "Failure creating client: %s\n", err)
+		return subcommands.ExitFailure
+	}
+	defer cleanup()
+
+	if bulk {
+		return p.deleteMany(ctx, client, ids)
+	}
+
+	for _, id := range ids {
+		if _, err := deleteContainer(ctx, client, id); err != nil {
+			logf(ctx, "Failure deleting container %s: %s\n", id, err)
+			return subcommands.ExitFailure
+		}
+		forgetContainer(ctx, &p.baseCmd, id)
+	}
+
+	return subcommands.ExitSuccess
+}
+
+// deleteOutcome is how deleting one of the containers of -filter went.
+type deleteOutcome struct {
+	ContainerID string `json:"container_id"`
+	Deleted     bool   `json:"deleted"`
+	Error       string `json:"error,omitempty"`
+}
+
+// deleteMany deletes the containers ids concurrently and reports how each
+// went, failing if any couldn't be deleted.
+func (p *DeleteCmd) deleteMany(ctx context.Context, client *ttrpc.Client, ids []string) subcommands.ExitStatus {
+	outcomes := deleteAll(ctx, client, ids)
+
+	failed := 0
+	for _, o := range outcomes {
+		if o.Deleted {
+			forgetContainer(ctx, &p.baseCmd, o.ContainerID)
+		} else {
+			failed++
+		}
+	}
+
+	printed, err := p.printExtract(outcomes)
+	if err != nil {
+		logf(ctx, "Failure extracting from result: %s\n", err)
+		return subcommands.ExitFailure
+	}
+
+	if !printed && p.output == outputJSON {
+		out, err := json.Marshal(outcomes)
+		if err != nil {
+			logf(ctx, "Failure reporting result: %s\n", err)
+			return subcommands.ExitFailure
+		}
+		fmt.Fprintln(os.Stdout, string(out))
+	} else if !printed {
+		for _, o := range outcomes {
+			if o.Deleted {
+				fmt.Printf("%s\tdeleted\n", o.ContainerID)
+			} else {
+				fmt.Printf("%s\t%s\n", o.ContainerID, o.Error)
+			}
+		}
+	}
+
+	logf(ctx, "Deleted %d of %d %s\n", len(outcomes)-failed, len(outcomes), plural(len(outcomes), "container"))
+
+	if failed > 0 {
+		return subcommands.ExitFailure
+	}
+	return subcommands.ExitSuccess
+}
+
+// deleteAll deletes the containers ids concurrently, going on past the
+// ones that fail. The outcomes are in the order of ids.
+func deleteAll(ctx context.Context, client *ttrpc.Client, ids []string) []*deleteOutcome {
+	outcomes := make([]*deleteOutcome, len(ids))
+
+	done := make(chan struct{}, len(ids))
+	for i, id := range ids {
+		outcomes[i] = &deleteOutcome{ContainerID: id}
+
+		go func(o *deleteOutcome) {
+			defer func() { done <- struct{}{} }()
+
+			if _, err := deleteContainer(ctx, client, o.ContainerID); err != nil {
+				logf(ctx, "Failure deleting container %s: %s\n", o.ContainerID, err)
+				o.Error = err.Error()
+				return
+			}
+			o.Deleted = true
+		}(outcomes[i])
+	}
+
+	for range ids {
+		<-done
+	}
+
+	return outcomes
+}
+
+// plural is noun, made plural unless n is 1.
+func plural(n int, noun string) string {
+	if n == 1 {
+		return noun
+	}
+	return noun + "s"
+}
+
+// deleteContainer kills the container if it is still running and deletes it,
+// returning how its init process ended. Containers that don't exist are
+// already where we want them, they have no response.
+func deleteContainer(ctx context.Context, client *ttrpc.Client, id string) (*shim.DeleteResponse, error) {
+	stateReq := &shim.StateRequest{
+		ID: id,
+	}
+
+	stateRes := &shim.StateResponse{}
+
+	if err := client.Call(ctx, serviceName, stateMethodName, stateReq, stateRes); err != nil {
+		if status.Code(err) == codes.NotFound {
+			logf(ctx, "Container %s does not exist\n", id)
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if stateRes.Status != task.Status_STOPPED {
+		logf(ctx, "Killing container: %s\n", id)
+
+		if _, err := killAndWait(ctx, client, id); err != nil {
+			return nil, err
+		}
+	}
+
+	res := &shim.DeleteResponse{}
+	if err := client.Call(ctx, serviceName, deleteMethodName, &shim.DeleteRequest{ID: id}, res); err != nil {
+		return nil, err
+	}
+
+	logf(ctx, "Container %s deleted\n", id)
+
+	return res, nil
+}
@@ -0,0 +1,64 @@
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+)
+
+// deprecatedFlag is a flag kept working for a while after it was replaced,
+// so that scripts using it get warned instead of broken.
+type deprecatedFlag struct {
+	// Command is the subcommand the flag belongs to, "*" for all of them
+	Command     string `json:"command"`
+	Flag        string `json:"flag"`
+	Replacement string `json:"replacement,omitempty"`
+	Message     string `json:"message,omitempty"`
+}
+
+// deprecations lists the deprecated flags still accepted. A flag that is
+// replaced goes here and stays defined, on the same value as its
+// replacement, until it is dropped.
+var deprecations = []deprecatedFlag{}
+
+// deprecationWarning is written to stderr, one JSON object per line with
+// -output json.
+type deprecationWarning struct {
+	Warning string `json:"warning"`
+	deprecatedFlag
+}
+
+// warnDeprecated warns about the deprecated flags set in f for the
+// subcommand name, on the logger of ctx, or as JSON lines to w with
+// -output json.
+func warnDeprecated(ctx context.Context, w io.Writer, name string, f *flag.FlagSet) {
+	asJSON := false
+	if fl := f.Lookup("output"); fl != nil {
+		asJSON = fl.Value.String() == outputJSON
+	}
+
+	f.Visit(func(fl *flag.Flag) {
+		for _, d := range deprecations {
+			if (d.Command != name && d.Command != "*") || d.Flag != fl.Name {
+				continue
+			}
+
+			if asJSON {
+				out, _ := json.Marshal(&deprecationWarning{Warning: "deprecated_flag", deprecatedFlag: d})
+				fmt.Fprintln(w, string(out))
+				continue
+			}
+
+			msg := fmt.Sprintf("Warning: -%s is deprecated", d.Flag)
+			if len(d.Replacement) > 0 {
+				msg += fmt.Sprintf(", use -%s instead", d.Replacement)
+			}
+			if len(d.Message) > 0 {
+				msg += fmt.Sprintf(" (%s)", d.Message)
+			}
+			logf(ctx, "%s\n", msg)
+		}
+	})
+}
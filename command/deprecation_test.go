@@ -0,0 +1,80 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"testing"
+
+	"github.com/dehydr8/firecracker-containerd-agent-client/util"
+)
+
+func TestWarnDeprecated(t *testing.T) {
+	saved := deprecations
+	deprecations = []deprecatedFlag{{
+		Command:     "exec",
+		Flag:        "priv",
+		Replacement: "privileged",
+		Message:     "says what it grants",
+	}}
+	t.Cleanup(func() { deprecations = saved })
+
+	tests := []struct {
+		name    string
+		cmd     string
+		args    []string
+		wantLog string
+		wantOut string
+	}{
+		{
+			name:    "text",
+			cmd:     "exec",
+			args:    []string{"-priv"},
+			wantLog: "Warning: -priv is deprecated, use -privileged instead (says what it grants)\n",
+		},
+		{
+			name:    "json",
+			cmd:     "exec",
+			args:    []string{"-priv", "-output", "json"},
+			wantOut: `{"warning":"deprecated_flag","command":"exec","flag":"priv","replacement":"privileged","message":"says what it grants"}` + "\n",
+		},
+		{
+			name: "not set",
+			cmd:  "exec",
+			args: []string{"-output", "json"},
+		},
+		{
+			name: "other command",
+			cmd:  "create",
+			args: []string{"-priv"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logged, out bytes.Buffer
+			ctx := util.WithLogger(context.Background(), log.New(&logged, "", 0))
+
+			f := flag.NewFlagSet(tt.cmd, flag.ContinueOnError)
+			f.Bool("priv", false, "")
+			f.String("output", outputText, "")
+			if err := f.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+
+			warnDeprecated(ctx, &out, tt.cmd, f)
+
+			if logged.String() != tt.wantLog {
+				t.Errorf("logged %q, want %q", logged.String(), tt.wantLog)
+			}
+			if out.String() != tt.wantOut {
+				t.Errorf("wrote %q, want %q", out.String(), tt.wantOut)
+			}
+			if len(tt.wantOut) > 0 && !json.Valid(out.Bytes()) {
+				t.Errorf("wrote invalid JSON %q", out.String())
+			}
+		})
+	}
+}
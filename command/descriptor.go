@@ -0,0 +1,132 @@
+package command
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// stringList collects the values of a repeated flag.
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// loadDescriptorSets reads compiled FileDescriptorSets, as written by
+// protoc --descriptor_set_out --include_imports. Imports found in none of
+// them are looked up among the protos compiled into this client.
+func loadDescriptorSets(paths []string) (*protoregistry.Files, error) {
+	set := &descriptorpb.FileDescriptorSet{}
+
+	for _, path := range paths {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		s := &descriptorpb.FileDescriptorSet{}
+		if err := proto.Unmarshal(b, s); err != nil {
+			return nil, fmt.Errorf("parsing descriptor set %s: %w", path, err)
+		}
+		set.File = append(set.File, s.File...)
+	}
+
+	files := &protoregistry.Files{}
+
+	// files have to be registered after their imports
+	pending := set.File
+	for len(pending) > 0 {
+		var next []*descriptorpb.FileDescriptorProto
+		var lastErr error
+
+		for _, fdp := range pending {
+			fd, err := protodesc.NewFile(fdp, resolver{files})
+			if err != nil {
+				next = append(next, fdp)
+				lastErr = err
+				continue
+			}
+			if err := files.RegisterFile(fd); err != nil {
+				return nil, err
+			}
+		}
+
+		if len(next) == len(pending) {
+			return nil, lastErr
+		}
+		pending = next
+	}
+
+	return files, nil
+}
+
+// resolver looks descriptors up in the loaded files first, then in the ones
+// compiled in.
+type resolver struct {
+	files *protoregistry.Files
+}
+
+func (r resolver) FindFileByPath(path string) (protoreflect.FileDescriptor, error) {
+	if fd, err := r.files.FindFileByPath(path); err == nil {
+		return fd, nil
+	}
+	return protoregistry.GlobalFiles.FindFileByPath(path)
+}
+
+func (r resolver) FindDescriptorByName(name protoreflect.FullName) (protoreflect.Descriptor, error) {
+	if d, err := r.files.FindDescriptorByName(name); err == nil {
+		return d, nil
+	}
+	return protoregistry.GlobalFiles.FindDescriptorByName(name)
+}
+
+// findMethod looks service/method up in files.
+func findMethod(files *protoregistry.Files, service, method string) (protoreflect.MethodDescriptor, error) {
+	d, err := files.FindDescriptorByName(protoreflect.FullName(service))
+	if err != nil {
+		return nil, fmt.Errorf("service %s not in the descriptor sets", service)
+	}
+
+	sd, ok := d.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a service", service)
+	}
+
+	md := sd.Methods().ByName(protoreflect.Name(method))
+	if md == nil {
+		return nil, fmt.Errorf("service %s has no method %s", service, method)
+	}
+
+	return md, nil
+}
+
+// dynamicPair builds the request and response of service/method from the
+// descriptors in files, for services this client wasn't compiled with.
+func dynamicPair(files *protoregistry.Files, service, method string) (Pair, error) {
+	md, err := findMethod(files, service, method)
+	if err != nil {
+		return Pair{}, err
+	}
+
+	if md.IsStreamingClient() || md.IsStreamingServer() {
+		return Pair{}, fmt.Errorf("%s/%s is a streaming method, use -stream", service, method)
+	}
+
+	return Pair{
+		req: dynamicpb.NewMessage(md.Input()),
+		res: dynamicpb.NewMessage(md.Output()),
+	}, nil
+}
@@ -0,0 +1,299 @@
+package command
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	shim "github.com/containerd/containerd/api/runtime/task/v2"
+	events "github.com/containerd/containerd/api/services/events/v1"
+	"github.com/containerd/ttrpc"
+	"github.com/dehydr8/firecracker-containerd-agent-client/proto"
+	"github.com/google/subcommands"
+	"google.golang.org/protobuf/encoding/protojson"
+	gproto "google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+const (
+	ioProxyServiceName     = "IOProxy"
+	ioProxyStateMethodName = "State"
+
+	// caps the events drained with -events, the bridge keeps queueing
+	// while a busy guest runs
+	maxDiagnoseEvents = 1000
+
+	// agents whose bridge holds GetEvent open while nothing is queued,
+	// instead of answering with an empty envelope, count as drained once a
+	// call takes this long
+	eventDrainTimeout = time.Second
+)
+
+// diagnoseSummary is the summary.json at the root of the bundle.
+type diagnoseSummary struct {
+	CreatedAt  time.Time         `json:"created_at"`
+	CID        int               `json:"cid"`
+	Containers []string          `json:"containers,omitempty"`
+	Events     int               `json:"events"`
+	Traces     int               `json:"traces"`
+	Errors     map[string]string `json:"errors,omitempty"`
+}
+
+type DiagnoseCmd struct {
+	baseCmd
+
+	containerIds stringList
+	bundle       string
+	events       bool
+}
+
+func (*DiagnoseCmd) Name() string     { return "diagnose" }
+func (*DiagnoseCmd) Synopsis() string { return "Collect a diagnostics bundle for issue reports" }
+func (*DiagnoseCmd) Usage() string {
+	return `diagnose -bundle bundle.tar.gz [-container_id id]... [-events]:
+	Write a tarball with the agent info, the task and IO proxy state of each
+	container and the RPC traces of the session. To include the RPCs of a
+	failing command, run it and diagnose with the same -record-rpc directory.
+  `
+}
+
+func (p *DiagnoseCmd) SetFlags(f *flag.FlagSet) {
+	p.baseCmd.SetFlags(f)
+	f.Var(&p.containerIds, "container_id", "Container to include the state of, can be repeated")
+	f.StringVar(&p.bundle, "bundle", "", "Tarball to write, -output is the report format like everywhere else")
+	f.BoolVar(&p.events, "events", false, "Drain the events queued on the event bridge into the bundle; they are lost to other consumers, like wait -via-events")
+}
+
+func (p *DiagnoseCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	ctx, cancel := p.context(ctx)
+	defer cancel()
+
+	if len(p.bundle) <= 0 {
+		logf(ctx, "No -bundle defined")
+		return subcommands.ExitFailure
+	}
+
+	// record the RPCs of diagnose itself, next to those of earlier
+	// commands when -record-rpc is given
+	globals := globalsFrom(ctx)
+	if len(globals.RecordRPC) <= 0 {
+		dir, err := os.MkdirTemp("", "diagnose")
+		if err != nil {
+			logf(ctx, "Failure creating trace directory: %s\n", err)
+			return subcommands.ExitFailure
+		}
+		defer os.RemoveAll(dir)
+		globals.RecordRPC = dir
+	}
+
+	for i, id := range p.containerIds {
+		containerId, err := resolveContainerID(ctx, &p.baseCmd, id)
+		if err != nil {
+			logf(ctx, "%s\n", err)
+			return subcommands.ExitFailure
+		}
+		p.containerIds[i] = containerId
+	}
+
+	client, cleanup, err := p.newClient(ctx)
+	if err != nil {
+		logf(ctx, "Failure creating client: %s\n", err)
+		return subcommands.ExitFailure
+	}
+	defer cleanup()
+
+	out, err := os.Create(p.bundle)
+	if err != nil {
+		logf(ctx, "Failure creating %s: %s\n", p.bundle, err)
+		return subcommands.ExitFailure
+	}
+	defer out.Close()
+
+	gz := gzip.NewWriter(out)
+	bundle := &bundleWriter{tw: tar.NewWriter(gz)}
+
+	summary := &diagnoseSummary{
+		CreatedAt:  time.Now().UTC(),
+		CID:        p.cid,
+		Containers: p.containerIds,
+		Errors:     map[string]string{},
+	}
+
+	// the exec probes need a container, without one only the agent is asked
+	info := &InfoCmd{baseCmd: p.baseCmd}
+	probes := len(p.containerIds) > 0 && checkReadOnly(ctx, serviceName, execMethodName) == nil
+	if len(p.containerIds) > 0 {
+		info.containerId = p.containerIds[0]
+	}
+	bundle.addJSON("info.json", info.collect(ctx, client, probes))
+
+	for _, id := range p.containerIds {
+		dir := path.Join("containers", id)
+
+		if state, err := taskState(ctx, client, id); err != nil {
+			summary.Errors[path.Join(dir, "state")] = err.Error()
+		} else {
+			bundle.addProto(path.Join(dir, "state.json"), state)
+		}
+
+		if state, err := ioProxyState(ctx, client, id); err != nil {
+			summary.Errors[path.Join(dir, "ioproxy")] = err.Error()
+		} else {
+			bundle.addProto(path.Join(dir, "ioproxy.json"), state)
+		}
+	}
+
+	if p.events {
+		envelopes, err := drainEvents(ctx, client)
+		if err != nil {
+			summary.Errors["events"] = err.Error()
+		}
+		for i, envelope := range envelopes {
+			bundle.addProto(path.Join("events", fmt.Sprintf("%04d.json", i+1)), envelope)
+		}
+		summary.Events = len(envelopes)
+	}
+
+	traces, err := filepath.Glob(filepath.Join(globals.RecordRPC, "*.json"))
+	if err != nil {
+		summary.Errors["traces"] = err.Error()
+	}
+	for _, trace := range traces {
+		b, err := os.ReadFile(trace)
+		if err != nil {
+			summary.Errors["traces"] = err.Error()
+			continue
+		}
+		bundle.add(path.Join("traces", filepath.Base(trace)), b)
+	}
+	summary.Traces = len(traces)
+
+	bundle.addJSON("summary.json", summary)
+
+	err = bundle.close()
+	if err == nil {
+		err = gz.Close()
+	}
+	if err != nil {
+		logf(ctx, "Failure writing %s: %s\n", p.bundle, err)
+		return subcommands.ExitFailure
+	}
+
+	logf(ctx, "Diagnostics written to %s\n", p.bundle)
+
+	return subcommands.ExitSuccess
+}
+
+func taskState(ctx context.Context, client *ttrpc.Client, id string) (*shim.StateResponse, error) {
+	req := &shim.StateRequest{
+		ID: id,
+	}
+
+	res := &shim.StateResponse{}
+
+	if err := client.Call(ctx, serviceName, stateMethodName, req, res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func ioProxyState(ctx context.Context, client *ttrpc.Client, id string) (*proto.StateResponse, error) {
+	req := &proto.StateRequest{
+		ID: id,
+	}
+
+	res := &proto.StateResponse{}
+
+	if err := client.Call(ctx, ioProxyServiceName, ioProxyStateMethodName, req, res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// drainEvents pulls events off the event bridge until it has none queued.
+func drainEvents(ctx context.Context, client *ttrpc.Client) ([]*events.Envelope, error) {
+	var envelopes []*events.Envelope
+
+	for len(envelopes) < maxDiagnoseEvents {
+		envelope := &events.Envelope{}
+
+		callCtx, cancel := context.WithTimeout(ctx, eventDrainTimeout)
+		err := client.Call(callCtx, eventServiceName, getEventMethodName, &emptypb.Empty{}, envelope)
+		cancel()
+
+		if err != nil && ctx.Err() == nil && errors.Is(callCtx.Err(), context.DeadlineExceeded) {
+			break
+		}
+		if err != nil {
+			return envelopes, err
+		}
+
+		if envelope.Event == nil {
+			break
+		}
+
+		envelopes = append(envelopes, envelope)
+	}
+
+	return envelopes, nil
+}
+
+// bundleWriter adds files to a tarball, keeping the first error so the
+// collection code doesn't have to check each one.
+type bundleWriter struct {
+	tw  *tar.Writer
+	err error
+}
+
+func (w *bundleWriter) add(name string, b []byte) {
+	if w.err != nil {
+		return
+	}
+
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(b)),
+		ModTime: time.Now(),
+	}
+
+	if w.err = w.tw.WriteHeader(hdr); w.err != nil {
+		return
+	}
+	_, w.err = w.tw.Write(b)
+}
+
+func (w *bundleWriter) addJSON(name string, v interface{}) {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		w.err = err
+		return
+	}
+	w.add(name, b)
+}
+
+func (w *bundleWriter) addProto(name string, m gproto.Message) {
+	b, err := protojson.MarshalOptions{Multiline: true}.Marshal(m)
+	if err != nil {
+		w.err = err
+		return
+	}
+	w.add(name, b)
+}
+
+func (w *bundleWriter) close() error {
+	if w.err != nil {
+		return w.err
+	}
+	return w.tw.Close()
+}
@@ -0,0 +1,235 @@
+package command
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/containerd/ttrpc"
+	"github.com/dehydr8/firecracker-containerd-agent-client/proto"
+	"github.com/google/subcommands"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// downResult is reported once down went through everything of the manifest,
+// Errors lists what it couldn't tear down.
+type downResult struct {
+	Containers []string `json:"containers"`
+	Drives     []string `json:"drives"`
+	VMStopped  bool     `json:"vm_stopped,omitempty"`
+	Errors     []string `json:"errors,omitempty"`
+}
+
+type DownCmd struct {
+	baseCmd
+
+	manifest string
+	stopVM   bool
+	yes      bool
+}
+
+func (*DownCmd) Name() string     { return "down" }
+func (*DownCmd) Synopsis() string { return "Tear down what up brought up from a manifest" }
+func (*DownCmd) Usage() string {
+	return `down -f manifest.yaml [-stop-vm] [-yes]:
+	Delete the containers of the manifest, dependents first, unmount its
+	drives and, with -stop-vm, run the stop command of its VM. A failure
+	doesn't stop the rest from being torn down, and what is already gone
+	is skipped, so running it again finishes the job.
+  `
+}
+
+func (p *DownCmd) SetFlags(f *flag.FlagSet) {
+	p.baseCmd.SetFlags(f)
+	f.StringVar(&p.manifest, "f", "", "Manifest file")
+	f.BoolVar(&p.stopVM, "stop-vm", false, "Run the stop command of the VM afterwards")
+	f.BoolVar(&p.yes, "yes", false, "Don't ask for confirmation")
+}
+
+func (*DownCmd) lateTarget() {}
+
+func (p *DownCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	ctx, cancel := p.context(ctx)
+	defer cancel()
+
+	if len(p.manifest) <= 0 {
+		logf(ctx, "No manifest defined")
+		return subcommands.ExitFailure
+	}
+
+	m, err := loadManifest(p.manifest)
+	if err != nil {
+		logf(ctx, "Failure loading manifest: %s\n", err)
+		return subcommands.ExitFailure
+	}
+
+	containers, err := m.ordered()
+	if err != nil {
+		logf(ctx, "Failure ordering manifest: %s\n", err)
+		return subcommands.ExitFailure
+	}
+
+	if p.stopVM && (m.VM == nil || len(m.VM.Stop) <= 0) {
+		logf(ctx, "The manifest has no stop command for the VM\n")
+		return subcommands.ExitFailure
+	}
+
+	for _, method := range []string{killMethodName, deleteMethodName} {
+		if err := checkReadOnly(ctx, serviceName, method); err != nil {
+			logf(ctx, "%s\n", err)
+			return subcommands.ExitFailure
+		}
+	}
+
+	if len(m.Drives) > 0 {
+		if err := checkReadOnly(ctx, driveMounterServiceName, unmountDriveMethodName); err != nil {
+			logf(ctx, "%s\n", err)
+			return subcommands.ExitFailure
+		}
+	}
+
+	if err := m.VM.locate(ctx, &p.baseCmd, f); err != nil {
+		logf(ctx, "%s\n", err)
+		return subcommands.ExitUsageError
+	}
+
+	// nothing to ask about when the manifest has nothing to tear down
+	if summary := p.summary(containers, m.Drives); !p.yes && len(summary) > 0 {
+		ok, err := p.confirmTarget(p.Name(), summary)
+		if err != nil {
+			logf(ctx, "Failure asking for confirmation: %s\n", err)
+			return subcommands.ExitFailure
+		}
+
+		if !ok {
+			logf(ctx, "Aborted\n")
+			return subcommands.ExitFailure
+		}
+	}
+
+	result := &downResult{
+		Containers: []string{},
+		Drives:     []string{},
+	}
+
+	fail := func(format string, args ...interface{}) {
+		msg := fmt.Sprintf(format, args...)
+		logf(ctx, "%s\n", msg)
+		result.Errors = append(result.Errors, msg)
+	}
+
+	client, cleanup, err := p.newClient(ctx)
+	if err == nil {
+		defer cleanup()
+		err = agentAnswers(ctx, client)
+	}
+
+	switch {
+	case err != nil && p.stopVM:
+		// the VM is stopped anyway, and whatever runs in it with it
+		logf(ctx, "Agent not answering, stopping the VM without tearing down inside it: %s\n", err)
+	case err != nil:
+		fail("Failure reaching the agent: %s", err)
+	default:
+		// dependents go first
+		for i := len(containers) - 1; i >= 0; i-- {
+			id := containers[i].ID
+			if _, err := deleteContainer(ctx, client, id); err != nil {
+				fail("Failure deleting container %s: %s", id, err)
+				continue
+			}
+			forgetContainer(ctx, &p.baseCmd, id)
+			result.Containers = append(result.Containers, id)
+		}
+
+		for i := len(m.Drives) - 1; i >= 0; i-- {
+			d := m.Drives[i]
+			if err := unmountDrive(ctx, client, d); err != nil {
+				fail("Failure unmounting drive %s: %s", d.ID, err)
+				continue
+			}
+			result.Drives = append(result.Drives, d.ID)
+		}
+	}
+
+	if p.stopVM {
+		logf(ctx, "Stopping the VM: %s\n", strings.Join(m.VM.Stop, " "))
+
+		cmd := exec.CommandContext(ctx, m.VM.Stop[0], m.VM.Stop[1:]...)
+		cmd.Stdout = os.Stderr
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			fail("Failure stopping the VM: %s", err)
+		} else {
+			result.VMStopped = true
+
+			// the containers went down with the VM
+			for _, c := range containers {
+				forgetContainer(ctx, &p.baseCmd, c.ID)
+			}
+		}
+	}
+
+	if err := p.report(ctx, result, "Down: %d containers, %d drives from %s, %d errors\n", len(result.Containers), len(result.Drives), p.manifest, len(result.Errors)); err != nil {
+		logf(ctx, "Failure reporting result: %s\n", err)
+		return subcommands.ExitFailure
+	}
+
+	if len(result.Errors) > 0 {
+		return subcommands.ExitFailure
+	}
+
+	return subcommands.ExitSuccess
+}
+
+// summary tells what down is about to tear down, for the confirmation.
+func (p *DownCmd) summary(containers []*manifestContainer, drives []*manifestDrive) string {
+	var steps []string
+
+	if len(containers) > 0 {
+		ids := make([]string, len(containers))
+		for i, c := range containers {
+			ids[i] = c.ID
+		}
+		steps = append(steps, "kill and delete "+plural(len(ids), "container")+" "+strings.Join(ids, ", "))
+	}
+
+	if len(drives) > 0 {
+		ids := make([]string, len(drives))
+		for i, d := range drives {
+			ids[i] = d.ID
+		}
+		steps = append(steps, "unmount "+plural(len(ids), "drive")+" "+strings.Join(ids, ", "))
+	}
+
+	if p.stopVM {
+		steps = append(steps, "stop the VM")
+	}
+
+	return strings.Join(steps, ", then ")
+}
+
+// unmountDrive has the agent unmount the drive, a drive that isn't mounted
+// is already where we want it.
+func unmountDrive(ctx context.Context, client *ttrpc.Client, d *manifestDrive) error {
+	req := &proto.UnmountDriveRequest{
+		DriveID: d.ID,
+	}
+
+	err := client.Call(ctx, driveMounterServiceName, unmountDriveMethodName, req, &emptypb.Empty{})
+	if status.Code(err) == codes.NotFound {
+		logf(ctx, "Drive %s not mounted\n", d.ID)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	logf(ctx, "Drive %s unmounted\n", d.ID)
+	return nil
+}
@@ -0,0 +1,84 @@
+package command
+
+import (
+	"context"
+	"flag"
+
+	"github.com/dehydr8/firecracker-containerd-agent-client/util"
+	"github.com/google/subcommands"
+)
+
+const (
+	driveFormatExt4     = "ext4"
+	driveFormatSquashfs = "squashfs"
+)
+
+// DriveCmd groups the commands working on drive images.
+type DriveCmd struct{}
+
+func (*DriveCmd) Name() string     { return "drive" }
+func (*DriveCmd) Synopsis() string { return "Work with drive images" }
+func (*DriveCmd) Usage() string {
+	return `drive create -dir dir -o image [-format ext4|squashfs]:
+	Build drive images on the host.
+  `
+}
+
+func (*DriveCmd) SetFlags(*flag.FlagSet) {}
+
+func (*DriveCmd) Execute(ctx context.Context, f *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	commander := subcommands.NewCommander(f, "drive")
+	commander.Register(&driveCreateCmd{}, "")
+	return commander.Execute(ctx, args...)
+}
+
+type driveCreateCmd struct {
+	dir    string
+	output string
+	format string
+	sizeMB int
+}
+
+func (*driveCreateCmd) Name() string     { return "create" }
+func (*driveCreateCmd) Synopsis() string { return "Build a drive image from a directory" }
+func (*driveCreateCmd) Usage() string {
+	return `create -dir dir -o image [-format ext4|squashfs] [-size-mb n]:
+	Build an ext4 or squashfs image holding the contents of dir, to attach
+	to the VM and use with -rootfs-config or -mounts-config.
+  `
+}
+
+func (p *driveCreateCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&p.dir, "dir", "", "Directory to copy into the image")
+	f.StringVar(&p.output, "o", "", "Path of the image to write")
+	f.StringVar(&p.format, "format", driveFormatExt4, "Filesystem of the image (ext4, squashfs)")
+	f.IntVar(&p.sizeMB, "size-mb", 1024, "Size of the ext4 image, squashfs images are sized to fit")
+}
+
+func (p *driveCreateCmd) Execute(ctx context.Context, _ *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	if len(p.dir) <= 0 || len(p.output) <= 0 {
+		logf(ctx, "Both -dir and -o are required")
+		return subcommands.ExitFailure
+	}
+
+	var err error
+
+	switch p.format {
+	case driveFormatExt4:
+		err = util.MakeExt4(p.dir, p.output, p.sizeMB)
+	case driveFormatSquashfs:
+		err = util.MakeSquashfs(p.dir, p.output)
+	default:
+		logf(ctx, "Unknown drive format: %s\n", p.format)
+		return subcommands.ExitFailure
+	}
+
+	if err != nil {
+		logf(ctx, "Failure creating drive image: %s\n", err)
+		return subcommands.ExitFailure
+	}
+
+	logf(ctx, "Drive image written to %s\n", p.output)
+
+	return subcommands.ExitSuccess
+}
@@ -0,0 +1,444 @@
+package command
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	events "github.com/containerd/containerd/api/services/events/v1"
+	"github.com/containerd/ttrpc"
+	"github.com/dehydr8/firecracker-containerd-agent-client/util"
+	"github.com/google/subcommands"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// eventRecord is an envelope of the event bridge as events prints it, one
+// JSON line each, and as -sink stores it. Events of types compiled into this
+// client are decoded into Event, the others are kept as is in Value.
+type eventRecord struct {
+	Timestamp time.Time       `json:"timestamp"`
+	Namespace string          `json:"namespace,omitempty"`
+	Topic     string          `json:"topic"`
+	TypeURL   string          `json:"type_url,omitempty"`
+	Event     json.RawMessage `json:"event,omitempty"`
+	Value     []byte          `json:"value,omitempty"`
+}
+
+func newEventRecord(envelope *events.Envelope) *eventRecord {
+	record := &eventRecord{
+		Timestamp: envelope.Timestamp.AsTime(),
+		Namespace: envelope.Namespace,
+		Topic:     envelope.Topic,
+	}
+
+	if envelope.Event == nil {
+		return record
+	}
+
+	record.TypeURL = envelope.Event.TypeUrl
+
+	if event, err := envelope.Event.UnmarshalNew(); err == nil {
+		if out, err := (protojson.MarshalOptions{UseProtoNames: true}).Marshal(event); err == nil {
+			record.Event = out
+			return record
+		}
+	}
+
+	record.Value = envelope.Event.Value
+	return record
+}
+
+// containerID is the container the event is about, if it names one.
+func (r *eventRecord) containerID() string {
+	var event struct {
+		ContainerID string `json:"container_id"`
+	}
+
+	if len(r.Event) > 0 && json.Unmarshal(r.Event, &event) == nil {
+		return event.ContainerID
+	}
+	return ""
+}
+
+// eventFilter selects records by topic, which may be a path.Match pattern
+// like /tasks/*, by container or by namespace.
+type eventFilter struct {
+	key   string
+	value string
+}
+
+const (
+	eventFilterTopic     = "topic"
+	eventFilterContainer = "container"
+	eventFilterNamespace = "namespace"
+)
+
+// eventFilters collects repeated -filter key=value flags, a record has to
+// match all of them.
+type eventFilters []eventFilter
+
+func (ef *eventFilters) String() string {
+	filters := make([]string, 0, len(*ef))
+	for _, f := range *ef {
+		filters = append(filters, f.key+"="+f.value)
+	}
+	return strings.Join(filters, ",")
+}
+
+func (ef *eventFilters) Set(value string) error {
+	key, val, ok := strings.Cut(value, "=")
+	if !ok {
+		return fmt.Errorf("expected key=value, got %q", value)
+	}
+
+	switch key {
+	case eventFilterTopic:
+		if _, err := path.Match(val, ""); err != nil {
+			return fmt.Errorf("invalid topic pattern %q: %w", val, err)
+		}
+	case eventFilterContainer, eventFilterNamespace:
+	default:
+		return fmt.Errorf("unknown filter %s, expected topic, container or namespace", key)
+	}
+
+	*ef = append(*ef, eventFilter{key: key, value: val})
+	return nil
+}
+
+func (ef eventFilters) match(r *eventRecord) bool {
+	for _, f := range ef {
+		var ok bool
+
+		switch f.key {
+		case eventFilterTopic:
+			ok, _ = path.Match(f.value, r.Topic)
+		case eventFilterContainer:
+			ok = r.containerID() == f.value
+		case eventFilterNamespace:
+			ok = r.Namespace == f.value
+		}
+
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+type EventsCmd struct {
+	baseCmd
+
+	filters eventFilters
+	sink    string
+	journal string
+	replay  string
+	drain   bool
+	count   int
+	buffer  int
+	pollMax time.Duration
+	hooks   notifyHooks
+}
+
+func (*EventsCmd) Name() string     { return "events" }
+func (*EventsCmd) Synopsis() string { return "Print the events of the event bridge" }
+func (*EventsCmd) Usage() string {
+	return `events [-filter key=value]... [-sink file.ndjson] [-journal file] [-drain] [-count n] [-buffer bytes]
+      [-on-exit cmd] [-on-oom cmd]:
+	Pull events off the event bridge and print them as they come, one line
+	each, until interrupted. Pulling an event takes it away from other
+	consumers, like wait -via-events. With -sink, every event pulled is also
+	appended to the file, whether it passes the filters or not.
+
+	Every event is written out as soon as it is pulled. With -buffer, output
+	is held until the buffer fills or the bridge has nothing queued, fewer
+	writes for bursts of events.
+
+	With -journal, every event pulled is numbered and appended to the
+	journal before it is printed, the numbers going on from the session
+	before. Sessions are marked in the journal, and at start events reports
+	what earlier ones consumed, and whether the last one died, when an event
+	it pulled may be lost.
+
+	-on-exit and -on-oom run a command on the host with sh -c for every
+	/tasks/exit and /tasks/oom event passing the filters, once it is
+	printed and before the next event is pulled. The event is in the
+	environment: FC_EVENT is exit or oom, FC_EVENT_JSON the event, and
+	each of its fields is FC_ and its name in upper case, FC_CONTAINER_ID,
+	FC_EXIT_STATUS and so on. The output of the command goes to stderr.
+
+events -replay file.ndjson [-filter key=value]...:
+	Print the events saved by -sink or -journal instead of pulling them.
+
+	Filters are topic=pattern, as in path.Match like /tasks/*,
+	container=id and namespace=ns.
+  `
+}
+
+func (p *EventsCmd) SetFlags(f *flag.FlagSet) {
+	p.baseCmd.SetFlags(f)
+	f.Var(&p.filters, "filter", "Only print events matching topic=pattern, container=id or namespace=ns (repeatable)")
+	f.StringVar(&p.sink, "sink", "", "Append every event pulled to this file, as JSON lines")
+	f.StringVar(&p.journal, "journal", "", "Append every event pulled to this journal, numbered across sessions")
+	f.StringVar(&p.replay, "replay", "", "Print the events of a -sink or -journal file instead of pulling them from the agent")
+	f.BoolVar(&p.drain, "drain", false, "Stop once the event bridge has nothing queued instead of waiting for more")
+	f.IntVar(&p.count, "count", 0, "Stop after printing this many events, 0 for no limit")
+	f.IntVar(&p.buffer, "buffer", 0, "Bytes of output held until the bridge has nothing queued, 0 to write every event out as it comes")
+	f.DurationVar(&p.pollMax, "event-poll-max-interval", defaultEventPollInterval, "Longest pause between event bridge polls while no events come")
+	p.hooks.SetFlags(f)
+}
+
+func (p *EventsCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	ctx, cancel := p.context(ctx)
+	defer cancel()
+
+	// interrupting is how events stops, the output and journal are closed
+	// cleanly then
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if p.buffer < 0 {
+		logf(ctx, "-buffer can't be negative\n")
+		return subcommands.ExitUsageError
+	}
+
+	out := newRecordWriter(os.Stdout, p.buffer)
+	defer out.flush()
+
+	if len(p.replay) > 0 {
+		if len(p.sink) > 0 || len(p.journal) > 0 || p.drain || len(p.hooks.onExit) > 0 || len(p.hooks.onOOM) > 0 {
+			logf(ctx, "-replay can't be used with -sink, -journal, -drain, -on-exit or -on-oom\n")
+			return subcommands.ExitUsageError
+		}
+
+		if err := p.replayFile(ctx, out); err != nil {
+			logf(ctx, "Failure replaying %s: %s\n", p.replay, err)
+			return subcommands.ExitFailure
+		}
+		return subcommands.ExitSuccess
+	}
+
+	// left a nil interface without -sink, pull checks it
+	var sink io.Writer
+	if len(p.sink) > 0 {
+		file, err := os.OpenFile(p.sink, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			logf(ctx, "Failure opening sink: %s\n", err)
+			return subcommands.ExitFailure
+		}
+		defer file.Close()
+		sink = file
+	}
+
+	client, cleanup, err := p.newClient(ctx)
+	if err != nil {
+		logf(ctx, "Failure creating client: %s\n", err)
+		return subcommands.ExitFailure
+	}
+	defer cleanup()
+
+	var journal *eventJournal
+	if len(p.journal) > 0 {
+		var summary *journalSummary
+		if journal, summary, err = openJournal(p.journal); err != nil {
+			logf(ctx, "Failure opening journal %s: %s\n", p.journal, err)
+			return subcommands.ExitFailure
+		}
+		reportJournal(ctx, p.journal, summary)
+	}
+
+	err = p.pull(ctx, client, journal, sink, out)
+	clean := err == nil || (errors.Is(err, context.Canceled) && ctx.Err() != nil)
+
+	if journal != nil {
+		if closeErr := journal.close(clean); closeErr != nil {
+			logf(ctx, "Failure closing journal %s: %s\n", p.journal, closeErr)
+			return subcommands.ExitFailure
+		}
+	}
+
+	if !clean {
+		logf(ctx, "Failure pulling events: %s\n", err)
+		return subcommands.ExitFailure
+	}
+
+	return subcommands.ExitSuccess
+}
+
+// reportJournal logs what earlier sessions left in the journal.
+func reportJournal(ctx context.Context, path string, summary *journalSummary) {
+	if summary.Sessions <= 0 {
+		logf(ctx, "Journal %s is new\n", path)
+		return
+	}
+
+	if summary.Events <= 0 {
+		logf(ctx, "Journal %s: no events consumed in %d sessions\n", path, summary.Sessions)
+	} else {
+		logf(ctx, "Journal %s: %d events consumed in %d sessions, the last #%d at %s\n",
+			path, summary.Events, summary.Sessions, summary.LastSeq, summary.LastAt.Format(time.RFC3339Nano))
+	}
+
+	if summary.Unclean {
+		logf(ctx, "Journal %s: the last session died, an event it pulled after #%d may be lost\n", path, summary.LastSeq)
+	}
+	if len(summary.Gaps) > 0 {
+		logf(ctx, "Journal %s: events missing: %s\n", path, strings.Join(summary.Gaps, ", "))
+	}
+}
+
+// pull prints the events of the event bridge as they come, until ctx is
+// done, or the bridge is drained with -drain. Like diagnose, a bridge
+// holding GetEvent open counts as having nothing queued once a call takes
+// eventDrainTimeout, which is when buffered output is flushed.
+func (p *EventsCmd) pull(ctx context.Context, client *ttrpc.Client, journal *eventJournal, sink io.Writer, out *recordWriter) error {
+	backoff := util.NewBackoff(eventPollMinInterval, p.pollMax)
+	printed := 0
+
+	for p.count <= 0 || printed < p.count {
+		envelope := &events.Envelope{}
+
+		callCtx, cancel := context.WithTimeout(ctx, eventDrainTimeout)
+		err := client.Call(callCtx, eventServiceName, getEventMethodName, &emptypb.Empty{}, envelope)
+		cancel()
+
+		heldOpen := err != nil && ctx.Err() == nil && errors.Is(callCtx.Err(), context.DeadlineExceeded)
+		if err != nil && !heldOpen {
+			return err
+		}
+
+		if envelope.Event == nil {
+			if err := out.flush(); err != nil {
+				return err
+			}
+			if p.drain {
+				return nil
+			}
+			// a held call waited already
+			if heldOpen {
+				continue
+			}
+			if err := backoff.Wait(ctx); err != nil {
+				return err
+			}
+			continue
+		}
+
+		backoff.Reset()
+
+		record := newEventRecord(envelope)
+
+		if journal != nil {
+			if err := journal.add(record); err != nil {
+				return fmt.Errorf("writing to journal: %w", err)
+			}
+		}
+
+		if sink != nil {
+			if err := writeEventRecord(sink, record); err != nil {
+				return fmt.Errorf("writing to sink: %w", err)
+			}
+		}
+
+		if !p.filters.match(record) {
+			continue
+		}
+
+		if err := p.print(out, record); err != nil {
+			return err
+		}
+		printed++
+
+		switch record.Topic {
+		case taskExitEventTopic:
+			p.notify(ctx, out, notifyExit, record)
+		case taskOOMEventTopic:
+			p.notify(ctx, out, notifyOOM, record)
+		}
+	}
+
+	return nil
+}
+
+// notify runs the hook of kind for the record, with the output printed so
+// far flushed first.
+func (p *EventsCmd) notify(ctx context.Context, out *recordWriter, kind string, record *eventRecord) {
+	if err := out.flush(); err != nil {
+		logf(ctx, "Failure flushing output: %s\n", err)
+	}
+	p.hooks.notify(ctx, &p.baseCmd, kind, record.Event)
+}
+
+// replayFile prints the records of a -sink or -journal file that match the
+// filters, skipping the session markers of journals.
+func (p *EventsCmd) replayFile(ctx context.Context, out *recordWriter) error {
+	file, err := os.Open(p.replay)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, maxEventLineSize)
+
+	printed := 0
+	for line := 1; scanner.Scan() && (p.count <= 0 || printed < p.count); line++ {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		entry, err := parseJournalEntry(scanner.Bytes())
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+
+		if len(entry.Marker) > 0 {
+			continue
+		}
+
+		record := entry.eventRecord
+
+		if !p.filters.match(record) {
+			continue
+		}
+
+		if err := p.print(out, record); err != nil {
+			return err
+		}
+		printed++
+	}
+
+	return scanner.Err()
+}
+
+func (p *EventsCmd) print(out *recordWriter, record *eventRecord) error {
+	event := string(record.Event)
+	if len(record.Event) <= 0 {
+		event = record.TypeURL
+	}
+
+	return p.printRecord(out, record, fmt.Sprintf("%s %s %s", record.Timestamp.Format(time.RFC3339Nano), record.Topic, event))
+}
+
+// maxEventLineSize bounds the lines -replay reads, events carry little.
+const maxEventLineSize = 1024 * 1024
+
+func writeEventRecord(w io.Writer, record *eventRecord) error {
+	out, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(append(out, '\n'))
+	return err
+}
@@ -3,23 +3,19 @@ package command
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
-	"math"
-	"math/rand"
 	"os"
+	"regexp"
+	"sync/atomic"
 	"time"
 
 	shim "github.com/containerd/containerd/api/runtime/task/v2"
-	"github.com/dehydr8/firecracker-containerd-agent-client/client"
 	"github.com/dehydr8/firecracker-containerd-agent-client/proto"
 	"github.com/dehydr8/firecracker-containerd-agent-client/util"
-	"github.com/gogo/protobuf/types"
 	"github.com/google/subcommands"
-	"github.com/google/uuid"
 	"github.com/opencontainers/runtime-spec/specs-go"
-	"github.com/sirupsen/logrus"
 	"golang.org/x/term"
 	"google.golang.org/protobuf/types/known/anypb"
 	"google.golang.org/protobuf/types/known/emptypb"
@@ -29,29 +25,55 @@ const (
 	serviceName     = "containerd.task.v2.Task"
 	execMethodName  = "Exec"
 	startMethodName = "Start"
+	waitMethodName  = "Wait"
 
-	minVsockIOPort = uint32(12000)
+	stallActionWarn  = "warn"
+	stallActionAbort = "abort"
+
+	defaultMaskPrompt = `(?i)(password|passphrase|secret|token)[^:\n]*: *$`
 )
 
 type ExecCmd struct {
-	cid         int
-	port        int
+	baseCmd
+
+	args argFlags
+
+	templates    string
+	template     string
+	templateVars templateVars
+
 	containerId string
 	execId      string
+	execName    string
+	ids         idFlags
 	cwd         string
+	stdin       string
 	stdout      string
 	stderr      string
 	tty         bool
+	ttyFlag     ttyFlag
+	forceTty    bool
+	splitStderr bool
 	io          bool
 	uid         int
 	gid         int
 	priv        bool
-}
-
-func (s *ExecCmd) randomVSockPorts() (uint32, uint32, uint32) {
-	p := rand.Int31n(int32(math.MaxInt32) - int32(minVsockIOPort) - 3)
-	p += int32(minVsockIOPort)
-	return uint32(p), uint32(p + 1), uint32(p + 2)
+	ioDrain     time.Duration
+	stdinBuffer int
+	keepalive   time.Duration
+	ioSerialize bool
+	ioTags      bool
+	ioStall     time.Duration
+	stallAction string
+	killAfter   time.Duration
+	keepStdin   bool
+	mutator     string
+	maxOutput   int64
+	stripANSI   bool
+	term        string
+	termLocale  bool
+	maskInput   bool
+	maskPrompt  string
 }
 
 func (*ExecCmd) Name() string     { return "exec" }
@@ -63,33 +85,180 @@ func (*ExecCmd) Usage() string {
 }
 
 func (p *ExecCmd) SetFlags(f *flag.FlagSet) {
-	f.IntVar(&p.cid, "cid", 0, "Vsock Context ID")
-	f.IntVar(&p.port, "port", 10789, "Vsock Port")
+	p.baseCmd.SetFlags(f)
+	p.args.SetFlags(f)
+	p.templateVars = templateVars{}
+	f.StringVar(&p.templates, "templates", "", "File of named command templates")
+	f.StringVar(&p.template, "template", "", "Run the named command of the -templates file, followed by the arguments")
+	f.Var(p.templateVars, "var", "Value of a template variable, as name=value (repeatable)")
 	f.StringVar(&p.containerId, "container_id", "", "Container ID")
 	f.StringVar(&p.execId, "exec_id", "", "Execution ID")
-	f.StringVar(&p.stdout, "stdout", "", "Standard Output")
-	f.StringVar(&p.stderr, "stderr", "", "Standard Error")
-	f.BoolVar(&p.tty, "tty", false, "Terminal")
+	p.ids.SetFlags(f)
+	f.StringVar(&p.execName, "exec-name", "", "Friendly name used as the execution ID, refused while a process of that name runs")
+	f.StringVar(&p.stdin, "stdin", "", "Host fifo to read Standard Input from (fifo:///path), or a file to follow like tail -F (tail:///path)")
+	f.StringVar(&p.stdout, "stdout", "", "Standard Output, or a host fifo to bridge it to or file to append it to (fifo:///path, append:///path)")
+	f.StringVar(&p.stderr, "stderr", "", "Standard Error, or a host fifo to bridge it to or file to append it to (fifo:///path, append:///path)")
+	p.ttyFlag = ttyFlag{auto: true}
+	f.Var(&p.ttyFlag, "tty", "Terminal: true, false, or auto for one when -io runs with stdin and stdout on a terminal")
+	f.BoolVar(&p.splitStderr, "split-stderr", false, "With -tty, proxy stderr on its own port to -stderr instead of the terminal, for agents that keep it apart")
+	f.BoolVar(&p.forceTty, "force-tty", false, "Keep -tty when stdout isn't a terminal, instead of running without one")
 	f.BoolVar(&p.io, "io", false, "IO Proxy")
+	f.StringVar(&p.mutator, "spec-mutator", "", "Program the process spec JSON is piped through before it is used, given \"process\" as argument; it rejects the spec by failing")
+	f.BoolVar(&p.keepStdin, "stdin-keep-open", false, "With -io, keep the stdin of the process open once the host's ends, until it exits or close-io is run")
+	f.DurationVar(&p.killAfter, "kill-after", 0, "With -io and -timeout, stop the process on the timeout: SIGTERM, SIGKILL once it outlived this, then a forced delete")
 	f.IntVar(&p.uid, "uid", 0, "User")
 	f.IntVar(&p.gid, "gid", 0, "Group")
 	f.StringVar(&p.cwd, "cwd", "/", "Current working directory")
 	f.BoolVar(&p.priv, "priv", false, "All Capabilities")
+	f.DurationVar(&p.ioDrain, "io-drain-timeout", util.DefaultIOFlushTimeout, "Time to wait for IO to drain after the process exits")
+	f.IntVar(&p.stdinBuffer, "stdin-buffer", 0, "Bytes of stdin to read ahead of the process, 0 to disable")
+	f.DurationVar(&p.keepalive, "keepalive-interval", 0, "Interval of keepalive calls to detect a dead agent, 0 to disable")
+	f.BoolVar(&p.ioSerialize, "io-serialize", false, "Write stdout and stderr a whole line at a time so they don't garble each other")
+	f.BoolVar(&p.ioTags, "io-tags", false, "Prefix lines with the stream they come from, with -io-serialize")
+	f.DurationVar(&p.ioStall, "io-stall-timeout", 0, "Report IO as stalled once no bytes moved for this long, 0 to disable")
+	f.StringVar(&p.stallAction, "io-stall-action", stallActionAbort, "What to do about stalled IO (warn, abort)")
+	f.Int64Var(&p.maxOutput, "max-output-bytes", 0, "Bytes of stdout and stderr each to pass on before truncating, 0 for no limit")
+	f.StringVar(&p.term, "term", "", "TERM of the -tty session, the host's if empty")
+	f.BoolVar(&p.termLocale, "term-locale", false, "Pass the host's locale and COLORTERM on to the -tty session")
+	f.BoolVar(&p.maskInput, "mask-input", false, "Turn off the echo of the local terminal while answering a prompt for a secret, without -tty")
+	f.StringVar(&p.maskPrompt, "mask-prompt", defaultMaskPrompt, "Regex matching the end of the output that prompts for a secret, with -mask-input")
+	f.BoolVar(&p.stripANSI, "strip-ansi", false, "Remove terminal escape sequences from stdout and stderr, without -tty")
 }
 
 func (p *ExecCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	ctx, cancel := p.context(ctx)
+	defer cancel()
+
 	if len(p.containerId) <= 0 {
-		log.Printf("No container ID defined")
+		logf(ctx, "No container ID defined")
+		return subcommands.ExitFailure
+	}
+
+	containerId, err := resolveContainerID(ctx, &p.baseCmd, p.containerId)
+	if err != nil {
+		logf(ctx, "%s\n", err)
 		return subcommands.ExitFailure
 	}
+	p.containerId = containerId
 
-	if len(f.Args()) <= 0 {
-		log.Printf("No command defined")
+	args, err := p.args.args(f.Args())
+	if err != nil {
+		logf(ctx, "%s\n", err)
 		return subcommands.ExitFailure
 	}
 
+	if len(p.template) > 0 {
+		if len(p.templates) <= 0 {
+			logf(ctx, "-template needs a -templates file\n")
+			return subcommands.ExitFailure
+		}
+
+		templates, err := loadTemplates(p.templates)
+		if err != nil {
+			logf(ctx, "Failure loading templates: %s\n", err)
+			return subcommands.ExitFailure
+		}
+
+		cmdline, err := expandTemplate(templates, p.template, p.templateVars)
+		if err != nil {
+			logf(ctx, "%s\n", err)
+			return subcommands.ExitFailure
+		}
+
+		args = append(cmdline, args...)
+	}
+
+	if len(args) <= 0 {
+		logf(ctx, "No command defined")
+		return subcommands.ExitFailure
+	}
+
+	if p.stallAction != stallActionWarn && p.stallAction != stallActionAbort {
+		logf(ctx, "Unknown IO stall action: %s\n", p.stallAction)
+		return subcommands.ExitFailure
+	}
+
+	p.tty = p.ttyFlag.value
+	if p.ttyFlag.auto {
+		p.tty = p.autoTty()
+	}
+
+	// a terminal session only makes sense on a terminal, in CI and with
+	// redirected output the process runs as if -tty wasn't given
+	if p.tty && !p.forceTty && !term.IsTerminal(int(os.Stdout.Fd())) {
+		logf(ctx, "stdout isn't a terminal, running without -tty, -force-tty keeps it\n")
+		p.tty = false
+	}
+
+	if p.stripANSI && p.tty {
+		logf(ctx, "-strip-ansi can't be used with -tty\n")
+		return subcommands.ExitFailure
+	}
+
+	if p.maskInput && p.tty {
+		logf(ctx, "-mask-input can't be used with -tty, the container's terminal echoes\n")
+		return subcommands.ExitFailure
+	}
+
+	// without a terminal stderr has its own port anyway
+	if p.splitStderr && p.tty && !bridged(p.stderr) {
+		logf(ctx, "-split-stderr needs -stderr to name a host fifo or file (fifo:///path, append:///path)\n")
+		return subcommands.ExitFailure
+	}
+
+	maskPrompt, err := regexp.Compile(p.maskPrompt)
+	if err != nil {
+		logf(ctx, "Failure parsing mask prompt: %s\n", err)
+		return subcommands.ExitFailure
+	}
+
+	if err := checkReadOnly(ctx, serviceName, execMethodName); err != nil {
+		logf(ctx, "%s\n", err)
+		return subcommands.ExitFailure
+	}
+
+	if len(p.execName) > 0 {
+		if len(p.execId) > 0 {
+			logf(ctx, "-exec-name and -exec_id can't be used together\n")
+			return subcommands.ExitFailure
+		}
+
+		if err := validateExecName(p.execName); err != nil {
+			logf(ctx, "%s\n", err)
+			return subcommands.ExitFailure
+		}
+
+		p.execId = p.execName
+	}
+
 	if len(p.execId) <= 0 {
-		p.execId = uuid.NewString()
+		p.execId = p.ids.generate(&p.baseCmd, args)
+	}
+
+	// host fifos and files are bridged to the guest through the IO proxy
+	for _, uri := range []string{p.stdin, p.stdout, p.stderr} {
+		if bridged(uri) {
+			p.io = true
+		}
+	}
+
+	// without -io the process isn't waited on, so there's no deadline to
+	// enforce
+	if p.killAfter > 0 && (!p.io || p.timeout <= 0) {
+		logf(ctx, "-kill-after needs -io and -timeout\n")
+		return subcommands.ExitFailure
+	}
+
+	if p.keepStdin && !p.io {
+		logf(ctx, "-stdin-keep-open needs -io\n")
+		return subcommands.ExitFailure
+	}
+
+	// the output of the process goes to stdout unless bridged to a host file
+	stdoutFIFO := bridged(p.stdout)
+	if err := p.ids.check(&p.baseCmd, p.io && !stdoutFIFO); err != nil {
+		logf(ctx, "%s\n", err)
+		return subcommands.ExitFailure
 	}
 
 	if len(p.stdout) <= 0 {
@@ -100,7 +269,7 @@ func (p *ExecCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...interface{}
 		p.stderr = fmt.Sprintf("file:///tmp/%s.stderr", p.execId)
 	}
 
-	log.Printf("Execution ID: %s\n", p.execId)
+	logf(ctx, "Execution ID: %s\n", p.execId)
 
 	caps := defaultUnixCaps()
 
@@ -113,7 +282,7 @@ func (p *ExecCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...interface{}
 			UID: uint32(p.uid),
 			GID: uint32(p.gid),
 		},
-		Args: f.Args(),
+		Args: args,
 		Cwd:  p.cwd,
 		Capabilities: &specs.LinuxCapabilities{
 			Bounding:  caps,
@@ -124,12 +293,39 @@ func (p *ExecCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...interface{}
 	}
 
 	if p.tty {
-		cmd.Env = append(cmd.Env, "TERM=xterm")
+		cmd.Env = append(cmd.Env, util.TermEnv(p.term, p.termLocale)...)
+
+		// the bytes go to the host terminal as they are, so the guest has
+		// to have the same charset for them to show right
+		if locale := util.HostLocale(); p.termLocale && len(locale) > 0 && !util.IsUTF8Locale(locale) {
+			logf(ctx, "Host locale %s isn't UTF-8, the container needs it installed\n", locale)
+		}
 	}
 
-	a, _ := json.Marshal(cmd)
+	if len(p.mutator) > 0 {
+		if err := runMutator(p.mutator, mutateProcess, cmd); err != nil {
+			logf(ctx, "%s\n", err)
+			return subcommands.ExitFailure
+		}
+	}
+
+	a, err := json.Marshal(cmd)
+	if err != nil {
+		logf(ctx, "Failure marshalling process spec: %s\n", err)
+		return subcommands.ExitFailure
+	}
 
-	stdinPort, stdoutPort, stderrPort := p.randomVSockPorts()
+	stdinPort, stdoutPort, stderrPort, err := p.vsockPorts()
+	if err != nil {
+		logf(ctx, "%s\n", err)
+		return subcommands.ExitFailure
+	}
+
+	// like containerd, a terminal session has no stderr of its own unless
+	// the agent is asked to keep it apart
+	if p.tty && !p.splitStderr {
+		stderrPort = 0
+	}
 
 	// Firecracker agent expects the spec to be wrapped in ExtraData
 	spec := &proto.ExtraData{
@@ -142,34 +338,55 @@ func (p *ExecCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...interface{}
 		StderrPort: stderrPort,
 	}
 
-	marshalled_spec, _ := types.MarshalAny(spec)
+	marshalled_spec, err := marshalExtraData(spec)
+	if err != nil {
+		logf(ctx, "%s\n", err)
+		return subcommands.ExitFailure
+	}
 
 	req := &shim.ExecProcessRequest{
 		ID:       p.containerId,
 		ExecID:   p.execId,
 		Terminal: p.tty,
-		Spec: &anypb.Any{
-			// force TypeUrl as we're using a different proto impl
-			TypeUrl: "type.googleapis.com/ExtraData",
-			Value:   marshalled_spec.Value,
-		},
-		Stdout: p.stdout,
-		Stderr: p.stderr,
+		Spec:     marshalled_spec,
+		Stdout:   p.stdout,
+		Stderr:   p.stderr,
 	}
 
 	if p.io {
-		req.Stdin = uuid.NewString()
-		req.Stdout = uuid.NewString()
-		req.Stderr = uuid.NewString()
+		req.Stdin = p.newID()
+		req.Stdout = p.newID()
+		if stderrPort != 0 {
+			req.Stderr = p.newID()
+		}
 	}
 
-	client, cleanup := client.New(uint32(p.cid), uint32(p.port))
+	client, cleanup, err := p.newClient(ctx)
+	if err != nil {
+		logf(ctx, "Failure creating client: %s\n", err)
+		return subcommands.ExitFailure
+	}
 	defer cleanup()
 
+	if len(p.execName) > 0 {
+		if err := claimExecName(ctx, client, p.containerId, p.execName); err != nil {
+			logf(ctx, "Failure claiming exec name: %s\n", err)
+			return subcommands.ExitFailure
+		}
+	}
+
+	keepAlive(ctx, client, p.containerId, p.keepalive, cancel)
+
 	res := &emptypb.Empty{}
 
 	execCallError := make(chan error)
 	var copyDone <-chan error
+	var stalled atomic.Bool
+
+	// procCtx is cancelled once the process exits, which gives the IO streams
+	// ioDrain to flush before the proxy closes them
+	procCtx, procCancel := context.WithCancel(ctx)
+	defer procCancel()
 
 	go func() {
 		err := client.Call(ctx, serviceName, execMethodName, req, res)
@@ -180,59 +397,122 @@ func (p *ExecCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...interface{}
 	time.Sleep(1 * time.Second)
 
 	if p.io {
-		proxy := util.NewIOConnectorProxy(
-			&util.IOConnectorPair{
-				ReadConnector:  util.FileConnector(os.Stdin),
-				WriteConnector: util.VSockDialConnector(uint32(p.cid), spec.StdinPort),
-			},
+		stdinConnector := hostConnector(p.stdin, os.Stdin, os.O_RDONLY)
+		if p.stdinBuffer > 0 {
+			stdinConnector = util.BufferedReadConnector(stdinConnector, p.stdinBuffer)
+		}
+
+		var detector *util.StallDetector
+		if p.ioStall > 0 {
+			detector = util.NewStallDetector()
+		}
+
+		// guestConnector connects to an IO port of the process
+		guestConnector := func(port uint32) util.IOConnector {
+			connector := p.ioConnector(ctx, port)
+			if detector != nil {
+				connector = detector.Connector(connector)
+			}
+			return connector
+		}
+
+		var serializer *util.LineSerializer
+		if p.ioSerialize {
+			serializer = util.NewLineSerializer()
+		}
+
+		stdoutConnector := p.captureConnector(serializer, p.stdout, os.Stdout, "stdout")
+
+		// a fifo or file doesn't echo, only the terminal on stdin does
+		if p.maskInput && !bridged(p.stdin) {
+			if fd, ok := util.GetFd(os.Stdin); ok {
+				masker := util.NewInputMasker(maskPrompt, fd, os.Stdout)
+				defer masker.Restore()
+
+				stdinConnector = masker.InputConnector(stdinConnector)
+				stdoutConnector = masker.OutputConnector(stdoutConnector)
+			}
+		}
+
+		var stderrPair *util.IOConnectorPair
+		if spec.StderrPort != 0 {
+			stderrPair = &util.IOConnectorPair{
+				ReadConnector:  guestConnector(spec.StderrPort),
+				WriteConnector: p.captureConnector(serializer, p.stderr, os.Stderr, "stderr"),
+			}
+		}
+
+		guestStdin := guestConnector(spec.StdinPort)
+		if p.keepStdin {
+			guestStdin = util.KeepOpenConnector(procCtx, guestStdin)
+		}
+
+		proxy := util.NewIOConnectorProxyWithFlushTimeout(
 			&util.IOConnectorPair{
-				ReadConnector:  util.VSockDialConnector(uint32(p.cid), spec.StdoutPort),
-				WriteConnector: util.FileConnector(os.Stdout),
+				ReadConnector:  stdinConnector,
+				WriteConnector: guestStdin,
 			},
 			&util.IOConnectorPair{
-				ReadConnector:  util.VSockDialConnector(uint32(p.cid), spec.StderrPort),
-				WriteConnector: util.FileConnector(os.Stderr),
+				ReadConnector:  guestConnector(spec.StdoutPort),
+				WriteConnector: stdoutConnector,
 			},
+			stderrPair,
+			p.ioDrain,
 		)
 
-		logger := logrus.New()
+		logger := util.ProxyLogger(ctx)
 
-		initDone, xcopyDone := proxy.Start(ctx, logger)
+		initDone, xcopyDone := proxy.Start(procCtx, logger)
 
 		copyDone = xcopyDone
 
 		err := <-initDone
 		if err != nil {
-			log.Printf("Failure starting IOProxy: %s\n", err)
+			logf(ctx, "Failure starting IOProxy: %s\n", err)
 			return subcommands.ExitFailure
 		}
 
-		log.Printf("Proxy attached...\n")
+		logf(ctx, "Proxy attached...\n")
+
+		if detector != nil {
+			go detector.Watch(procCtx, p.ioStall, func(idle time.Duration) {
+				logf(ctx, "No IO for %s, the connection to the agent may be hung\n", idle.Round(time.Second))
+				if p.stallAction == stallActionAbort {
+					stalled.Store(true)
+					cancel()
+				}
+			})
+		}
 	}
 
-	err := <-execCallError
+	err = <-execCallError
 
 	if err != nil {
-		log.Printf("Failure in exec call: %s\n", err)
+		logf(ctx, "Failure in exec call: %s\n", err)
 		return subcommands.ExitFailure
 	}
 
-	log.Printf("Exec call successfull, starting process...\n")
+	logf(ctx, "Exec call successfull, starting process...\n")
 
-	var termFd int
+	termFd := -1
 
 	if p.tty {
 		if fd, ok := util.GetFd(os.Stdin); ok {
 			termFd = fd
 			state, err := term.MakeRaw(fd)
 			if err != nil {
-				log.Printf("Failure making terminal: %s\n", err)
+				logf(ctx, "Failure making terminal: %s\n", err)
 				return subcommands.ExitFailure
 			}
 
 			defer term.Restore(fd, state)
 
-			go util.WatchWindowSize(ctx, fd, p.containerId, p.execId, client)
+			stopWatch := util.WatchWindowSize(ctx, fd, p.containerId, p.execId, client)
+			defer func() {
+				if err := stopWatch(); err != nil {
+					logf(ctx, "Failure resizing terminal: %s\n", err)
+				}
+			}()
 		}
 	}
 
@@ -246,25 +526,172 @@ func (p *ExecCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...interface{}
 	err = client.Call(ctx, serviceName, startMethodName, startReq, startRes)
 
 	if err != nil {
-		log.Printf("Failure in start call: %s\n", err)
+		logf(ctx, "Failure in start call: %s\n", err)
 		return subcommands.ExitFailure
 	}
 
-	log.Printf("Command executed with PID: %d\n", startRes.Pid)
+	logf(ctx, "Command executed with PID: %d\n", startRes.Pid)
 
-	if p.tty {
+	if err := p.ids.emit(p.execId); err != nil {
+		logf(ctx, "%s\n", err)
+		return subcommands.ExitFailure
+	}
+
+	if p.tty && termFd >= 0 {
 		// update the initial terminal size
-		width, height, _ := term.GetSize(termFd)
-		err = util.ResizePty(ctx, p.containerId, p.execId, width, height, client)
+		if width, height, err := util.TermSize(termFd); err == nil {
+			util.ResizePty(ctx, p.containerId, p.execId, width, height, client)
+		}
 	}
 
 	if p.io {
+		// the copy may end before the wait call does, the wait is called
+		// off then instead of failing on the client closed after it
+		waitCtx, waitCancel := context.WithCancel(ctx)
+		defer waitCancel()
+
+		go func() {
+			waitReq := &shim.WaitRequest{
+				ID:     p.containerId,
+				ExecID: p.execId,
+			}
+
+			waitRes := &shim.WaitResponse{}
+
+			if err := client.Call(waitCtx, serviceName, waitMethodName, waitReq, waitRes); err != nil {
+				if waitCtx.Err() == nil {
+					logf(ctx, "Failure in wait call: %s\n", err)
+				}
+				return
+			}
+
+			logf(ctx, "Process exited with status: %d\n", waitRes.ExitStatus)
+			procCancel()
+		}()
+
+		// the process is stopped as soon as the timeout passes, the IO
+		// proxy may take longer to let go of stdin
+		stopped := make(chan error, 1)
+		if p.killAfter > 0 {
+			go func() {
+				<-ctx.Done()
+				if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+					return
+				}
+
+				logf(ctx, "Timeout of %s passed, stopping process %s\n", p.timeout, p.execId)
+
+				killCtx, killCancel := context.WithTimeout(context.WithoutCancel(ctx), killWaitTimeout+2*p.killAfter)
+				defer killCancel()
+
+				exit, err := terminate(killCtx, client, p.containerId, p.execId, p.killAfter)
+				if err == nil {
+					logf(ctx, "Process exited with status: %d\n", exit.ExitStatus)
+				}
+				stopped <- err
+			}()
+		}
+
 		err = <-copyDone
+
+		if p.killAfter > 0 && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			if err := <-stopped; err != nil {
+				logf(ctx, "Failure stopping process: %s\n", err)
+			}
+			return subcommands.ExitFailure
+		}
+
+		if stalled.Load() {
+			logf(ctx, "Aborted on stalled IO\n")
+			return subcommands.ExitFailure
+		}
+
 		if err != nil {
-			log.Printf("Failure in IOProxy: %s\n", err)
+			logf(ctx, "Failure in IOProxy: %s\n", err)
 			return subcommands.ExitFailure
 		}
 	}
 
 	return subcommands.ExitSuccess
 }
+
+// autoTty is what -tty=auto comes to: a terminal for an -io session whose
+// stdin and stdout are the host's terminal, unless asked for filtering that
+// only works without one.
+func (p *ExecCmd) autoTty() bool {
+	if !p.io || p.stripANSI || p.maskInput {
+		return false
+	}
+
+	if len(p.stdin) > 0 || len(p.stdout) > 0 {
+		return false
+	}
+
+	return term.IsTerminal(int(os.Stdin.Fd())) && term.IsTerminal(int(os.Stdout.Fd()))
+}
+
+// bridged tells whether uri names a host fifo or file the IO proxy bridges
+// the stream to.
+func bridged(uri string) bool {
+	_, fifo := util.FIFOPath(uri)
+	_, tail := util.TailPath(uri)
+	_, appended := util.AppendPath(uri)
+	return fifo || tail || appended
+}
+
+// hostConnector opens the host fifo named by uri, follows the file for stdin
+// or appends to it for output, if it names one, and otherwise connects to
+// file.
+func hostConnector(uri string, file *os.File, flag int) util.IOConnector {
+	if path, ok := util.FIFOPath(uri); ok {
+		return util.FIFOConnector(path, flag)
+	}
+	if path, ok := util.TailPath(uri); ok && flag == os.O_RDONLY {
+		return util.TailFileConnector(path)
+	}
+	if path, ok := util.AppendPath(uri); ok && flag == os.O_WRONLY {
+		return util.AppendFileConnector(path)
+	}
+	return util.FileConnector(file)
+}
+
+// captureConnector is outputConnector with the output filtered and limited
+// as asked for.
+func (p *ExecCmd) captureConnector(serializer *util.LineSerializer, uri string, file *os.File, name string) util.IOConnector {
+	return filterOutput(p.outputConnector(serializer, uri, file, name), p.stripANSI, p.maxOutput)
+}
+
+// filterOutput strips escape sequences from what is written through
+// connector, if stripANSI is set, and truncates it after limit bytes, unless
+// limit is 0.
+func filterOutput(connector util.IOConnector, stripANSI bool, limit int64) util.IOConnector {
+	connector = limitOutput(connector, limit)
+	if stripANSI {
+		connector = util.StripANSIConnector(connector)
+	}
+	return connector
+}
+
+// limitOutput truncates what is written through connector after limit bytes,
+// unless limit is 0.
+func limitOutput(connector util.IOConnector, limit int64) util.IOConnector {
+	if limit <= 0 {
+		return connector
+	}
+	return util.LimitedConnector(connector, limit)
+}
+
+// outputConnector is hostConnector for stdout and stderr, which go through
+// serializer, when set, unless they are bridged to a fifo.
+func (p *ExecCmd) outputConnector(serializer *util.LineSerializer, uri string, file *os.File, name string) util.IOConnector {
+	if bridged(uri) || serializer == nil {
+		return hostConnector(uri, file, os.O_WRONLY)
+	}
+
+	tag := ""
+	if p.ioTags {
+		tag = "[" + name + "] "
+	}
+
+	return serializer.Connector(file, tag)
+}
@@ -0,0 +1,54 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	shim "github.com/containerd/containerd/api/runtime/task/v2"
+	"github.com/containerd/containerd/api/types/task"
+	"github.com/containerd/ttrpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// execNamePattern is the identifier format containerd accepts for exec IDs,
+// so a name is used as the exec ID as is and later calls can refer to it
+// with -exec_id.
+var execNamePattern = regexp.MustCompile(`^[A-Za-z0-9]+(?:[._-][A-Za-z0-9]+)*$`)
+
+const maxExecNameLength = 76
+
+func validateExecName(name string) error {
+	if len(name) > maxExecNameLength || !execNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid exec name %q, use letters, digits and single . _ - between them", name)
+	}
+	return nil
+}
+
+// claimExecName makes sure no process of the container goes by name. A
+// process still running under it is an error, an exited one is deleted so
+// the name can be taken again.
+func claimExecName(ctx context.Context, client *ttrpc.Client, containerId, name string) error {
+	stateReq := &shim.StateRequest{
+		ID:     containerId,
+		ExecID: name,
+	}
+
+	stateRes := &shim.StateResponse{}
+
+	if err := client.Call(ctx, serviceName, stateMethodName, stateReq, stateRes); err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil
+		}
+		return err
+	}
+
+	if stateRes.Status != task.Status_STOPPED {
+		return fmt.Errorf("exec %s is still running in container %s with PID %d", name, containerId, stateRes.Pid)
+	}
+
+	logf(ctx, "Deleting exited exec %s to reuse its name\n", name)
+
+	return deleteExec(ctx, client, containerId, name)
+}
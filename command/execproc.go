@@ -0,0 +1,203 @@
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"syscall"
+	"time"
+
+	shim "github.com/containerd/containerd/api/runtime/task/v2"
+	"github.com/containerd/ttrpc"
+	"github.com/dehydr8/firecracker-containerd-agent-client/proto"
+	"github.com/dehydr8/firecracker-containerd-agent-client/util"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// execProcess execs args in container id, waits for it to exit and deletes
+// it. stdin, if not nil, is fed to the process and its stdout, if not nil,
+// written to stdout. It is the plumbing of the commands that run helper
+// processes in a container, exec itself does more around it.
+func execProcess(ctx context.Context, b *baseCmd, client *ttrpc.Client, id string, args []string, stdin io.Reader, stdout io.Writer) (*waitResult, error) {
+	caps := defaultUnixCaps()
+
+	a, err := json.Marshal(&specs.Process{
+		Args: args,
+		Cwd:  "/",
+		Capabilities: &specs.LinuxCapabilities{
+			Bounding:  caps,
+			Permitted: caps,
+			Effective: caps,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	stdinPort, stdoutPort, _, err := b.vsockPorts()
+	if err != nil {
+		return nil, err
+	}
+
+	// Firecracker agent expects the spec to be wrapped in ExtraData
+	spec := &proto.ExtraData{
+		RuncOptions: &anypb.Any{
+			TypeUrl: "",
+			Value:   a,
+		},
+	}
+
+	execId := b.newID()
+
+	req := &shim.ExecProcessRequest{
+		ID:     id,
+		ExecID: execId,
+	}
+
+	var stdinPair, stdoutPair *util.IOConnectorPair
+
+	if stdin != nil {
+		spec.StdinPort = stdinPort
+		req.Stdin = b.newID()
+		stdinPair = &util.IOConnectorPair{
+			ReadConnector:  readerConnector(stdin),
+			WriteConnector: b.ioConnector(ctx, stdinPort),
+		}
+	}
+
+	if stdout != nil {
+		spec.StdoutPort = stdoutPort
+		req.Stdout = b.newID()
+		stdoutPair = &util.IOConnectorPair{
+			ReadConnector:  b.ioConnector(ctx, stdoutPort),
+			WriteConnector: writerConnector(stdout),
+		}
+	}
+
+	if req.Spec, err = marshalExtraData(spec); err != nil {
+		return nil, err
+	}
+
+	execCallError := make(chan error)
+	go func() {
+		execCallError <- client.Call(ctx, serviceName, execMethodName, req, &emptypb.Empty{})
+	}()
+
+	procCtx, procCancel := context.WithCancel(ctx)
+	defer procCancel()
+
+	var copyDone <-chan error
+
+	if stdinPair != nil || stdoutPair != nil {
+		// catch-22 in Exec, it won't finish until a connection is accepted for IOProxy
+		time.Sleep(1 * time.Second)
+
+		proxy := util.NewIOConnectorProxy(stdinPair, stdoutPair, nil)
+
+		var initDone <-chan error
+		initDone, copyDone = proxy.Start(procCtx, util.ProxyLogger(ctx))
+
+		if err := <-initDone; err != nil {
+			return nil, err
+		}
+	}
+
+	if err := <-execCallError; err != nil {
+		return nil, err
+	}
+
+	var started, exited bool
+
+	// helper processes would otherwise pile up in the agent, the exec is
+	// deleted whether it ran or not, on a context of its own in case ctx
+	// is what ended it. One still running can't be deleted, it is killed
+	// first.
+	defer func() {
+		deleteCtx, deleteCancel := context.WithTimeout(context.WithoutCancel(ctx), 2*killWaitTimeout)
+		defer deleteCancel()
+
+		if started && !exited {
+			if _, err := signalAndWait(deleteCtx, client, id, execId, syscall.SIGKILL, killWaitTimeout); err != nil {
+				logf(ctx, "Failure killing exec %s of container %s: %s\n", execId, id, err)
+			}
+		}
+
+		if err := deleteExec(deleteCtx, client, id, execId); err != nil {
+			logf(ctx, "Failure deleting exec %s of container %s: %s\n", execId, id, err)
+		}
+	}()
+
+	startReq := &shim.StartRequest{
+		ID:     id,
+		ExecID: execId,
+	}
+
+	if err := client.Call(ctx, serviceName, startMethodName, startReq, &shim.StartResponse{}); err != nil {
+		return nil, err
+	}
+	started = true
+
+	exit, err := waitForExit(ctx, client, id, execId)
+	if err != nil {
+		return nil, err
+	}
+	exited = true
+
+	if copyDone != nil {
+		procCancel()
+		if err := <-copyDone; err != nil {
+			return nil, err
+		}
+	}
+
+	return exit, nil
+}
+
+// deleteExec deletes the exited exec execId of container containerId.
+func deleteExec(ctx context.Context, client *ttrpc.Client, containerId, execId string) error {
+	req := &shim.DeleteRequest{
+		ID:     containerId,
+		ExecID: execId,
+	}
+
+	return client.Call(ctx, serviceName, deleteMethodName, req, &shim.DeleteResponse{})
+}
+
+// readerConnector connects to r, files keep the zero-copy path of
+// util.FileConnector.
+func readerConnector(r io.Reader) util.IOConnector {
+	if f, ok := r.(*os.File); ok {
+		return util.FileConnector(f)
+	}
+
+	return func(procCtx context.Context, logger *logrus.Entry) <-chan util.IOConnectorResult {
+		returnCh := make(chan util.IOConnectorResult, 1)
+		defer close(returnCh)
+
+		returnCh <- util.IOConnectorResult{
+			ReadWriteCloser: &util.ReadWriteNopCloserWrapper{
+				Reader: r,
+			},
+		}
+		return returnCh
+	}
+}
+
+// writerConnector connects to w, for output captured in memory.
+func writerConnector(w io.Writer) util.IOConnector {
+	return func(procCtx context.Context, logger *logrus.Entry) <-chan util.IOConnectorResult {
+		returnCh := make(chan util.IOConnectorResult, 1)
+		defer close(returnCh)
+
+		returnCh <- util.IOConnectorResult{
+			ReadWriteCloser: &util.ReadWriteNopCloserWrapper{
+				Writer: w,
+			},
+		}
+		return returnCh
+	}
+}
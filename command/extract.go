@@ -0,0 +1,168 @@
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// extractFlags pick single fields out of the result of a command, for
+// callers without jq at hand.
+type extractFlags struct {
+	jsonPath string
+	format   string
+}
+
+func (e *extractFlags) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&e.jsonPath, "jsonpath", "", "Print the fields of the result picked by a JSONPath like {.pid}")
+	f.StringVar(&e.format, "go-template", "", "Print the result through a Go template like {{.pid}}")
+}
+
+// extract renders v, in its JSON form, through -jsonpath or -go-template.
+// It returns false when neither was given.
+func (e *extractFlags) extract(v interface{}) (string, bool, error) {
+	if len(e.jsonPath) <= 0 && len(e.format) <= 0 {
+		return "", false, nil
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", true, err
+	}
+
+	// numbers are kept as written, not turned into floats
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+
+	var data interface{}
+	if err := decoder.Decode(&data); err != nil {
+		return "", true, err
+	}
+
+	if len(e.jsonPath) > 0 {
+		out, err := evalJSONPath(e.jsonPath, data)
+		return out, true, err
+	}
+
+	t, err := template.New("format").Option("missingkey=error").Parse(e.format)
+	if err != nil {
+		return "", true, err
+	}
+
+	var sb strings.Builder
+	if err := t.Execute(&sb, data); err != nil {
+		return "", true, err
+	}
+	return sb.String(), true, nil
+}
+
+// evalJSONPath supports the part of kubectl's JSONPath that picks fields:
+// text with {.field.sub[0]} expressions in it.
+func evalJSONPath(expr string, data interface{}) (string, error) {
+	var sb strings.Builder
+
+	for len(expr) > 0 {
+		start := strings.IndexByte(expr, '{')
+		if start < 0 {
+			sb.WriteString(expr)
+			break
+		}
+
+		end := strings.IndexByte(expr[start:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unclosed { in jsonpath")
+		}
+		end += start
+
+		sb.WriteString(expr[:start])
+
+		v, err := lookupPath(strings.TrimSpace(expr[start+1:end]), data)
+		if err != nil {
+			return "", err
+		}
+		sb.WriteString(jsonPathString(v))
+
+		expr = expr[end+1:]
+	}
+
+	return sb.String(), nil
+}
+
+// lookupPath walks data along a path like .a.b[0], the leading $ is
+// optional.
+func lookupPath(path string, data interface{}) (interface{}, error) {
+	path = strings.TrimPrefix(path, "$")
+
+	for len(path) > 0 {
+		switch path[0] {
+		case '.':
+			path = path[1:]
+
+			i := strings.IndexAny(path, ".[")
+			if i < 0 {
+				i = len(path)
+			}
+
+			name := path[:i]
+			path = path[i:]
+			if len(name) <= 0 {
+				continue
+			}
+
+			obj, ok := data.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s is not an object field", name)
+			}
+			if data, ok = obj[name]; !ok {
+				return nil, fmt.Errorf("no field %s", name)
+			}
+		case '[':
+			end := strings.IndexByte(path, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed [ in jsonpath")
+			}
+
+			index, err := strconv.Atoi(path[1:end])
+			if err != nil {
+				return nil, fmt.Errorf("invalid index %s", path[1:end])
+			}
+			path = path[end+1:]
+
+			list, ok := data.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("[%d] of something that is not a list", index)
+			}
+			if index < 0 {
+				index += len(list)
+			}
+			if index < 0 || index >= len(list) {
+				return nil, fmt.Errorf("index %d out of range", index)
+			}
+			data = list[index]
+		default:
+			return nil, fmt.Errorf("unexpected %q in jsonpath", path[0])
+		}
+	}
+
+	return data, nil
+}
+
+// jsonPathString prints strings and numbers as they are, objects and lists
+// as JSON.
+func jsonPathString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case nil:
+		return ""
+	case map[string]interface{}, []interface{}:
+		b, _ := json.Marshal(v)
+		return string(b)
+	default:
+		return fmt.Sprint(v)
+	}
+}
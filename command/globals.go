@@ -0,0 +1,138 @@
+package command
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	_ "net/http/pprof"
+	"os"
+	"time"
+
+	"github.com/dehydr8/firecracker-containerd-agent-client/client"
+	"github.com/dehydr8/firecracker-containerd-agent-client/util"
+)
+
+const (
+	logBackendText = "text"
+	logBackendSlog = "slog"
+)
+
+// Globals are the flags given before the subcommand name, they apply to
+// every subcommand.
+type Globals struct {
+	ReadOnly bool
+	AuditLog string
+
+	AdmissionHook string
+
+	RecordRPC string
+	ReplayRPC string
+
+	Chaos util.Chaos
+
+	Policy     string
+	PolicyRole string
+
+	SlowRPC     time.Duration
+	ShowTimings bool
+	Timings     *client.Timings
+
+	PprofAddr string
+
+	Strict bool
+
+	LogBackend string
+
+	Store string
+}
+
+func (g *Globals) SetFlags(f *flag.FlagSet) {
+	g.Timings = client.NewTimings()
+	f.DurationVar(&g.SlowRPC, "slow-rpc-threshold", 0, "Warn about RPCs taking longer than this, 0 to disable")
+	f.BoolVar(&g.ShowTimings, "timings", false, "Write a summary of the time spent in each RPC to stderr at the end")
+	f.StringVar(&g.LogBackend, "log-backend", logBackendText, "Log as text lines, or as slog JSON records with a trace ID on each RPC (text, slog)")
+	f.BoolVar(&g.Strict, "strict", false, "Fail on responses with fields unknown to this client, a sign of protos out of step with the agent")
+	f.StringVar(&g.PprofAddr, "pprof-addr", "", "Serve net/http/pprof on this address, to profile long running commands like exec -io")
+	f.BoolVar(&g.ReadOnly, "read-only", false, "Only allow RPCs that don't change state in the guest")
+	f.StringVar(&g.AuditLog, "audit-log", "", "Append a record of every mutating RPC to this file, or \"syslog\"")
+	f.StringVar(&g.AdmissionHook, "admission-hook", "", "Program that gets every mutating RPC as JSON on stdin, with the method as argument, and rejects it by failing with the reason on stderr")
+	f.StringVar(&g.RecordRPC, "record-rpc", "", "Record every RPC of the session to this directory")
+	f.StringVar(&g.ReplayRPC, "replay-rpc", "", "Answer RPCs from a directory written by -record-rpc instead of the agent")
+	f.StringVar(&g.Policy, "policy", "", "Policy file restricting the commands, flags and CIDs that may be used")
+	f.StringVar(&g.PolicyRole, "policy-role", defaultPolicyRole, "Role of the -policy file that applies")
+	f.StringVar(&g.Store, "store", defaultStorePath(), "File recording the containers created through this client and their labels, for list and -container_id prefixes, empty to not record them")
+}
+
+// SetHiddenFlags adds the flags left out of the help output, for testing
+// only, to f.
+func (g *Globals) SetHiddenFlags(f *flag.FlagSet) {
+	f.Float64Var(&g.Chaos.DropRate, "chaos-drop-rate", 0, "Testing only: probability of each read or write severing the connection")
+	f.DurationVar(&g.Chaos.Latency, "chaos-latency", 0, "Testing only: maximum random delay added to each read or write")
+}
+
+// WithLogger returns ctx carrying the logger -log-backend asks for.
+func (g *Globals) WithLogger(ctx context.Context) (context.Context, error) {
+	switch g.LogBackend {
+	case logBackendText:
+		return ctx, nil
+	case logBackendSlog:
+		logger := slog.New(slog.NewJSONHandler(os.Stderr, nil))
+		return util.WithLogger(ctx, util.SlogLogger{Logger: logger}), nil
+	default:
+		return nil, fmt.Errorf("unknown log backend %s", g.LogBackend)
+	}
+}
+
+// StartProfiling serves the pprof endpoints on -pprof-addr, if given, for as
+// long as the process runs.
+func (g *Globals) StartProfiling(ctx context.Context) error {
+	if len(g.PprofAddr) <= 0 {
+		return nil
+	}
+
+	l, err := net.Listen("tcp", g.PprofAddr)
+	if err != nil {
+		return err
+	}
+
+	logf(ctx, "Serving pprof on http://%s/debug/pprof/\n", l.Addr())
+
+	go http.Serve(l, http.DefaultServeMux)
+
+	return nil
+}
+
+// ReportTimings writes the -timings summary, if asked for, once the
+// subcommand is done.
+func (g *Globals) ReportTimings() {
+	if g.ShowTimings {
+		g.Timings.WriteSummary(os.Stderr)
+	}
+}
+
+type globalsKey struct{}
+
+// WithGlobals returns a context carrying g, it is passed on to the
+// subcommands by subcommands.Execute.
+func WithGlobals(ctx context.Context, g *Globals) context.Context {
+	return context.WithValue(ctx, globalsKey{}, g)
+}
+
+func globalsFrom(ctx context.Context) *Globals {
+	if g, ok := ctx.Value(globalsKey{}).(*Globals); ok {
+		return g
+	}
+	return &Globals{}
+}
+
+// checkReadOnly rejects mutating methods up front when in read-only mode,
+// for commands that do work before their first RPC.
+func checkReadOnly(ctx context.Context, service, method string) error {
+	if !globalsFrom(ctx).ReadOnly {
+		return nil
+	}
+	return client.CheckReadOnly(service, method)
+}
@@ -0,0 +1,75 @@
+package command
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// hookStages are the OCI hook stages -hook accepts, see specs.Hooks.
+var hookStages = []string{"prestart", "createRuntime", "createContainer", "startContainer", "poststart", "poststop"}
+
+// hookFlags collects -hook stage=/path[,arg...] flags into the hooks of a
+// spec. The path is also argv[0] of the hook.
+type hookFlags struct {
+	hooks specs.Hooks
+}
+
+func (h *hookFlags) String() string {
+	var hooks []string
+	for _, stage := range hookStages {
+		for _, hook := range *h.stage(stage) {
+			hooks = append(hooks, fmt.Sprintf("%s=%s", stage, strings.Join(hook.Args, ",")))
+		}
+	}
+	return strings.Join(hooks, " ")
+}
+
+func (h *hookFlags) Set(value string) error {
+	stage, command, ok := strings.Cut(value, "=")
+	if !ok || len(command) <= 0 {
+		return fmt.Errorf("expected stage=/path[,arg...], got %q", value)
+	}
+
+	hooks := h.stage(stage)
+	if hooks == nil {
+		return fmt.Errorf("unknown hook stage %s, expected one of %s", stage, strings.Join(hookStages, ", "))
+	}
+
+	args := strings.Split(command, ",")
+	*hooks = append(*hooks, specs.Hook{
+		Path: args[0],
+		Args: args,
+	})
+	return nil
+}
+
+// stage returns the hooks of the named stage, nil for an unknown stage.
+func (h *hookFlags) stage(name string) *[]specs.Hook {
+	switch name {
+	case "prestart":
+		return &h.hooks.Prestart
+	case "createRuntime":
+		return &h.hooks.CreateRuntime
+	case "createContainer":
+		return &h.hooks.CreateContainer
+	case "startContainer":
+		return &h.hooks.StartContainer
+	case "poststart":
+		return &h.hooks.Poststart
+	case "poststop":
+		return &h.hooks.Poststop
+	}
+	return nil
+}
+
+// set reports whether any hook was given.
+func (h *hookFlags) set() bool {
+	for _, stage := range hookStages {
+		if len(*h.stage(stage)) > 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+package command
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// idSeedNamespace is the UUIDv5 namespace -id-seed IDs are derived in.
+var idSeedNamespace = uuid.NewSHA1(uuid.NameSpaceURL, []byte("https://github.com/dehydr8/firecracker-containerd-agent-client"))
+
+// idFlags control how the ID of what a command creates is made, and hand it
+// to scripts, which then don't have to parse it out of the log.
+type idFlags struct {
+	idFile  string
+	printId bool
+	seed    string
+}
+
+func (i *idFlags) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&i.idFile, "id-file", "", "Write the ID to this file")
+	f.BoolVar(&i.printId, "print-id", false, "Print the ID as the only output on stdout")
+	f.StringVar(&i.seed, "id-seed", "", "Derive the ID from this seed, the command and the CID instead of making a random one")
+}
+
+// check rejects -print-id when something else goes to stdout too.
+func (i *idFlags) check(b *baseCmd, stdoutUsed bool) error {
+	if i.printId && (stdoutUsed || b.output == outputJSON) {
+		return errors.New("-print-id needs stdout to itself, it can't be used with -output json or -io")
+	}
+	return nil
+}
+
+// emit writes id where it was asked for.
+func (i *idFlags) emit(id string) error {
+	if len(i.idFile) > 0 {
+		if err := os.WriteFile(i.idFile, []byte(id+"\n"), 0644); err != nil {
+			return fmt.Errorf("writing id file: %w", err)
+		}
+	}
+
+	if i.printId {
+		fmt.Fprintln(os.Stdout, id)
+	}
+
+	return nil
+}
+
+// generate makes a new ID for running args, random unless -id-seed was
+// given. Seeded IDs come out the same for the same command on the same VM, so
+// a retry reuses the ID and guest logs can be matched to the run.
+func (i *idFlags) generate(b *baseCmd, args []string) string {
+	if len(i.seed) <= 0 {
+		return b.newID()
+	}
+
+	name := strings.Join(append([]string{i.seed, strconv.Itoa(b.cid)}, args...), "\x00")
+	return uuid.NewSHA1(idSeedNamespace, []byte(name)).String()
+}
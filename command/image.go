@@ -0,0 +1,212 @@
+package command
+
+import (
+	"context"
+	"flag"
+	"io"
+	"os"
+	"runtime"
+
+	"github.com/dehydr8/firecracker-containerd-agent-client/util"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/subcommands"
+)
+
+const (
+	exportFormatTar  = "tar"
+	exportFormatExt4 = "ext4"
+	exportFormatDir  = "dir"
+)
+
+// ImageCmd groups the commands working on OCI images.
+type ImageCmd struct{}
+
+func (*ImageCmd) Name() string     { return "image" }
+func (*ImageCmd) Synopsis() string { return "Work with OCI images" }
+func (*ImageCmd) Usage() string {
+	return `image export-rootfs -o path [-format tar|ext4|dir] [-mount path -drive-id id] <reference>:
+	Pull an image and flatten it to a rootfs.
+  `
+}
+
+func (*ImageCmd) SetFlags(*flag.FlagSet) {}
+
+func (*ImageCmd) Execute(ctx context.Context, f *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	commander := subcommands.NewCommander(f, "image")
+	commander.Register(&imageExportCmd{}, "")
+	return commander.Execute(ctx, args...)
+}
+
+type imageExportCmd struct {
+	baseCmd
+
+	output   string
+	format   string
+	platform string
+	sizeMB   int
+
+	mount   string
+	driveID string
+}
+
+func (*imageExportCmd) Name() string     { return "export-rootfs" }
+func (*imageExportCmd) Synopsis() string { return "Pull an image and flatten it to a rootfs" }
+func (*imageExportCmd) Usage() string {
+	return `export-rootfs -o path [-format tar|ext4|dir] [-platform os/arch] [-mount path -drive-id id] <reference>:
+	Pull the image from its registry and write its flattened layers as a
+	tarball, an ext4 image to use with -rootfs-config, or a directory to
+	use with bundle prepare.
+
+	With -mount, the agent then mounts the ext4 image at path in the VM.
+	The image must be attached to the VM as drive id beforehand, which is
+	up to the VMM.
+  `
+}
+
+func (p *imageExportCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&p.output, "o", "", "Path to write the rootfs to")
+	f.StringVar(&p.format, "format", exportFormatTar, "Rootfs format (tar, ext4, dir)")
+	f.StringVar(&p.platform, "platform", "linux/"+runtime.GOARCH, "Platform to pick from multi-platform images")
+	f.IntVar(&p.sizeMB, "size-mb", 1024, "Size of the ext4 image")
+	f.StringVar(&p.mount, "mount", "", "Have the agent mount the ext4 image at this path in the VM")
+	f.StringVar(&p.driveID, "drive-id", "", "ID of the drive the ext4 image is attached as, with -mount")
+	p.setAgentFlags(f)
+}
+
+func (p *imageExportCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	ctx, cancel := p.context(ctx)
+	defer cancel()
+
+	if len(f.Args()) != 1 {
+		logf(ctx, "Exactly one image reference is required")
+		return subcommands.ExitFailure
+	}
+
+	if len(p.output) <= 0 {
+		logf(ctx, "No output path defined")
+		return subcommands.ExitFailure
+	}
+
+	switch p.format {
+	case exportFormatTar, exportFormatExt4, exportFormatDir:
+	default:
+		logf(ctx, "Unknown rootfs format: %s\n", p.format)
+		return subcommands.ExitFailure
+	}
+
+	if len(p.mount) > 0 {
+		if p.format != exportFormatExt4 || len(p.driveID) <= 0 {
+			logf(ctx, "-mount needs -format ext4 and -drive-id")
+			return subcommands.ExitFailure
+		}
+
+		if err := checkReadOnly(ctx, driveMounterServiceName, mountDriveMethodName); err != nil {
+			logf(ctx, "%s\n", err)
+			return subcommands.ExitFailure
+		}
+
+		// the policy only sees the flags of image, not of export-rootfs
+		if err := checkTarget(ctx, &p.baseCmd); err != nil {
+			logf(ctx, "%s\n", err)
+			return subcommands.ExitFailure
+		}
+	}
+
+	ref, err := name.ParseReference(f.Arg(0))
+	if err != nil {
+		logf(ctx, "Failure parsing image reference: %s\n", err)
+		return subcommands.ExitFailure
+	}
+
+	platform, err := v1.ParsePlatform(p.platform)
+	if err != nil {
+		logf(ctx, "Failure parsing platform: %s\n", err)
+		return subcommands.ExitFailure
+	}
+
+	logf(ctx, "Pulling %s\n", ref)
+
+	img, err := remote.Image(ref, remote.WithContext(ctx), remote.WithPlatform(*platform))
+	if err != nil {
+		logf(ctx, "Failure pulling image: %s\n", err)
+		return subcommands.ExitFailure
+	}
+
+	// Extract applies the whiteouts of upper layers while flattening
+	rootfs := mutate.Extract(img)
+	defer rootfs.Close()
+
+	switch p.format {
+	case exportFormatTar:
+		err = writeTar(rootfs, p.output)
+	case exportFormatDir:
+		err = util.ExtractTar(rootfs, p.output)
+	case exportFormatExt4:
+		err = writeExt4(rootfs, p.output, p.sizeMB)
+	}
+
+	if err != nil {
+		logf(ctx, "Failure exporting rootfs: %s\n", err)
+		return subcommands.ExitFailure
+	}
+
+	logf(ctx, "Rootfs of %s written to %s\n", ref, p.output)
+
+	if len(p.mount) > 0 {
+		if err := p.mountRootfs(ctx); err != nil {
+			logf(ctx, "Failure mounting rootfs: %s\n", err)
+			return subcommands.ExitFailure
+		}
+	}
+
+	return subcommands.ExitSuccess
+}
+
+// mountRootfs has the agent mount the drive the exported image is attached
+// as.
+func (p *imageExportCmd) mountRootfs(ctx context.Context) error {
+	client, cleanup, err := p.newClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	return mountDrive(ctx, client, &manifestDrive{
+		ID:     p.driveID,
+		Path:   p.mount,
+		FSType: exportFormatExt4,
+	})
+}
+
+func writeTar(rootfs io.Reader, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(f, rootfs); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// writeExt4 goes through a scratch directory, mkfs.ext4 can't read tarballs
+// on older e2fsprogs.
+func writeExt4(rootfs io.Reader, path string, sizeMB int) error {
+	dir, err := os.MkdirTemp("", "rootfs-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	if err := util.ExtractTar(rootfs, dir); err != nil {
+		return err
+	}
+
+	return util.MakeExt4(dir, path, sizeMB)
+}
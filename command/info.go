@@ -0,0 +1,215 @@
+package command
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	shim "github.com/containerd/containerd/api/runtime/task/v2"
+	"github.com/containerd/ttrpc"
+	"github.com/google/subcommands"
+)
+
+// infoReport describes the guest environment, for bug reports. Probes that
+// fail leave their part empty and say why in Errors.
+type infoReport struct {
+	CID         int               `json:"cid"`
+	ContainerID string            `json:"container_id"`
+	Agent       *agentInfo        `json:"agent,omitempty"`
+	Kernel      string            `json:"kernel,omitempty"`
+	MemInfo     map[string]string `json:"meminfo,omitempty"`
+	Cgroup      *cgroupInfo       `json:"cgroup,omitempty"`
+	Errors      map[string]string `json:"errors,omitempty"`
+}
+
+type agentInfo struct {
+	Version string `json:"version"`
+	ShimPid uint32 `json:"shim_pid"`
+	TaskPid uint32 `json:"task_pid"`
+}
+
+type cgroupInfo struct {
+	Version     int      `json:"version"`
+	Controllers []string `json:"controllers,omitempty"`
+}
+
+type InfoCmd struct {
+	baseCmd
+
+	containerId string
+}
+
+func (*InfoCmd) Name() string     { return "info" }
+func (*InfoCmd) Synopsis() string { return "Report on the guest environment" }
+func (*InfoCmd) Usage() string {
+	return `info -container_id id:
+	Ask the agent for its version and exec probes in the container for the
+	kernel, memory and cgroup version of the guest, and print the findings
+	as one JSON report to attach to bug reports.
+  `
+}
+
+func (p *InfoCmd) SetFlags(f *flag.FlagSet) {
+	p.baseCmd.SetFlags(f)
+	f.StringVar(&p.containerId, "container_id", "", "Container ID")
+}
+
+func (p *InfoCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	ctx, cancel := p.context(ctx)
+	defer cancel()
+
+	if len(p.containerId) <= 0 {
+		logf(ctx, "No container ID defined")
+		return subcommands.ExitFailure
+	}
+
+	containerId, err := resolveContainerID(ctx, &p.baseCmd, p.containerId)
+	if err != nil {
+		logf(ctx, "%s\n", err)
+		return subcommands.ExitFailure
+	}
+	p.containerId = containerId
+
+	if err := checkReadOnly(ctx, serviceName, execMethodName); err != nil {
+		logf(ctx, "%s\n", err)
+		return subcommands.ExitFailure
+	}
+
+	client, cleanup, err := p.newClient(ctx)
+	if err != nil {
+		logf(ctx, "Failure creating client: %s\n", err)
+		return subcommands.ExitFailure
+	}
+	defer cleanup()
+
+	report := p.collect(ctx, client, true)
+
+	printed, err := p.printExtract(report)
+	if err != nil {
+		logf(ctx, "Failure extracting from result: %s\n", err)
+		return subcommands.ExitFailure
+	}
+
+	if !printed {
+		out, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Fprintln(os.Stdout, string(out))
+	}
+
+	return subcommands.ExitSuccess
+}
+
+// collect runs the probes, without probes only the agent is asked.
+func (p *InfoCmd) collect(ctx context.Context, client *ttrpc.Client, probes bool) *infoReport {
+	report := &infoReport{
+		CID:         p.cid,
+		ContainerID: p.containerId,
+		Errors:      map[string]string{},
+	}
+
+	var err error
+	if report.Agent, err = p.agentInfo(ctx, client); err != nil {
+		report.Errors["agent"] = err.Error()
+	}
+
+	if !probes {
+		return report
+	}
+
+	if out, err := p.probe(ctx, client, "uname", "-a"); err != nil {
+		report.Errors["kernel"] = err.Error()
+	} else {
+		report.Kernel = strings.TrimSpace(string(out))
+	}
+
+	if out, err := p.probe(ctx, client, "cat", "/proc/meminfo"); err != nil {
+		report.Errors["meminfo"] = err.Error()
+	} else {
+		report.MemInfo = parseMemInfo(out)
+	}
+
+	// only the unified hierarchy of cgroup v2 has cgroup.controllers at
+	// its root, cat failing on it tells v1, not reaching the agent nothing
+	out, err := p.probe(ctx, client, "cat", "/sys/fs/cgroup/cgroup.controllers")
+
+	var exitErr *probeExitError
+	switch {
+	case err == nil:
+		report.Cgroup = &cgroupInfo{
+			Version:     2,
+			Controllers: strings.Fields(string(out)),
+		}
+	case errors.As(err, &exitErr):
+		report.Cgroup = &cgroupInfo{Version: 1}
+	default:
+		report.Errors["cgroup"] = err.Error()
+	}
+
+	return report
+}
+
+func (p *InfoCmd) agentInfo(ctx context.Context, client *ttrpc.Client) (*agentInfo, error) {
+	req := &shim.ConnectRequest{
+		ID: p.containerId,
+	}
+
+	res := &shim.ConnectResponse{}
+
+	if err := client.Call(ctx, serviceName, connectMethodName, req, res); err != nil {
+		return nil, err
+	}
+
+	return &agentInfo{
+		Version: res.Version,
+		ShimPid: res.ShimPid,
+		TaskPid: res.TaskPid,
+	}, nil
+}
+
+// probe execs args in the container and returns what it wrote to stdout.
+func (p *InfoCmd) probe(ctx context.Context, client *ttrpc.Client, args ...string) ([]byte, error) {
+	var out bytes.Buffer
+
+	exit, err := execProcess(ctx, &p.baseCmd, client, p.containerId, args, nil, &out)
+	if err != nil {
+		return nil, err
+	}
+
+	if exit.ExitStatus != 0 {
+		return nil, &probeExitError{args: args, status: exit.ExitStatus}
+	}
+
+	return out.Bytes(), nil
+}
+
+// probeExitError is returned by probe for a process that ran and exited
+// with a status other than 0.
+type probeExitError struct {
+	args   []string
+	status uint32
+}
+
+func (e *probeExitError) Error() string {
+	return fmt.Sprintf("%s exited with status %d", strings.Join(e.args, " "), e.status)
+}
+
+// parseMemInfo turns the "MemTotal:  2043136 kB" lines of /proc/meminfo
+// into a map.
+func parseMemInfo(b []byte) map[string]string {
+	info := map[string]string{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if ok {
+			info[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+
+	return info
+}
@@ -0,0 +1,594 @@
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	apievents "github.com/containerd/containerd/api/events"
+	shim "github.com/containerd/containerd/api/runtime/task/v2"
+	events "github.com/containerd/containerd/api/services/events/v1"
+	"github.com/containerd/containerd/api/types"
+	"github.com/containerd/ttrpc"
+	"github.com/dehydr8/firecracker-containerd-agent-client/proto"
+	"github.com/dehydr8/firecracker-containerd-agent-client/util"
+	"github.com/google/subcommands"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	gproto "google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+const (
+	taskOOMEventTopic = "/tasks/oom"
+
+	// jobCleanupTimeout bounds the kill and delete of the container, which
+	// still have to happen once the deadline passed
+	jobCleanupTimeout = 30 * time.Second
+
+	// killWaitTimeout bounds the wait for a process to exit on SIGKILL
+	// before it is deleted anyway, when no grace period says otherwise
+	killWaitTimeout = 10 * time.Second
+
+	// oomWatchGrace is how long the exit event, which ends the OOM watch,
+	// is waited for after Wait returned
+	oomWatchGrace = 5 * time.Second
+
+	// jobRestartMinBackoff and jobRestartMaxBackoff bound the pause before
+	// each restart, which doubles from one to the next
+	jobRestartMinBackoff = time.Second
+	jobRestartMaxBackoff = time.Minute
+)
+
+// jobResult is reported once the job's container was deleted.
+type jobResult struct {
+	ID       string  `json:"id"`
+	ExitCode uint32  `json:"exit_code"`
+	Duration float64 `json:"duration_seconds"`
+	OOM      bool    `json:"oom"`
+	TimedOut bool    `json:"timed_out"`
+	Restarts int     `json:"restarts"`
+	Stdout   string  `json:"stdout"`
+	Stderr   string  `json:"stderr"`
+
+	// ProbeFailure is why the last run was stopped by its probes
+	ProbeFailure string `json:"probe_failure,omitempty"`
+
+	// CollectFailures are the -collect paths of the last run that couldn't
+	// be copied out
+	CollectFailures []string `json:"collect_failures,omitempty"`
+}
+
+type JobCmd struct {
+	baseCmd
+
+	spec    specFlags
+	secrets secretFlags
+	probes  probeFlags
+
+	id           string
+	bundle       string
+	rootFSConfig string
+	deadline     time.Duration
+	killAfter    time.Duration
+	logDir       string
+	maxOutput    int64
+	stripANSI    bool
+	restart      restartPolicy
+	initExecs    stringList
+	collect      collectFlags
+	hooks        notifyHooks
+	progress     progressReporter
+}
+
+func (*JobCmd) Name() string     { return "job" }
+func (*JobCmd) Synopsis() string { return "Run a container to completion and report its result" }
+func (*JobCmd) Usage() string {
+	return `job [-deadline d] [-log-dir dir] [-restart on-failure[:max]] [-init-exec cmd]... [-ready-cmd cmd] [-live-cmd cmd] [-collect src:dst]...
+    [-on-exit cmd] [-on-oom cmd] [-progress json|bar] <command>:
+	Create and start a container, capture its output to <id>.stdout and
+	<id>.stderr in the log directory, wait for it to exit, delete it and
+	print the result as JSON. The -init-exec commands are run in order in
+	the created container before it is started, each has to exit with 0. The container is killed once the deadline
+	passes, with -kill-after after a SIGTERM and a grace period. With
+	-restart on-failure, a container exiting with non-zero is deleted and
+	created again, up to max times if given, until the deadline. A
+	container failing its -ready-cmd or -live-cmd probe is stopped like on
+	the deadline, and counts as failed. With -collect, the command is run
+	under sh, which keeps the container up once it exited for the paths to
+	be copied out with tar before the container is deleted. Fails unless
+	the command exits with 0 and every path was collected. The container
+	stays recorded in the -store once deleted, with its exit status and
+	time for list. -on-oom and -on-exit run a command on the host with sh -c
+	once a run of the container was OOM killed and once it exited, with the
+	event in the environment as for events -on-exit.
+
+	-progress reports the steps of every run on stderr as they happen:
+	attached, created, started, waiting, exited and deleted. With json,
+	each is a line with its time, the container ID, the run, 0 for the
+	first, and the exit status once exited. With bar, a line with the steps
+	done so far.
+  `
+}
+
+func (p *JobCmd) SetFlags(f *flag.FlagSet) {
+	p.baseCmd.SetFlags(f)
+	p.spec.SetFlags(f)
+	p.probes.SetFlags(f)
+	f.StringVar(&p.id, "id", "", "Container ID, random if empty")
+	f.StringVar(&p.rootFSConfig, "rootfs-config", "{}", "RootFS Config JSON")
+	f.StringVar(&p.bundle, "bundle", "", "Bundle")
+	f.DurationVar(&p.deadline, "deadline", 0, "Kill the container once it ran this long, 0 for no deadline")
+	f.DurationVar(&p.killAfter, "kill-after", 0, "On the deadline, send SIGTERM and only SIGKILL once the container outlived this, 0 for SIGKILL right away")
+	f.StringVar(&p.logDir, "log-dir", ".", "Directory the output of the container is captured to")
+	f.Int64Var(&p.maxOutput, "max-output-bytes", 0, "Bytes of stdout and stderr each to capture before truncating, 0 for no limit")
+	f.BoolVar(&p.stripANSI, "strip-ansi", false, "Remove terminal escape sequences from the captured output")
+	f.Var(&p.secrets, "secret", "Push a file into "+secretsDir+" of the container, as name=/local/path[:mode] (repeatable)")
+	f.Var(&p.initExecs, "init-exec", "Command run with sh -c in the container before it is started, its stdout goes to the stdout log (repeatable, run in order)")
+	f.Var(&p.collect, "collect", "Copy a path out of the container into a local directory once the command exited, as /path/in/container:/local/dir (repeatable)")
+	f.Var(&p.restart, "restart", "Restart policy, no or on-failure[:max] to create the container again when it exits with non-zero")
+	p.hooks.SetFlags(f)
+	f.Var(&p.progress, "progress", "Report the steps of each run on stderr, as json lines or a bar")
+}
+
+func (p *JobCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	ctx, cancel := p.context(ctx)
+	defer cancel()
+
+	if len(f.Args()) <= 0 {
+		logf(ctx, "No command defined")
+		return subcommands.ExitFailure
+	}
+
+	methods := []string{createMethodName, startMethodName, killMethodName, deleteMethodName}
+	if len(p.secrets) > 0 || len(p.initExecs) > 0 || len(p.collect) > 0 || len(p.probes.readyCmd) > 0 || len(p.probes.liveCmd) > 0 {
+		methods = append(methods, execMethodName)
+	}
+
+	for _, method := range methods {
+		if err := checkReadOnly(ctx, serviceName, method); err != nil {
+			logf(ctx, "%s\n", err)
+			return subcommands.ExitFailure
+		}
+	}
+
+	if p.killAfter > 0 && p.deadline <= 0 && len(p.probes.readyCmd) <= 0 && len(p.probes.liveCmd) <= 0 {
+		logf(ctx, "-kill-after needs a -deadline or a probe\n")
+		return subcommands.ExitFailure
+	}
+
+	if err := p.probes.check(); err != nil {
+		logf(ctx, "%s\n", err)
+		return subcommands.ExitFailure
+	}
+
+	if len(p.id) <= 0 {
+		p.id = p.newID()
+	}
+
+	spec, err := p.spec.spec(p.id, f.Args())
+	if err != nil {
+		logf(ctx, "Failure building spec: %s\n", err)
+		return subcommands.ExitFailure
+	}
+
+	p.secrets.mount(spec)
+
+	if err := p.spec.mutate(spec); err != nil {
+		logf(ctx, "%s\n", err)
+		return subcommands.ExitFailure
+	}
+
+	p.collect.wrap(spec)
+
+	var rootFSMount types.Mount
+	if err := json.Unmarshal([]byte(p.rootFSConfig), &rootFSMount); err != nil {
+		logf(ctx, "Failure parsing RootFS JSON config: %s\n", err)
+		return subcommands.ExitFailure
+	}
+
+	result := &jobResult{
+		ID:     p.id,
+		Stdout: filepath.Join(p.logDir, p.id+".stdout"),
+		Stderr: filepath.Join(p.logDir, p.id+".stderr"),
+	}
+
+	stdout, err := os.Create(result.Stdout)
+	if err != nil {
+		logf(ctx, "Failure creating stdout log: %s\n", err)
+		return subcommands.ExitFailure
+	}
+	defer stdout.Close()
+
+	stderr, err := os.Create(result.Stderr)
+	if err != nil {
+		logf(ctx, "Failure creating stderr log: %s\n", err)
+		return subcommands.ExitFailure
+	}
+	defer stderr.Close()
+
+	client, cleanup, err := p.newClient(ctx)
+	if err != nil {
+		logf(ctx, "Failure creating client: %s\n", err)
+		return subcommands.ExitFailure
+	}
+	defer cleanup()
+
+	backoff := util.NewBackoff(jobRestartMinBackoff, jobRestartMaxBackoff)
+
+	// started is set by the first run, the deadline and the duration count
+	// from there
+	var started time.Time
+
+	for {
+		run, err := p.run(ctx, client, spec, &rootFSMount, stdout, stderr, &started, result.Restarts)
+		if err != nil {
+			logf(ctx, "Failure running container %s: %s\n", p.id, err)
+			return subcommands.ExitFailure
+		}
+
+		result.ExitCode = run.exit.ExitStatus
+		result.OOM = run.oom
+		result.TimedOut = run.timedOut
+		result.ProbeFailure = run.probeFailure
+		result.CollectFailures = run.collectFailures
+
+		if run.oom {
+			oom, _ := json.Marshal(map[string]string{"container_id": p.id})
+			p.hooks.notify(ctx, &p.baseCmd, notifyOOM, oom)
+		}
+		exit, _ := json.Marshal(run.exit)
+		p.hooks.notify(ctx, &p.baseCmd, notifyExit, exit)
+
+		if run.timedOut || (run.exit.ExitStatus == 0 && len(run.probeFailure) <= 0) || !p.restart.allows(result.Restarts) {
+			break
+		}
+
+		result.Restarts++
+		logf(ctx, "Container %s exited with %d, restarting it (%s)\n", p.id, run.exit.ExitStatus, p.restart.count(result.Restarts))
+
+		if err := backoff.Wait(ctx); err != nil {
+			logf(ctx, "Failure waiting to restart container %s: %s\n", p.id, err)
+			return subcommands.ExitFailure
+		}
+	}
+
+	result.Duration = time.Since(started).Seconds()
+
+	printed, err := p.printExtract(result)
+	if err != nil {
+		logf(ctx, "Failure extracting from result: %s\n", err)
+		return subcommands.ExitFailure
+	}
+
+	if !printed {
+		out, _ := json.Marshal(result)
+		fmt.Fprintln(os.Stdout, string(out))
+	}
+
+	if result.TimedOut || result.ExitCode != 0 || len(result.ProbeFailure) > 0 || len(result.CollectFailures) > 0 {
+		return subcommands.ExitFailure
+	}
+
+	return subcommands.ExitSuccess
+}
+
+// jobRun is how one run of the job's container ended.
+type jobRun struct {
+	exit         *waitResult
+	oom          bool
+	timedOut     bool
+	probeFailure string
+
+	collectFailures []string
+}
+
+// run creates the container, starts it and waits for it to exit, then
+// deletes it. Its output is appended to stdout and stderr. started is set
+// on the first run, n counts the runs from 0 for -progress.
+func (p *JobCmd) run(ctx context.Context, client *ttrpc.Client, spec *specs.Spec, rootFSMount *types.Mount, stdout, stderr *os.File, started *time.Time, n int) (*jobRun, error) {
+	_, stdoutPort, stderrPort, err := p.vsockPorts()
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := newCreateTaskRequest(p.id, p.bundle, spec, rootFSMount, &proto.ExtraData{
+		StdoutPort: stdoutPort,
+		StderrPort: stderrPort,
+	})
+	if err != nil {
+		return nil, err
+	}
+	req.Stdout = p.newID()
+	req.Stderr = p.newID()
+
+	logf(ctx, "Creating container: %s\n", p.id)
+
+	createCallError := make(chan error)
+	go func() {
+		createCallError <- client.Call(ctx, serviceName, createMethodName, req, &shim.CreateTaskResponse{})
+	}()
+
+	// catch-22 in Create, it won't finish until a connection is accepted for IOProxy
+	time.Sleep(1 * time.Second)
+
+	// procCtx is cancelled once the container exits, which gives the IO
+	// streams time to flush before the proxy closes them
+	procCtx, procCancel := context.WithCancel(ctx)
+	defer procCancel()
+
+	proxy := util.NewIOConnectorProxy(
+		nil,
+		&util.IOConnectorPair{
+			ReadConnector:  p.ioConnector(ctx, stdoutPort),
+			WriteConnector: filterOutput(util.FileConnector(stdout), p.stripANSI, p.maxOutput),
+		},
+		&util.IOConnectorPair{
+			ReadConnector:  p.ioConnector(ctx, stderrPort),
+			WriteConnector: filterOutput(util.FileConnector(stderr), p.stripANSI, p.maxOutput),
+		},
+	)
+
+	initDone, copyDone := proxy.Start(procCtx, util.ProxyLogger(ctx))
+
+	if err := <-initDone; err != nil {
+		return nil, fmt.Errorf("starting IOProxy: %w", err)
+	}
+	p.progress.step(p.id, n, "attached", nil)
+
+	if err := <-createCallError; err != nil {
+		return nil, fmt.Errorf("create call: %w", err)
+	}
+	p.progress.step(p.id, n, "created", nil)
+
+	// from here on the container exists and is deleted however the run ends
+	cleanupCtx, cleanupCancel := context.WithTimeout(context.WithoutCancel(ctx), jobCleanupTimeout+2*p.killAfter)
+	defer cleanupCancel()
+
+	recordContainer(ctx, &p.baseCmd, p.id, labelsOf(spec))
+
+	defer func() {
+		res, err := deleteContainer(cleanupCtx, client, p.id)
+		if err != nil {
+			logf(ctx, "Failure deleting container %s: %s\n", p.id, err)
+			return
+		}
+		recordExit(cleanupCtx, &p.baseCmd, p.id, res)
+		p.progress.step(p.id, n, "deleted", nil)
+	}()
+
+	if err := p.secrets.push(ctx, &p.baseCmd, client, p.id); err != nil {
+		return nil, fmt.Errorf("pushing secrets: %w", err)
+	}
+
+	for _, cmd := range p.initExecs {
+		logf(ctx, "Running init exec in container %s: %s\n", p.id, cmd)
+
+		exit, err := execProcess(ctx, &p.baseCmd, client, p.id, []string{"sh", "-c", cmd}, nil, stdout)
+		if err != nil {
+			return nil, fmt.Errorf("init exec %q: %w", cmd, err)
+		}
+
+		if exit.ExitStatus != 0 {
+			return nil, fmt.Errorf("init exec %q exited with %d", cmd, exit.ExitStatus)
+		}
+	}
+
+	watchCtx, watchCancel := context.WithCancel(ctx)
+	defer watchCancel()
+
+	oom := watchOOM(watchCtx, client, p.id)
+
+	if _, err := startContainer(ctx, client, p.id); err != nil {
+		return nil, fmt.Errorf("start call: %w", err)
+	}
+
+	p.progress.step(p.id, n, "started", nil)
+
+	if started.IsZero() {
+		*started = time.Now()
+	}
+
+	jobCtx, jobCancel := ctx, context.CancelFunc(func() {})
+	if p.deadline > 0 {
+		jobCtx, jobCancel = context.WithDeadline(ctx, started.Add(p.deadline))
+	}
+	defer jobCancel()
+
+	run := &jobRun{}
+
+	// a failed probe stops the container, which ends the wait below
+	probeCtx, probeCancel := context.WithCancel(jobCtx)
+	defer probeCancel()
+
+	probeFailed := p.probes.watch(probeCtx, &p.baseCmd, client, p.id)
+	probesDone := make(chan struct{})
+	go func() {
+		defer close(probesDone)
+
+		reason, ok := <-probeFailed
+		if !ok {
+			return
+		}
+
+		run.probeFailure = reason
+		logf(ctx, "Container %s failed its probe, stopping it: %s\n", p.id, reason)
+
+		if _, err := terminate(cleanupCtx, client, p.id, "", p.killAfter); err != nil {
+			logf(ctx, "Failure stopping container %s: %s\n", p.id, err)
+		}
+	}()
+
+	p.progress.step(p.id, n, "waiting", nil)
+
+	var exit *waitResult
+	if len(p.collect) > 0 {
+		exit, run.collectFailures, err = p.collect.waitAndCollect(jobCtx, &p.baseCmd, client, p.id)
+	} else {
+		exit, err = waitForExit(jobCtx, client, p.id, "")
+	}
+
+	if err != nil && errors.Is(jobCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil {
+		logf(ctx, "Deadline of %s passed, stopping container %s\n", p.deadline, p.id)
+		run.timedOut = true
+		exit, err = terminate(cleanupCtx, client, p.id, "", p.killAfter)
+	}
+
+	probeCancel()
+	<-probesDone
+
+	if err != nil {
+		return nil, fmt.Errorf("waiting for exit: %w", err)
+	}
+
+	run.exit = exit
+	p.progress.step(p.id, n, "exited", &exit.ExitStatus)
+
+	procCancel()
+	if err := <-copyDone; err != nil {
+		logf(ctx, "Failure in IOProxy: %s\n", err)
+	}
+
+	select {
+	case run.oom = <-oom:
+	case <-time.After(oomWatchGrace):
+		logf(ctx, "No exit event from the event bridge, OOM kills can't be told apart\n")
+	}
+
+	return run, nil
+}
+
+func killAndWait(ctx context.Context, client *ttrpc.Client, id string) (*waitResult, error) {
+	killReq := &shim.KillRequest{
+		ID:     id,
+		Signal: uint32(syscall.SIGKILL),
+		All:    true,
+	}
+
+	if err := client.Call(ctx, serviceName, killMethodName, killReq, &emptypb.Empty{}); err != nil {
+		return nil, err
+	}
+
+	return waitForExit(ctx, client, id, "")
+}
+
+// terminate stops the process by escalation: SIGTERM, SIGKILL once it
+// outlived grace, then a forced Delete once it outlived the SIGKILL as long.
+// A grace of 0 goes straight to SIGKILL, which is given killWaitTimeout.
+func terminate(ctx context.Context, client *ttrpc.Client, containerId, execId string, grace time.Duration) (*waitResult, error) {
+	name := containerId
+	if len(execId) > 0 {
+		name += "/" + execId
+	}
+
+	if grace > 0 {
+		exit, err := signalAndWait(ctx, client, containerId, execId, syscall.SIGTERM, grace)
+		if err == nil {
+			return exit, nil
+		}
+		logf(ctx, "Process %s still running %s after SIGTERM, sending SIGKILL\n", name, grace)
+	}
+
+	wait := grace
+	if wait <= 0 {
+		wait = killWaitTimeout
+	}
+
+	exit, err := signalAndWait(ctx, client, containerId, execId, syscall.SIGKILL, wait)
+	if err == nil {
+		return exit, nil
+	}
+	logf(ctx, "Process %s didn't exit on SIGKILL (%s), deleting it\n", name, err)
+
+	req := &shim.DeleteRequest{
+		ID:     containerId,
+		ExecID: execId,
+	}
+
+	res := &shim.DeleteResponse{}
+
+	if err := client.Call(ctx, serviceName, deleteMethodName, req, res); err != nil {
+		return nil, err
+	}
+
+	return &waitResult{
+		ContainerID: containerId,
+		ExecID:      execId,
+		ExitStatus:  res.ExitStatus,
+		ExitedAt:    res.ExitedAt.AsTime(),
+	}, nil
+}
+
+// signalAndWait sends sig to the process, to all of the container's for its
+// init process, and waits up to wait for it to exit.
+func signalAndWait(ctx context.Context, client *ttrpc.Client, containerId, execId string, sig syscall.Signal, wait time.Duration) (*waitResult, error) {
+	req := &shim.KillRequest{
+		ID:     containerId,
+		ExecID: execId,
+		Signal: uint32(sig),
+		All:    len(execId) <= 0,
+	}
+
+	// a process that already exited can't be signalled, but can be waited on
+	if err := client.Call(ctx, serviceName, killMethodName, req, &emptypb.Empty{}); err != nil && status.Code(err) != codes.NotFound {
+		return nil, err
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+
+	return waitForExit(waitCtx, client, containerId, execId)
+}
+
+// watchOOM pulls events from the event bridge until the exit event of the
+// container, and sends whether an OOM event came before it. Like wait
+// -via-events, it takes the events of other containers away from other
+// consumers.
+func watchOOM(ctx context.Context, client *ttrpc.Client, containerId string) <-chan bool {
+	oomCh := make(chan bool, 1)
+
+	go func() {
+		oom := false
+		backoff := util.NewBackoff(eventPollMinInterval, defaultEventPollInterval)
+
+		for {
+			envelope := &events.Envelope{}
+
+			if err := client.Call(ctx, eventServiceName, getEventMethodName, &emptypb.Empty{}, envelope); err != nil {
+				return
+			}
+
+			if envelope.Event == nil {
+				if backoff.Wait(ctx) != nil {
+					return
+				}
+				continue
+			}
+
+			backoff.Reset()
+
+			switch envelope.Topic {
+			case taskOOMEventTopic:
+				event := &apievents.TaskOOM{}
+				if err := gproto.Unmarshal(envelope.Event.Value, event); err == nil && event.ContainerID == containerId {
+					oom = true
+				}
+			case taskExitEventTopic:
+				event := &apievents.TaskExit{}
+				if err := gproto.Unmarshal(envelope.Event.Value, event); err == nil && event.ContainerID == containerId && event.ID == containerId {
+					oomCh <- oom
+					return
+				}
+			}
+		}
+	}()
+
+	return oomCh
+}
@@ -0,0 +1,196 @@
+package command
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"time"
+)
+
+const (
+	journalMarkerStart = "start"
+	journalMarkerEnd   = "end"
+)
+
+// journalEntry is a line of an events -journal: an event pulled, numbered
+// across sessions, or a marker of a session starting or ending. Event
+// lines are eventRecords with a seq, -replay reads journals like sinks.
+type journalEntry struct {
+	Seq    uint64     `json:"seq,omitempty"`
+	Marker string     `json:"marker,omitempty"`
+	Time   *time.Time `json:"time,omitempty"`
+
+	*eventRecord
+}
+
+// parseJournalEntry parses a journal line, or a -sink line, which is an
+// event without seq.
+func parseJournalEntry(data []byte) (*journalEntry, error) {
+	// json can't allocate an embedded pointer to an unexported type
+	entry := &journalEntry{eventRecord: &eventRecord{}}
+	if err := json.Unmarshal(data, entry); err != nil {
+		return nil, err
+	}
+
+	if len(entry.Marker) > 0 {
+		entry.eventRecord = nil
+	}
+	return entry, nil
+}
+
+// journalSummary is what a journal holds from earlier sessions.
+type journalSummary struct {
+	Events   int
+	Sessions int
+	LastSeq  uint64
+	LastAt   time.Time
+
+	// the last session has no end marker, it died while it may have held
+	// an event pulled but not journaled yet
+	Unclean bool
+
+	// event numbers missing between the journaled ones, as first-last
+	Gaps []string
+}
+
+// eventJournal appends the events pulled by a session to the journal,
+// which it keeps locked against other sessions. GetEvent takes the event
+// off the bridge, the journal is the only trace left of events consumed
+// by earlier sessions.
+type eventJournal struct {
+	file *os.File
+	seq  uint64
+}
+
+// openJournal opens the journal at path, creating it if needed, reads what
+// earlier sessions left and marks a new session started.
+func openJournal(path string) (*eventJournal, *journalSummary, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// released by closing the file
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, nil, errors.New("in use by another events session")
+		}
+		return nil, nil, err
+	}
+
+	summary, end, err := readJournal(file)
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+
+	// a torn last line of a session that died mid write is dropped, the
+	// next entries would be glued to it
+	if err := file.Truncate(end); err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	if _, err := file.Seek(end, io.SeekStart); err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+
+	j := &eventJournal{file: file, seq: summary.LastSeq}
+
+	if err := j.mark(journalMarkerStart); err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+
+	return j, summary, nil
+}
+
+// readJournal summarizes the journal in r and returns the offset past its
+// last complete line.
+func readJournal(r io.Reader) (*journalSummary, int64, error) {
+	summary := &journalSummary{}
+	reader := bufio.NewReader(r)
+
+	var end int64
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			// whatever is left has no newline, it is the torn line
+			return summary, end, nil
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+
+		entry, err := parseJournalEntry(bytes.TrimSpace(data))
+		if err != nil {
+			return nil, 0, fmt.Errorf("line %d: %w", line, err)
+		}
+		end += int64(len(data))
+
+		switch {
+		case entry.Marker == journalMarkerStart:
+			summary.Sessions++
+			summary.Unclean = true
+		case entry.Marker == journalMarkerEnd:
+			summary.Unclean = false
+		case len(entry.Marker) > 0:
+			return nil, 0, fmt.Errorf("line %d: unknown marker %s", line, entry.Marker)
+		default:
+			if entry.Seq <= summary.LastSeq {
+				return nil, 0, fmt.Errorf("line %d: event %d after event %d", line, entry.Seq, summary.LastSeq)
+			}
+			if entry.Seq != summary.LastSeq+1 {
+				summary.Gaps = append(summary.Gaps, fmt.Sprintf("%d-%d", summary.LastSeq+1, entry.Seq-1))
+			}
+			summary.Events++
+			summary.LastSeq = entry.Seq
+			summary.LastAt = entry.Timestamp
+		}
+	}
+}
+
+// add journals the record as the next event, synced to disk before the
+// event is printed.
+func (j *eventJournal) add(record *eventRecord) error {
+	j.seq++
+	return j.write(&journalEntry{Seq: j.seq, eventRecord: record})
+}
+
+// mark journals a session marker.
+func (j *eventJournal) mark(marker string) error {
+	now := time.Now().UTC()
+	return j.write(&journalEntry{Marker: marker, Time: &now})
+}
+
+func (j *eventJournal) write(entry *journalEntry) error {
+	out, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	if _, err := j.file.Write(append(out, '\n')); err != nil {
+		return err
+	}
+	return j.file.Sync()
+}
+
+// close ends the session, marking it ended unless it was cut short, when a
+// pulled event may not have made it into the journal.
+func (j *eventJournal) close(clean bool) error {
+	var err error
+	if clean {
+		err = j.mark(journalMarkerEnd)
+	}
+
+	if closeErr := j.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
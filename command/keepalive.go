@@ -0,0 +1,24 @@
+package command
+
+import (
+	"context"
+	"time"
+
+	"github.com/containerd/ttrpc"
+	"github.com/dehydr8/firecracker-containerd-agent-client/client"
+)
+
+// keepAlive cancels the command once the agent stops answering keepalives,
+// it does nothing if interval isn't positive.
+func keepAlive(ctx context.Context, c *ttrpc.Client, id string, interval time.Duration, cancel context.CancelFunc) {
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		if err := client.KeepAlive(ctx, c, id, interval); err != nil {
+			logf(ctx, "Agent stopped responding: %s\n", err)
+			cancel()
+		}
+	}()
+}
@@ -0,0 +1,59 @@
+package command
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// labelAnnotationPrefix keeps labels apart from the other annotations of
+// the spec, which is where they are stored.
+const labelAnnotationPrefix = "com.github.dehydr8.firecracker-containerd-agent-client.label."
+
+// keyValueFlags collects repeated -flag key=value flags.
+type keyValueFlags map[string]string
+
+func (kv keyValueFlags) String() string {
+	return fmt.Sprint(map[string]string(kv))
+}
+
+func (kv keyValueFlags) Set(value string) error {
+	key, val, ok := strings.Cut(value, "=")
+	if !ok || len(key) <= 0 {
+		return fmt.Errorf("expected key=value, got %q", value)
+	}
+	kv[key] = val
+	return nil
+}
+
+// annotate adds the annotations and labels to the spec, labels as
+// annotations under labelAnnotationPrefix.
+func annotate(spec *specs.Spec, labels, annotations map[string]string) {
+	if len(labels) <= 0 && len(annotations) <= 0 {
+		return
+	}
+
+	if spec.Annotations == nil {
+		spec.Annotations = map[string]string{}
+	}
+
+	for k, v := range annotations {
+		spec.Annotations[k] = v
+	}
+
+	for k, v := range labels {
+		spec.Annotations[labelAnnotationPrefix+k] = v
+	}
+}
+
+// labelsOf returns the labels annotate stored in the spec.
+func labelsOf(spec *specs.Spec) map[string]string {
+	labels := map[string]string{}
+	for k, v := range spec.Annotations {
+		if key, ok := strings.CutPrefix(k, labelAnnotationPrefix); ok {
+			labels[key] = v
+		}
+	}
+	return labels
+}
@@ -0,0 +1,100 @@
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/subcommands"
+)
+
+type ListCmd struct {
+	baseCmd
+
+	filters labelFilters
+}
+
+func (*ListCmd) Name() string     { return "list" }
+func (*ListCmd) Synopsis() string { return "List the containers created through this client" }
+func (*ListCmd) Usage() string {
+	return `list [-filter label=key[=value]]...:
+	List the containers created on the agent through this client, as
+	recorded in the -store file, with their labels. The agent isn't asked,
+	containers deleted by other means stay listed until delete is run on
+	them. Containers job ran stay listed once it deleted them, with the
+	exit status and time of their init process, until delete is run on
+	them.
+  `
+}
+
+func (p *ListCmd) SetFlags(f *flag.FlagSet) {
+	p.baseCmd.SetFlags(f)
+	f.Var(&p.filters, "filter", "Only list containers with the label key, set to value if given, as label=key[=value] (repeatable)")
+}
+
+func (p *ListCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	store := storeFrom(ctx)
+	if store == nil {
+		logf(ctx, "No -store to list containers from")
+		return subcommands.ExitFailure
+	}
+
+	containers, err := store.list(p.target(), p.filters)
+	if err != nil {
+		logf(ctx, "Failure reading %s: %s\n", store.path, err)
+		return subcommands.ExitFailure
+	}
+
+	if containers == nil {
+		containers = []*storedContainer{}
+	}
+
+	printed, err := p.printExtract(containers)
+	if err != nil {
+		logf(ctx, "Failure extracting from result: %s\n", err)
+		return subcommands.ExitFailure
+	}
+
+	if printed {
+		return subcommands.ExitSuccess
+	}
+
+	if p.output == outputJSON {
+		out, err := json.Marshal(containers)
+		if err != nil {
+			logf(ctx, "Failure reporting result: %s\n", err)
+			return subcommands.ExitFailure
+		}
+		fmt.Fprintln(os.Stdout, string(out))
+		return subcommands.ExitSuccess
+	}
+
+	// the ID comes first, for xargs
+	for _, c := range containers {
+		columns := []string{c.ID}
+		if len(c.Labels) > 0 {
+			columns = append(columns, formatLabels(c.Labels))
+		}
+		if c.ExitStatus != nil {
+			columns = append(columns, fmt.Sprintf("exited with %d at %s", *c.ExitStatus, c.ExitedAt.Format(time.RFC3339)))
+		}
+		fmt.Println(strings.Join(columns, "\t"))
+	}
+
+	return subcommands.ExitSuccess
+}
+
+// formatLabels joins the labels as sorted key=value pairs.
+func formatLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+v)
+	}
+	slices.Sort(pairs)
+	return strings.Join(pairs, ",")
+}
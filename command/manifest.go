@@ -0,0 +1,242 @@
+package command
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	shim "github.com/containerd/containerd/api/runtime/task/v2"
+	"github.com/containerd/containerd/api/types"
+	"github.com/dehydr8/firecracker-containerd-agent-client/proto"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"sigs.k8s.io/yaml"
+)
+
+// manifest declares the containers apply creates and delete tears down. up
+// also uses the VM, drives and primary container it may declare.
+type manifest struct {
+	VM         *manifestVM          `json:"vm,omitempty"`
+	Drives     []*manifestDrive     `json:"drives,omitempty"`
+	Containers []*manifestContainer `json:"containers"`
+	Primary    *manifestPrimary     `json:"primary,omitempty"`
+}
+
+// manifestVM locates the agent, and tells up how to launch the VM when the
+// agent doesn't answer and down how to stop it. The address flags given on the command line win
+// over it.
+type manifestVM struct {
+	CID        int    `json:"cid,omitempty"`
+	Port       int    `json:"port,omitempty"`
+	UnixSocket string `json:"unixSocket,omitempty"`
+
+	// Launch is run on the host, through fc-control or otherwise, and must
+	// return once the VM is booting
+	Launch []string `json:"launch,omitempty"`
+
+	// Stop is run on the host by down -stop-vm once the containers and
+	// drives are gone
+	Stop []string `json:"stop,omitempty"`
+
+	// AgentTimeout bounds the wait for the agent to answer, as a Go
+	// duration
+	AgentTimeout string `json:"agentTimeout,omitempty"`
+}
+
+// manifestDrive is a drive of the VM the agent mounts in the guest.
+type manifestDrive struct {
+	ID      string   `json:"id"`
+	Path    string   `json:"path"`
+	FSType  string   `json:"fsType,omitempty"`
+	Options []string `json:"options,omitempty"`
+}
+
+// manifestPrimary names the container up attaches to once everything runs.
+type manifestPrimary struct {
+	ID string `json:"id"`
+
+	// Args is the process attached to, sh when not set
+	Args []string `json:"args,omitempty"`
+}
+
+// manifestContainer holds the settings create takes as flags, for one
+// container of the manifest.
+type manifestContainer struct {
+	ID           string            `json:"id"`
+	Args         []string          `json:"args"`
+	Env          []string          `json:"env,omitempty"`
+	DefaultEnv   string            `json:"defaultEnv,omitempty"`
+	Cwd          string            `json:"cwd,omitempty"`
+	Priv         bool              `json:"priv,omitempty"`
+	Namespace    string            `json:"namespace,omitempty"`
+	CgroupParent string            `json:"cgroupParent,omitempty"`
+	PidNs        string            `json:"pidNs,omitempty"`
+	Bundle       string            `json:"bundle,omitempty"`
+	Rootfs       *types.Mount      `json:"rootfs,omitempty"`
+	Mounts       []specs.Mount     `json:"mounts,omitempty"`
+	Hooks        *specs.Hooks      `json:"hooks,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+	Stdout       string            `json:"stdout,omitempty"`
+	Stderr       string            `json:"stderr,omitempty"`
+	DependsOn    []string          `json:"dependsOn,omitempty"`
+}
+
+// loadManifest reads the manifest at path, unknown fields are rejected so
+// typos don't go unnoticed.
+func loadManifest(path string) (*manifest, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &manifest{}
+	if err := yaml.UnmarshalStrict(b, m); err != nil {
+		return nil, fmt.Errorf("parsing manifest %s: %w", path, err)
+	}
+
+	ids := map[string]bool{}
+	for _, c := range m.Containers {
+		if len(c.ID) <= 0 {
+			return nil, fmt.Errorf("manifest %s has a container without id", path)
+		}
+		if ids[c.ID] {
+			return nil, fmt.Errorf("manifest %s declares container %s twice", path, c.ID)
+		}
+		ids[c.ID] = true
+	}
+
+	for _, c := range m.Containers {
+		for _, dep := range c.DependsOn {
+			if !ids[dep] {
+				return nil, fmt.Errorf("container %s depends on undeclared container %s", c.ID, dep)
+			}
+		}
+	}
+
+	if vm := m.VM; vm != nil && len(vm.AgentTimeout) > 0 {
+		if _, err := time.ParseDuration(vm.AgentTimeout); err != nil {
+			return nil, fmt.Errorf("manifest %s has an invalid agentTimeout: %w", path, err)
+		}
+	}
+
+	drives := map[string]bool{}
+	for _, d := range m.Drives {
+		if len(d.ID) <= 0 || len(d.Path) <= 0 {
+			return nil, fmt.Errorf("manifest %s has a drive without id or path", path)
+		}
+		if drives[d.ID] {
+			return nil, fmt.Errorf("manifest %s declares drive %s twice", path, d.ID)
+		}
+		drives[d.ID] = true
+	}
+
+	if m.Primary != nil && !ids[m.Primary.ID] {
+		return nil, fmt.Errorf("primary container %s isn't declared", m.Primary.ID)
+	}
+
+	return m, nil
+}
+
+// locate points b at the agent of the VM, for the address flags not given
+// on the command line, and checks the policy against where it ends up.
+func (vm *manifestVM) locate(ctx context.Context, b *baseCmd, f *flag.FlagSet) error {
+	if vm == nil {
+		return checkTarget(ctx, b)
+	}
+
+	set := map[string]bool{}
+	f.Visit(func(fl *flag.Flag) {
+		set[fl.Name] = true
+	})
+
+	if vm.CID != 0 && !set["cid"] {
+		b.cid = vm.CID
+	}
+	if vm.Port != 0 && !set["port"] {
+		b.port = vm.Port
+	}
+	if len(vm.UnixSocket) > 0 && !set["unix-socket"] {
+		b.unixSocket = vm.UnixSocket
+	}
+
+	return checkTarget(ctx, b)
+}
+
+// ordered returns the containers with each one after its dependencies, and
+// otherwise in the order they are declared.
+func (m *manifest) ordered() ([]*manifestContainer, error) {
+	byId := map[string]*manifestContainer{}
+	for _, c := range m.Containers {
+		byId[c.ID] = c
+	}
+
+	const (
+		visiting = 1
+		visited  = 2
+	)
+
+	state := map[string]int{}
+	var ordered []*manifestContainer
+
+	var visit func(c *manifestContainer) error
+	visit = func(c *manifestContainer) error {
+		switch state[c.ID] {
+		case visited:
+			return nil
+		case visiting:
+			return fmt.Errorf("dependency cycle through container %s", c.ID)
+		}
+
+		state[c.ID] = visiting
+		for _, dep := range c.DependsOn {
+			if err := visit(byId[dep]); err != nil {
+				return err
+			}
+		}
+		state[c.ID] = visited
+
+		ordered = append(ordered, c)
+		return nil
+	}
+
+	for _, c := range m.Containers {
+		if err := visit(c); err != nil {
+			return nil, err
+		}
+	}
+
+	return ordered, nil
+}
+
+// request builds the create request of the container.
+func (c *manifestContainer) request() (*shim.CreateTaskRequest, error) {
+	env, err := resolveEnv(c.DefaultEnv, c.Env)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := newSpec(c.Namespace, c.CgroupParent, c.ID, c.PidNs, c.Priv, c.Args, c.Mounts)
+	spec.Process.Env = env
+	spec.Hooks = c.Hooks
+	annotate(spec, c.Labels, c.Annotations)
+
+	if len(c.Cwd) > 0 {
+		spec.Process.Cwd = c.Cwd
+	}
+
+	rootfs := c.Rootfs
+	if rootfs == nil {
+		rootfs = &types.Mount{}
+	}
+
+	req, err := newCreateTaskRequest(c.ID, c.Bundle, spec, rootfs, &proto.ExtraData{})
+	if err != nil {
+		return nil, err
+	}
+	req.Stdout = c.Stdout
+	req.Stderr = c.Stderr
+
+	return req, nil
+}
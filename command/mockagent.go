@@ -0,0 +1,93 @@
+package command
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/dehydr8/firecracker-containerd-agent-client/testserver"
+	"github.com/google/subcommands"
+)
+
+// methodErrors collects repeated -error Service/Method=message flags.
+type methodErrors map[string]string
+
+func (m methodErrors) String() string {
+	return fmt.Sprint(map[string]string(m))
+}
+
+func (m methodErrors) Set(value string) error {
+	key, msg, ok := strings.Cut(value, "=")
+	if !ok {
+		return fmt.Errorf("expected Service/Method=message, got %q", value)
+	}
+	m[key] = msg
+	return nil
+}
+
+// MockAgentCmd serves a mock agent on a unix socket, it is hidden from the
+// help output since it only exists for testing this tool.
+type MockAgentCmd struct {
+	socket     string
+	delay      time.Duration
+	errors     methodErrors
+	stdout     string
+	stderr     string
+	echoStdin  bool
+	exitStatus uint
+	lifetime   time.Duration
+}
+
+func (*MockAgentCmd) Name() string     { return "mock-agent" }
+func (*MockAgentCmd) Synopsis() string { return "Serve a mock agent on a unix socket" }
+func (*MockAgentCmd) Usage() string {
+	return `mock-agent -socket <path>:
+	Serve a mock agent for testing, use -unix-socket <path> on the other commands to reach it.
+  `
+}
+
+func (p *MockAgentCmd) SetFlags(f *flag.FlagSet) {
+	p.errors = methodErrors{}
+	f.StringVar(&p.socket, "socket", "", "Unix socket to serve on")
+	f.DurationVar(&p.delay, "delay", 0, "Delay before answering every call")
+	f.Var(p.errors, "error", "Fail a method, as Service/Method=message (repeatable)")
+	f.StringVar(&p.stdout, "stdout", "", "Output written to the stdout of every process")
+	f.StringVar(&p.stderr, "stderr", "", "Output written to the stderr of every process")
+	f.BoolVar(&p.echoStdin, "echo-stdin", false, "Copy the stdin of processes to their stdout")
+	f.UintVar(&p.exitStatus, "exit-status", 0, "Exit status of every process")
+	f.DurationVar(&p.lifetime, "lifetime", 0, "How long the init processes of containers run before exiting, unless killed")
+}
+
+func (p *MockAgentCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	if len(p.socket) <= 0 {
+		logf(ctx, "No socket defined")
+		return subcommands.ExitFailure
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	server := testserver.New(p.socket, testserver.Behavior{
+		Delay:      p.delay,
+		Errors:     p.errors,
+		Stdout:     []byte(p.stdout),
+		Stderr:     []byte(p.stderr),
+		EchoStdin:  p.echoStdin,
+		ExitStatus: uint32(p.exitStatus),
+		Lifetime:   p.lifetime,
+	})
+
+	logf(ctx, "Serving mock agent on %s\n", p.socket)
+
+	if err := server.Serve(ctx); err != nil {
+		logf(ctx, "Failure serving mock agent: %s\n", err)
+		return subcommands.ExitFailure
+	}
+
+	return subcommands.ExitSuccess
+}
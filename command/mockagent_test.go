@@ -0,0 +1,179 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	shim "github.com/containerd/containerd/api/runtime/task/v2"
+	"github.com/dehydr8/firecracker-containerd-agent-client/testserver"
+	"github.com/dehydr8/firecracker-containerd-agent-client/util"
+	"github.com/google/subcommands"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// startMockAgent serves a mock agent behaving as behavior until the test
+// ends, and returns its socket.
+func startMockAgent(t *testing.T, behavior testserver.Behavior) string {
+	t.Helper()
+
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	ctx, cancel := context.WithCancel(context.Background())
+
+	served := make(chan error, 1)
+	go func() {
+		served <- testserver.New(socket, behavior).Serve(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-served; err != nil {
+			t.Errorf("serving mock agent: %v", err)
+		}
+	})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn, err := net.Dial("unix", socket)
+		if err == nil {
+			conn.Close()
+			return socket
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("mock agent not listening: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// runCommand parses args into cmd and executes it.
+func runCommand(t *testing.T, cmd subcommands.Command, args ...string) subcommands.ExitStatus {
+	t.Helper()
+
+	f := flag.NewFlagSet(cmd.Name(), flag.ContinueOnError)
+	cmd.SetFlags(f)
+	if err := f.Parse(args); err != nil {
+		t.Fatalf("parsing %s flags: %v", cmd.Name(), err)
+	}
+
+	return cmd.Execute(context.Background(), f)
+}
+
+func TestMockAgentCreateExec(t *testing.T) {
+	socket := startMockAgent(t, testserver.Behavior{EchoStdin: true})
+
+	idFile := filepath.Join(t.TempDir(), "id")
+	if status := runCommand(t, &CreateCmd{}, "-unix-socket", socket, "-id-file", idFile, "sh"); status != subcommands.ExitSuccess {
+		t.Fatalf("create exited with %d", status)
+	}
+
+	id, err := os.ReadFile(idFile)
+	if err != nil {
+		t.Fatalf("reading container ID: %v", err)
+	}
+	containerId := strings.TrimSpace(string(id))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	b := &baseCmd{
+		unixSocket: socket,
+		IDs:        &util.SequentialIDGenerator{Prefix: "exec-"},
+	}
+	client, cleanup, err := b.newClient(ctx)
+	if err != nil {
+		t.Fatalf("connecting to mock agent: %v", err)
+	}
+	defer cleanup()
+
+	var stdout bytes.Buffer
+	result, err := execProcess(ctx, b, client, containerId, []string{"cat"}, strings.NewReader("ping"), &stdout)
+	if err != nil {
+		t.Fatalf("exec: %v", err)
+	}
+
+	if result.ExitStatus != 0 {
+		t.Errorf("exec exited with %d", result.ExitStatus)
+	}
+	if stdout.String() != "ping" {
+		t.Errorf("got stdout %q, want %q", stdout.String(), "ping")
+	}
+
+	// the exec is deleted once it exited
+	err = client.Call(ctx, serviceName, stateMethodName, &shim.StateRequest{
+		ID:     containerId,
+		ExecID: result.ExecID,
+	}, &shim.StateResponse{})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("got %v for the state of the exec, want NotFound", err)
+	}
+}
+
+func TestMockAgentApplyReconcilePrunes(t *testing.T) {
+	socket := startMockAgent(t, testserver.Behavior{})
+
+	dir := t.TempDir()
+	store := &containerStore{path: filepath.Join(dir, storeFileName)}
+	ctx := WithGlobals(context.Background(), &Globals{Store: store.path})
+
+	manifest := filepath.Join(dir, "manifest.json")
+	apply := func(declared string) {
+		t.Helper()
+
+		if err := os.WriteFile(manifest, []byte(declared), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		cmd := &ApplyCmd{}
+		f := flag.NewFlagSet(cmd.Name(), flag.ContinueOnError)
+		cmd.SetFlags(f)
+		if err := f.Parse([]string{"-unix-socket", socket, "-f", manifest, "-reconcile"}); err != nil {
+			t.Fatal(err)
+		}
+		if status := cmd.Execute(ctx, f); status != subcommands.ExitSuccess {
+			t.Fatalf("apply exited with %d", status)
+		}
+	}
+
+	apply(`{"containers":[{"id":"web","args":["sh"]},{"id":"db","args":["sh"]}]}`)
+
+	// not from the manifest, left alone
+	if err := store.add((&baseCmd{unixSocket: socket}).target(), "other", nil, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	apply(`{"containers":[{"id":"web","args":["sh"]}]}`)
+
+	containers, err := store.read()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []string
+	for _, c := range containers {
+		ids = append(ids, c.ID)
+	}
+	slices.Sort(ids)
+	if got, want := strings.Join(ids, ","), "other,web"; got != want {
+		t.Errorf("recorded containers %s, want %s", got, want)
+	}
+
+	b := &baseCmd{unixSocket: socket}
+	client, cleanup, err := b.newClient(ctx)
+	if err != nil {
+		t.Fatalf("connecting to mock agent: %v", err)
+	}
+	defer cleanup()
+
+	err = client.Call(ctx, serviceName, stateMethodName, &shim.StateRequest{ID: "db"}, &shim.StateResponse{})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("got %v for the state of db, want NotFound", err)
+	}
+}
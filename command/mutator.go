@@ -0,0 +1,54 @@
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+const (
+	mutateContainer = "container"
+	mutateProcess   = "process"
+)
+
+// runMutator pipes v as JSON through the program at path, given kind as its
+// argument, and replaces v with the JSON it writes back. The program rejects
+// the spec by exiting with a status other than 0, its stderr being the
+// reason. Fields the spec doesn't have are refused, so typos don't go
+// unnoticed.
+func runMutator[T any](path, kind string, v *T) error {
+	in, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.Command(path, kind)
+	cmd.Stdin = bytes.NewReader(in)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); len(msg) > 0 {
+			return fmt.Errorf("spec mutator %s: %w: %s", path, err, msg)
+		}
+		return fmt.Errorf("spec mutator %s: %w", path, err)
+	}
+
+	// decoded into a fresh spec, what the mutator left out is gone rather
+	// than kept from v
+	var mutated T
+
+	dec := json.NewDecoder(&stdout)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&mutated); err != nil {
+		return fmt.Errorf("spec mutator %s wrote an invalid spec: %w", path, err)
+	}
+
+	*v = mutated
+
+	return nil
+}
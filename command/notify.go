@@ -0,0 +1,92 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+const (
+	notifyExit = "exit"
+	notifyOOM  = "oom"
+)
+
+// notifyHooks are the -on-exit and -on-oom commands, run on the host with
+// sh -c when a container exits or is OOM killed. The event is passed in the
+// environment: FC_EVENT is exit or oom, FC_TARGET the agent, FC_EVENT_JSON
+// the payload, and each top level field of the payload is FC_ and its name
+// in upper case, like FC_CONTAINER_ID or FC_EXIT_STATUS.
+type notifyHooks struct {
+	onExit string
+	onOOM  string
+}
+
+func (n *notifyHooks) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&n.onExit, "on-exit", "", "Command run with sh -c on the host when the container exits, with the event in FC_* environment variables")
+	f.StringVar(&n.onOOM, "on-oom", "", "Command run with sh -c on the host when the container is OOM killed, with the event in FC_* environment variables")
+}
+
+// notify runs the hook of kind, if any, with payload, the JSON of the
+// event. It returns once the hook exited, its output goes to stderr. A
+// failing hook is only logged, it doesn't stop watching the container.
+func (n *notifyHooks) notify(ctx context.Context, b *baseCmd, kind string, payload []byte) {
+	command := n.onExit
+	if kind == notifyOOM {
+		command = n.onOOM
+	}
+	if len(command) <= 0 {
+		return
+	}
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Env = append(os.Environ(), notifyEnv(kind, b.target(), payload)...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		logf(ctx, "Failure running -on-%s hook: %s\n", kind, err)
+	}
+}
+
+// notifyEnv is the environment a hook of kind gets for payload.
+func notifyEnv(kind, target string, payload []byte) []string {
+	env := []string{
+		"FC_EVENT=" + kind,
+		"FC_TARGET=" + target,
+		"FC_EVENT_JSON=" + string(payload),
+	}
+
+	var fields map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	if err := decoder.Decode(&fields); err != nil {
+		return env
+	}
+
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		var value string
+		switch v := fields[name].(type) {
+		case string:
+			value = v
+		case json.Number:
+			value = v.String()
+		default:
+			out, _ := json.Marshal(v)
+			value = string(out)
+		}
+		env = append(env, "FC_"+strings.ToUpper(name)+"="+value)
+	}
+
+	return env
+}
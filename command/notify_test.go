@@ -0,0 +1,33 @@
+package command
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestNotifyEnv(t *testing.T) {
+	payload := `{"container_id":"c1","id":"e1","pid":42,"exit_status":137,"exited_at":"2024-01-02T03:04:05Z","labels":{"a":"b"}}`
+
+	got := notifyEnv(notifyExit, "vsock:3", []byte(payload))
+	want := []string{
+		"FC_EVENT=exit",
+		"FC_TARGET=vsock:3",
+		"FC_EVENT_JSON=" + payload,
+		"FC_CONTAINER_ID=c1",
+		"FC_EXIT_STATUS=137",
+		"FC_EXITED_AT=2024-01-02T03:04:05Z",
+		"FC_ID=e1",
+		`FC_LABELS={"a":"b"}`,
+		"FC_PID=42",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("notifyEnv() = %q, want %q", got, want)
+	}
+
+	// undecoded events only have the basics
+	got = notifyEnv(notifyOOM, "vsock:3", nil)
+	want = []string{"FC_EVENT=oom", "FC_TARGET=vsock:3", "FC_EVENT_JSON="}
+	if !slices.Equal(got, want) {
+		t.Errorf("notifyEnv() = %q, want %q", got, want)
+	}
+}
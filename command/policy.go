@@ -0,0 +1,169 @@
+package command
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+
+	"github.com/google/subcommands"
+	"sigs.k8s.io/yaml"
+)
+
+// defaultPolicyRole is the role applied when -policy is given without
+// -policy-role.
+const defaultPolicyRole = "default"
+
+// policy restricts what the invocations of a wrapper may do, per role. It is
+// enforced by the client, so it guards against mistakes rather than against
+// someone running the binary without it.
+type policy struct {
+	Roles map[string]*policyRole `json:"roles"`
+}
+
+// policyRole lists what a role may use, empty lists allow everything.
+type policyRole struct {
+	// Commands are the subcommands the role may run
+	Commands []string `json:"commands,omitempty"`
+	// DeniedFlags are the flags the role may not set, per subcommand, with
+	// "*" applying to every subcommand
+	DeniedFlags map[string][]string `json:"deniedFlags,omitempty"`
+	// CIDs are the vsock context IDs the role may target
+	CIDs []int `json:"cids,omitempty"`
+}
+
+func loadPolicy(path string) (*policy, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &policy{}
+	if err := yaml.UnmarshalStrict(b, p); err != nil {
+		return nil, fmt.Errorf("parsing policy %s: %w", path, err)
+	}
+
+	return p, nil
+}
+
+// lateTarget is implemented by the subcommands that may take the address
+// of the agent from elsewhere than their flags, they call checkTarget once
+// they know it.
+type lateTarget interface {
+	lateTarget()
+}
+
+// check rejects running the subcommand name with the flags set in f. The
+// -cid and -unix-socket flags are left to checkTarget with lateTarget.
+func (r *policyRole) check(name string, f *flag.FlagSet, lateTarget bool) error {
+	if len(r.Commands) > 0 && !slices.Contains(r.Commands, name) {
+		return fmt.Errorf("policy doesn't allow the %s command", name)
+	}
+
+	var err error
+	f.Visit(func(fl *flag.Flag) {
+		if err == nil && (slices.Contains(r.DeniedFlags[name], fl.Name) || slices.Contains(r.DeniedFlags["*"], fl.Name)) {
+			err = fmt.Errorf("policy doesn't allow -%s with the %s command", fl.Name, name)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	if lateTarget {
+		return nil
+	}
+
+	// like in checkTarget, a unix socket can be any VM's vsock
+	if fl := f.Lookup("unix-socket"); fl != nil && len(fl.Value.String()) > 0 && len(r.CIDs) > 0 {
+		return fmt.Errorf("policy doesn't allow targeting unix socket %s, it restricts the CIDs", fl.Value.String())
+	}
+
+	if fl := f.Lookup("cid"); fl != nil {
+		cid, _ := strconv.Atoi(fl.Value.String())
+		if err := r.checkCID(cid); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *policyRole) checkCID(cid int) error {
+	if len(r.CIDs) > 0 && !slices.Contains(r.CIDs, cid) {
+		return fmt.Errorf("policy doesn't allow targeting CID %d", cid)
+	}
+	return nil
+}
+
+// checkTarget applies the CIDs of the policy to the agent b ended up
+// pointed at, for commands that take its address from elsewhere than their
+// flags. A unix socket can't be told apart, it is refused when the CIDs are
+// restricted.
+func checkTarget(ctx context.Context, b *baseCmd) error {
+	role, err := policyRoleFrom(ctx)
+	if err != nil || role == nil {
+		return err
+	}
+
+	if len(b.unixSocket) > 0 && len(role.CIDs) > 0 {
+		return fmt.Errorf("policy doesn't allow targeting unix socket %s, it restricts the CIDs", b.unixSocket)
+	}
+
+	return role.checkCID(b.cid)
+}
+
+// policyRoleFrom returns the role of -policy that applies, nil without
+// -policy.
+func policyRoleFrom(ctx context.Context) (*policyRole, error) {
+	g := globalsFrom(ctx)
+
+	if len(g.Policy) <= 0 {
+		return nil, nil
+	}
+
+	pol, err := loadPolicy(g.Policy)
+	if err != nil {
+		return nil, fmt.Errorf("loading policy: %w", err)
+	}
+
+	role, ok := pol.Roles[g.PolicyRole]
+	if !ok {
+		return nil, fmt.Errorf("policy has no role %s", g.PolicyRole)
+	}
+
+	return role, nil
+}
+
+// policyCmd checks the policy given with -policy, if any, before running the
+// wrapped subcommand, and warns about the deprecated flags it was given.
+type policyCmd struct {
+	subcommands.Command
+}
+
+// WithPolicy wraps cmd so that it is subject to -policy.
+func WithPolicy(cmd subcommands.Command) subcommands.Command {
+	return &policyCmd{Command: cmd}
+}
+
+func (p *policyCmd) Execute(ctx context.Context, f *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	warnDeprecated(ctx, os.Stderr, p.Name(), f)
+
+	role, err := policyRoleFrom(ctx)
+	if err != nil {
+		logf(ctx, "Failure applying policy: %s\n", err)
+		return subcommands.ExitFailure
+	}
+
+	if role != nil {
+		_, late := p.Command.(lateTarget)
+		if err := role.check(p.Name(), f, late); err != nil {
+			logf(ctx, "%s\n", err)
+			return subcommands.ExitUsageError
+		}
+	}
+
+	return p.Command.Execute(ctx, f, args...)
+}
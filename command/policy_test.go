@@ -0,0 +1,59 @@
+package command
+
+import (
+	"flag"
+	"testing"
+)
+
+func TestPolicyRoleCheck(t *testing.T) {
+	role := &policyRole{
+		Commands:    []string{"exec", "up"},
+		DeniedFlags: map[string][]string{"exec": {"tty"}},
+		CIDs:        []int{5},
+	}
+
+	tests := []struct {
+		name       string
+		cmd        string
+		args       []string
+		lateTarget bool
+		wantErr    bool
+	}{
+		{"allowed cid", "exec", []string{"-cid", "5"}, false, false},
+		{"other cid", "exec", []string{"-cid", "6"}, false, true},
+		{"unix socket", "exec", []string{"-cid", "5", "-unix-socket", "/run/other-vm.vsock"}, false, true},
+		{"unix socket left to checkTarget", "up", []string{"-unix-socket", "/run/other-vm.vsock"}, true, false},
+		{"denied flag", "exec", []string{"-cid", "5", "-tty"}, false, true},
+		{"command not allowed", "delete", []string{"-cid", "5"}, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := flag.NewFlagSet(tt.cmd, flag.ContinueOnError)
+			f.Int("cid", 0, "")
+			f.String("unix-socket", "", "")
+			f.Bool("tty", false, "")
+			if err := f.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+
+			err := role.check(tt.cmd, f, tt.lateTarget)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("check() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPolicyRoleCheckUnixSocketUnrestricted(t *testing.T) {
+	f := flag.NewFlagSet("exec", flag.ContinueOnError)
+	f.Int("cid", 0, "")
+	f.String("unix-socket", "", "")
+	if err := f.Parse([]string{"-unix-socket", "/run/vm.vsock"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := (&policyRole{}).check("exec", f, false); err != nil {
+		t.Errorf("check() = %v, want no error without CIDs", err)
+	}
+}
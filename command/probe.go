@@ -0,0 +1,137 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/containerd/ttrpc"
+)
+
+const (
+	defaultReadyTimeout = 30 * time.Second
+	defaultLiveInterval = 10 * time.Second
+
+	// readyProbeInterval paces the readiness probe until it passes
+	readyProbeInterval = time.Second
+)
+
+// probeFlags are the exec probes of job. Probes are run with sh -c in the
+// container and fail when they exit with non-zero.
+type probeFlags struct {
+	readyCmd     string
+	readyTimeout time.Duration
+	liveCmd      string
+	liveInterval time.Duration
+}
+
+func (pf *probeFlags) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&pf.readyCmd, "ready-cmd", "", "Readiness probe, run with sh -c in the container until it exits with 0")
+	f.DurationVar(&pf.readyTimeout, "ready-timeout", defaultReadyTimeout, "How long -ready-cmd is given to pass before the container is stopped")
+	f.StringVar(&pf.liveCmd, "live-cmd", "", "Liveness probe, run with sh -c in the container once ready, the container is stopped when it exits with non-zero")
+	f.DurationVar(&pf.liveInterval, "live-interval", defaultLiveInterval, "Pause between -live-cmd runs, and how long each is given")
+}
+
+func (pf *probeFlags) check() error {
+	if len(pf.readyCmd) > 0 && pf.readyTimeout <= 0 {
+		return errors.New("-ready-timeout must be positive")
+	}
+	if len(pf.liveCmd) > 0 && pf.liveInterval <= 0 {
+		return errors.New("-live-interval must be positive")
+	}
+	return nil
+}
+
+// watch probes the started container id: the readiness probe until it
+// passes, then the liveness probe every interval. The returned channel gets
+// why the container failed its probes, if it does, and is closed once
+// probing stopped, which ctx ending does.
+func (pf *probeFlags) watch(ctx context.Context, b *baseCmd, client *ttrpc.Client, id string) <-chan string {
+	failed := make(chan string, 1)
+
+	go func() {
+		defer close(failed)
+
+		if len(pf.readyCmd) > 0 {
+			if reason := pf.waitReady(ctx, b, client, id); len(reason) > 0 {
+				failed <- reason
+				return
+			}
+		}
+
+		if len(pf.liveCmd) <= 0 {
+			return
+		}
+
+		for {
+			select {
+			case <-time.After(pf.liveInterval):
+			case <-ctx.Done():
+				return
+			}
+
+			probeCtx, cancel := context.WithTimeout(ctx, pf.liveInterval)
+			err := probe(probeCtx, b, client, id, pf.liveCmd)
+			cancel()
+
+			if err != nil && ctx.Err() == nil {
+				failed <- fmt.Sprintf("liveness probe %s", err)
+				return
+			}
+		}
+	}()
+
+	return failed
+}
+
+// waitReady runs the readiness probe until it passes, and returns why the
+// container isn't ready if it doesn't in time.
+func (pf *probeFlags) waitReady(ctx context.Context, b *baseCmd, client *ttrpc.Client, id string) string {
+	started := time.Now()
+
+	readyCtx, cancel := context.WithTimeout(ctx, pf.readyTimeout)
+	defer cancel()
+
+	var last error
+
+	for {
+		err := probe(readyCtx, b, client, id, pf.readyCmd)
+		if err == nil {
+			logf(ctx, "Container %s ready after %s\n", id, time.Since(started).Round(time.Millisecond))
+			return ""
+		}
+
+		// a probe cut short by the timeout says less than the one before
+		if readyCtx.Err() == nil || last == nil {
+			last = err
+		}
+
+		select {
+		case <-time.After(readyProbeInterval):
+		case <-readyCtx.Done():
+		}
+
+		if ctx.Err() != nil {
+			return ""
+		}
+		if readyCtx.Err() != nil {
+			return fmt.Sprintf("not ready after %s, readiness probe %s", pf.readyTimeout, last)
+		}
+	}
+}
+
+// probe runs cmd in container id, it fails unless cmd exits with 0.
+func probe(ctx context.Context, b *baseCmd, client *ttrpc.Client, id, cmd string) error {
+	exit, err := execProcess(ctx, b, client, id, []string{"sh", "-c", cmd}, nil, nil)
+	if err != nil {
+		return fmt.Errorf("failed: %w", err)
+	}
+
+	if exit.ExitStatus != 0 {
+		return fmt.Errorf("exited with %d", exit.ExitStatus)
+	}
+
+	return nil
+}
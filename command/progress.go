@@ -0,0 +1,88 @@
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	progressJSON = "json"
+	progressBar  = "bar"
+)
+
+// progressSteps are the steps of a run of a container, in the order they
+// happen: the IO streams are attached while Create is still in flight.
+var progressSteps = []string{"attached", "created", "started", "waiting", "exited", "deleted"}
+
+// progressEvent is a step of a run as -progress json writes it, one line
+// each.
+type progressEvent struct {
+	Time        time.Time `json:"time"`
+	Step        string    `json:"step"`
+	ContainerID string    `json:"container_id"`
+	Run         int       `json:"run"`
+	ExitStatus  *uint32   `json:"exit_status,omitempty"`
+}
+
+// progressReporter writes the steps of the runs of a container for -progress,
+// as JSON lines or a bar of the steps done, nothing when its format is
+// empty. It writes to stderr unless w is set.
+type progressReporter struct {
+	format string
+	w      io.Writer
+}
+
+func (r *progressReporter) String() string {
+	return r.format
+}
+
+func (r *progressReporter) Set(value string) error {
+	switch value {
+	case "", progressJSON, progressBar:
+		r.format = value
+		return nil
+	}
+	return fmt.Errorf("unknown progress format %s, expected json or bar", value)
+}
+
+// step reports step of run, the first being 0, of container id. Like the
+// logs, failing to write it doesn't stop the run.
+func (r *progressReporter) step(id string, run int, step string, exitStatus *uint32) {
+	w := r.w
+	if w == nil {
+		w = os.Stderr
+	}
+
+	switch r.format {
+	case progressJSON:
+		// a progressEvent always marshals
+		out, _ := json.Marshal(&progressEvent{
+			Time:        time.Now().UTC(),
+			Step:        step,
+			ContainerID: id,
+			Run:         run,
+			ExitStatus:  exitStatus,
+		})
+		fmt.Fprintln(w, string(out))
+	case progressBar:
+		done := 0
+		for i, s := range progressSteps {
+			if s == step {
+				done = i + 1
+			}
+		}
+
+		bar := strings.Repeat("#", done) + strings.Repeat("-", len(progressSteps)-done)
+		if exitStatus != nil {
+			step = fmt.Sprintf("%s with %d", step, *exitStatus)
+		}
+		if run > 0 {
+			id = fmt.Sprintf("%s (run %d)", id, run)
+		}
+		fmt.Fprintf(w, "[%s] %d/%d %s %s\n", bar, done, len(progressSteps), id, step)
+	}
+}
@@ -0,0 +1,44 @@
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestProgressReporter(t *testing.T) {
+	exitStatus := uint32(3)
+
+	var buf bytes.Buffer
+	bar := &progressReporter{format: progressBar, w: &buf}
+	bar.step("c1", 0, "created", nil)
+	bar.step("c1", 1, "exited", &exitStatus)
+
+	want := "[##----] 2/6 c1 created\n[#####-] 5/6 c1 (run 1) exited with 3\n"
+	if got := buf.String(); got != want {
+		t.Errorf("bar = %q, want %q", got, want)
+	}
+
+	buf.Reset()
+	lines := &progressReporter{format: progressJSON, w: &buf}
+	lines.step("c1", 1, "exited", &exitStatus)
+
+	var event progressEvent
+	if err := json.Unmarshal(buf.Bytes(), &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Step != "exited" || event.ContainerID != "c1" || event.Run != 1 || event.ExitStatus == nil || *event.ExitStatus != 3 || event.Time.IsZero() {
+		t.Errorf("json = %s", buf.String())
+	}
+
+	buf.Reset()
+	none := &progressReporter{w: &buf}
+	none.step("c1", 0, "created", nil)
+	if buf.Len() != 0 {
+		t.Errorf("no format wrote %q", buf.String())
+	}
+
+	if err := (&progressReporter{}).Set("xml"); err == nil {
+		t.Error("Set(xml) accepted")
+	}
+}
@@ -0,0 +1,52 @@
+package command
+
+import (
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// decodeRecorded renders the payloads of a recorded call as JSON, using the
+// request mapping of the call subcommand to find their types.
+func decodeRecorded(service, method string, req, res []byte) ([]byte, []byte) {
+	pair, ok := requestMapping[service+"/"+method]
+	if !ok {
+		return nil, nil
+	}
+
+	return decodeAs(pair.req, req), decodeAs(pair.res, res)
+}
+
+// responseType gives the strict mode a new response of the method, using the
+// request mapping of the call subcommand to find its type.
+func responseType(service, method string) proto.Message {
+	pair, ok := requestMapping[service+"/"+method]
+	if !ok {
+		return nil
+	}
+
+	m, ok := pair.res.(proto.Message)
+	if !ok {
+		return nil
+	}
+
+	return m.ProtoReflect().New().Interface()
+}
+
+func decodeAs(template interface{}, payload []byte) []byte {
+	m, ok := template.(proto.Message)
+	if !ok {
+		return nil
+	}
+
+	msg := m.ProtoReflect().New().Interface()
+	if err := proto.Unmarshal(payload, msg); err != nil {
+		return nil
+	}
+
+	b, err := protojson.Marshal(msg)
+	if err != nil {
+		return nil
+	}
+
+	return b
+}
@@ -0,0 +1,63 @@
+package command
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+)
+
+// recordWriter writes the records of commands printing until interrupted,
+// one line each. Unbuffered, every record reaches the reader as it is
+// written, for consumers like jq -c. With a buffer, records are held until
+// it fills or flush is called, which those commands do whenever the agent
+// has nothing new.
+type recordWriter struct {
+	w   io.Writer
+	buf *bufio.Writer
+}
+
+// newRecordWriter returns a writer to w buffering up to size bytes, none
+// when size is 0.
+func newRecordWriter(w io.Writer, size int) *recordWriter {
+	rw := &recordWriter{w: w}
+	if size > 0 {
+		rw.buf = bufio.NewWriterSize(w, size)
+		rw.w = rw.buf
+	}
+	return rw
+}
+
+// line writes s as one line, in a single write.
+func (rw *recordWriter) line(s string) error {
+	_, err := io.WriteString(rw.w, s+"\n")
+	return err
+}
+
+func (rw *recordWriter) flush() error {
+	if rw.buf == nil {
+		return nil
+	}
+	return rw.buf.Flush()
+}
+
+// printRecord writes what -jsonpath or -go-template pick out of v, v as
+// JSON with -output json, or text otherwise.
+func (b *baseCmd) printRecord(rw *recordWriter, v interface{}, text string) error {
+	out, ok, err := b.extract.extract(v)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return rw.line(out)
+	}
+
+	if b.output == outputJSON {
+		out, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		return rw.line(string(out))
+	}
+
+	return rw.line(text)
+}
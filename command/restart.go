@@ -0,0 +1,70 @@
+package command
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	restartNo        = "no"
+	restartOnFailure = "on-failure"
+)
+
+// restartPolicy is a -restart no|on-failure[:max] flag, the zero value is no.
+type restartPolicy struct {
+	onFailure bool
+
+	// max restarts, 0 for no limit
+	max int
+}
+
+func (r *restartPolicy) String() string {
+	switch {
+	case !r.onFailure:
+		return restartNo
+	case r.max > 0:
+		return fmt.Sprintf("%s:%d", restartOnFailure, r.max)
+	default:
+		return restartOnFailure
+	}
+}
+
+func (r *restartPolicy) Set(value string) error {
+	mode, max, hasMax := strings.Cut(value, ":")
+
+	switch {
+	case mode == restartNo && !hasMax:
+		*r = restartPolicy{}
+		return nil
+	case mode != restartOnFailure:
+		return fmt.Errorf("expected no or on-failure[:max], got %q", value)
+	}
+
+	r.onFailure = true
+	r.max = 0
+
+	if hasMax {
+		n, err := strconv.Atoi(max)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid maximum restart count %q", max)
+		}
+		r.max = n
+	}
+
+	return nil
+}
+
+// allows reports whether a process exiting with non-zero is restarted
+// once more, after restarts restarts.
+func (r *restartPolicy) allows(restarts int) bool {
+	return r.onFailure && (r.max <= 0 || restarts < r.max)
+}
+
+// count tells which restart restarts is, for the logs.
+func (r *restartPolicy) count(restarts int) string {
+	if r.max > 0 {
+		return fmt.Sprintf("restart %d of %d", restarts, r.max)
+	}
+	return fmt.Sprintf("restart %d", restarts)
+}
@@ -0,0 +1,116 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/containerd/ttrpc"
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+const (
+	// secretsDir is the tmpfs secrets are pushed onto, so they never touch
+	// the disk of the VM
+	secretsDir = "/run/secrets"
+
+	defaultSecretMode = os.FileMode(0400)
+)
+
+type secret struct {
+	name string
+	path string
+	mode os.FileMode
+}
+
+// secretFlags collects repeated -secret name=/local/path[:mode] flags.
+type secretFlags []secret
+
+func (s *secretFlags) String() string {
+	names := make([]string, 0, len(*s))
+	for _, sec := range *s {
+		names = append(names, sec.name)
+	}
+	return strings.Join(names, ",")
+}
+
+func (s *secretFlags) Set(value string) error {
+	name, localPath, ok := strings.Cut(value, "=")
+	if !ok || len(name) <= 0 || len(localPath) <= 0 {
+		return fmt.Errorf("expected name=/local/path[:mode], got %q", value)
+	}
+
+	if strings.ContainsRune(name, '/') || name == "." || name == ".." {
+		return fmt.Errorf("invalid secret name %q", name)
+	}
+
+	sec := secret{
+		name: name,
+		path: localPath,
+		mode: defaultSecretMode,
+	}
+
+	if i := strings.LastIndexByte(localPath, ':'); i >= 0 {
+		mode, err := strconv.ParseUint(localPath[i+1:], 8, 32)
+		if err != nil {
+			return fmt.Errorf("invalid mode of secret %s: %w", name, err)
+		}
+		sec.path = localPath[:i]
+		sec.mode = os.FileMode(mode).Perm()
+	}
+
+	*s = append(*s, sec)
+	return nil
+}
+
+// mount adds the tmpfs the secrets are pushed onto to spec, if there are any.
+func (s secretFlags) mount(spec *specs.Spec) {
+	if len(s) <= 0 {
+		return
+	}
+
+	spec.Mounts = append(spec.Mounts, specs.Mount{
+		Destination: secretsDir,
+		Type:        "tmpfs",
+		Source:      "tmpfs",
+		Options:     []string{"nosuid", "noexec", "nodev", "mode=755", "size=1024k"},
+	})
+}
+
+// push installs the secrets into container id, which has to be created
+// already. It is meant to be done before the container is started, so its
+// process finds them in place.
+func (s secretFlags) push(ctx context.Context, b *baseCmd, client *ttrpc.Client, id string) error {
+	for _, sec := range s {
+		if err := pushSecret(ctx, b, client, id, sec); err != nil {
+			return fmt.Errorf("pushing secret %s: %w", sec.name, err)
+		}
+	}
+	return nil
+}
+
+// pushSecret execs install in container id, fed the content of the secret
+// over stdin, so the secret goes neither in the spec nor in an env var.
+func pushSecret(ctx context.Context, b *baseCmd, client *ttrpc.Client, id string, sec secret) error {
+	file, err := os.Open(sec.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	args := []string{"install", "-m", fmt.Sprintf("%o", sec.mode), "/dev/stdin", path.Join(secretsDir, sec.name)}
+
+	exit, err := execProcess(ctx, b, client, id, args, file, nil)
+	if err != nil {
+		return err
+	}
+
+	if exit.ExitStatus != 0 {
+		return fmt.Errorf("install exited with status %d", exit.ExitStatus)
+	}
+
+	return nil
+}
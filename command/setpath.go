@@ -0,0 +1,72 @@
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// applySets sets the fields named by the dotted paths of sets, each given as
+// path=value, in the JSON object input of the message md. Values of string
+// fields are taken as is, others are parsed as JSON.
+func applySets(input []byte, md protoreflect.MessageDescriptor, sets []string) ([]byte, error) {
+	obj := map[string]interface{}{}
+	if len(input) > 0 {
+		if err := json.Unmarshal(input, &obj); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, set := range sets {
+		path, value, ok := strings.Cut(set, "=")
+		if !ok || len(path) <= 0 {
+			return nil, fmt.Errorf("expected path=value, got %q", set)
+		}
+
+		if err := setPath(obj, md, strings.Split(path, "."), value); err != nil {
+			return nil, fmt.Errorf("setting %s: %w", path, err)
+		}
+	}
+
+	return json.Marshal(obj)
+}
+
+func setPath(obj map[string]interface{}, md protoreflect.MessageDescriptor, path []string, value string) error {
+	fd := md.Fields().ByName(protoreflect.Name(path[0]))
+	if fd == nil {
+		fd = md.Fields().ByJSONName(path[0])
+	}
+	if fd == nil {
+		return fmt.Errorf("%s has no field %s", md.FullName(), path[0])
+	}
+
+	name := string(fd.Name())
+
+	if len(path) > 1 {
+		if fd.Message() == nil || fd.IsList() || fd.IsMap() {
+			return fmt.Errorf("%s is not a message field", name)
+		}
+
+		child, ok := obj[name].(map[string]interface{})
+		if !ok {
+			child = map[string]interface{}{}
+			obj[name] = child
+		}
+		return setPath(child, fd.Message(), path[1:], value)
+	}
+
+	if fd.Kind() == protoreflect.StringKind && !fd.IsList() && !fd.IsMap() {
+		obj[name] = value
+		return nil
+	}
+
+	var v interface{}
+	if err := json.Unmarshal([]byte(value), &v); err != nil {
+		return fmt.Errorf("value of %s is not JSON: %w", name, err)
+	}
+	obj[name] = v
+
+	return nil
+}
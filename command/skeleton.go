@@ -0,0 +1,101 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// maxSkeletonDepth stops recursive messages from expanding forever.
+const maxSkeletonDepth = 8
+
+// writeSkeleton writes a JSON object with every field of md set to its
+// default, in the form call takes its input. Enum fields take numbers, the
+// names of their values follow them in a // comment, which has to be removed
+// before the skeleton is sent.
+func writeSkeleton(w io.Writer, md protoreflect.MessageDescriptor) {
+	writeMessage(w, md, 0)
+	fmt.Fprintln(w)
+}
+
+func writeMessage(w io.Writer, md protoreflect.MessageDescriptor, depth int) {
+	fields := md.Fields()
+	if fields.Len() <= 0 || depth >= maxSkeletonDepth {
+		fmt.Fprint(w, "{}")
+		return
+	}
+
+	indent := strings.Repeat("  ", depth+1)
+
+	fmt.Fprintln(w, "{")
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+
+		fmt.Fprintf(w, "%s%q: ", indent, fd.Name())
+
+		switch {
+		case fd.IsMap():
+			fmt.Fprintf(w, "{%s: ", scalarDefault(fd.MapKey(), true))
+			writeValue(w, fd.MapValue(), depth+1)
+			fmt.Fprint(w, "}")
+		case fd.IsList():
+			fmt.Fprint(w, "[")
+			writeValue(w, fd, depth+1)
+			fmt.Fprint(w, "]")
+		default:
+			writeValue(w, fd, depth+1)
+		}
+
+		if i < fields.Len()-1 {
+			fmt.Fprint(w, ",")
+		}
+
+		if ed := fd.Enum(); ed != nil {
+			fmt.Fprintf(w, " // %s", enumValues(ed))
+		}
+
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintf(w, "%s}", strings.Repeat("  ", depth))
+}
+
+func writeValue(w io.Writer, fd protoreflect.FieldDescriptor, depth int) {
+	if md := fd.Message(); md != nil {
+		writeMessage(w, md, depth)
+		return
+	}
+	fmt.Fprint(w, scalarDefault(fd, false))
+}
+
+// scalarDefault is the default of fd in JSON, quoted when used as a map key.
+func scalarDefault(fd protoreflect.FieldDescriptor, key bool) string {
+	var v string
+
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		v = "false"
+	case protoreflect.StringKind, protoreflect.BytesKind:
+		return `""`
+	default:
+		v = "0"
+	}
+
+	if key {
+		return `"` + v + `"`
+	}
+	return v
+}
+
+func enumValues(ed protoreflect.EnumDescriptor) string {
+	values := ed.Values()
+
+	names := make([]string, 0, values.Len())
+	for i := 0; i < values.Len(); i++ {
+		v := values.Get(i)
+		names = append(names, fmt.Sprintf("%d=%s", v.Number(), v.Name()))
+	}
+
+	return fmt.Sprintf("%s: %s", ed.Name(), strings.Join(names, ", "))
+}
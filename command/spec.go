@@ -0,0 +1,186 @@
+package command
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+const (
+	// defaultCgroupPrefix names the scope of the containers in a systemd
+	// slice when there is no namespace to name it after.
+	defaultCgroupPrefix = "firecracker"
+
+	defaultEnvBuiltin = "builtin"
+	defaultEnvNone    = "none"
+)
+
+// builtinEnv is the environment containers start from unless told otherwise
+// with -default-env.
+var builtinEnv = []string{
+	"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
+}
+
+// specFlags holds the flags that shape the OCI spec of a container, shared by
+// create and bundle prepare.
+type specFlags struct {
+	mountsConfig string
+	namespace    string
+	cgroupParent string
+	pid          string
+	priv         bool
+	defaultEnv   string
+	env          stringList
+	hooks        hookFlags
+	labels       keyValueFlags
+	annotations  keyValueFlags
+	mutator      string
+}
+
+func (s *specFlags) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&s.mountsConfig, "mounts-config", "[]", "Mounts Config JSON")
+	f.StringVar(&s.namespace, "examplens", "", "cgroup Namespace")
+	f.StringVar(&s.cgroupParent, "cgroup-parent", "", "Parent cgroup of the container, a path or a systemd slice (system.slice[:prefix]), /<examplens> when not set")
+	f.StringVar(&s.pid, "pid", "", "PID NS Path")
+	f.BoolVar(&s.priv, "priv", false, "All Capabilities")
+	f.StringVar(&s.defaultEnv, "default-env", defaultEnvBuiltin, "Environment the container starts from: builtin (PATH only), none, or a file of KEY=VALUE lines")
+	f.Var(&s.hooks, "hook", "Run an OCI hook, as stage=/path[,arg...] with stage one of "+strings.Join(hookStages, ", ")+" (repeatable)")
+	s.labels = keyValueFlags{}
+	s.annotations = keyValueFlags{}
+	f.Var(s.labels, "label", "Label the container with key=value, kept in its spec annotations (repeatable)")
+	f.Var(s.annotations, "annotation", "Set the key=value annotation in the spec of the container (repeatable)")
+	f.StringVar(&s.mutator, "spec-mutator", "", "Program the spec JSON is piped through before it is used, given \"container\" as argument; it rejects the spec by failing")
+	f.Var(&s.env, "env", "Set KEY=VALUE in the environment of the container, over the defaults (repeatable)")
+}
+
+// spec builds the spec of container id running args.
+func (s *specFlags) spec(id string, args []string) (*specs.Spec, error) {
+	var inputMounts []specs.Mount
+	if err := json.Unmarshal([]byte(s.mountsConfig), &inputMounts); err != nil {
+		return nil, fmt.Errorf("parsing mounts JSON config: %w", err)
+	}
+
+	env, err := resolveEnv(s.defaultEnv, s.env)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := newSpec(s.namespace, s.cgroupParent, id, s.pid, s.priv, args, inputMounts)
+	spec.Process.Env = env
+
+	if s.hooks.set() {
+		spec.Hooks = &s.hooks.hooks
+	}
+
+	annotate(spec, s.labels, s.annotations)
+
+	return spec, nil
+}
+
+// mutate runs the finished spec through -spec-mutator, if given.
+func (s *specFlags) mutate(spec *specs.Spec) error {
+	if len(s.mutator) <= 0 {
+		return nil
+	}
+	return runMutator(s.mutator, mutateContainer, spec)
+}
+
+// resolveEnv returns the environment of a container: the defaults named by
+// profile, see -default-env, with the variables of env set over them.
+func resolveEnv(profile string, env []string) ([]string, error) {
+	var defaults []string
+
+	switch profile {
+	case "", defaultEnvBuiltin:
+		defaults = builtinEnv
+	case defaultEnvNone:
+	default:
+		b, err := os.ReadFile(profile)
+		if err != nil {
+			return nil, fmt.Errorf("reading default env: %w", err)
+		}
+
+		// blank lines and # comments are skipped, like in env files
+		for _, line := range strings.Split(string(b), "\n") {
+			line = strings.TrimSpace(line)
+			if len(line) <= 0 || strings.HasPrefix(line, "#") {
+				continue
+			}
+			defaults = append(defaults, line)
+		}
+	}
+
+	for _, kv := range append(defaults, env...) {
+		if !strings.Contains(kv, "=") {
+			return nil, fmt.Errorf("env %q isn't KEY=VALUE", kv)
+		}
+	}
+
+	return mergeEnv(defaults, env), nil
+}
+
+// mergeEnv sets the variables of overrides over those of base. A variable
+// keeps its place in base when overridden, new ones follow in order.
+func mergeEnv(base, overrides []string) []string {
+	merged := make([]string, 0, len(base)+len(overrides))
+	index := map[string]int{}
+
+	for _, kv := range append(append([]string{}, base...), overrides...) {
+		key, _, _ := strings.Cut(kv, "=")
+		if i, ok := index[key]; ok {
+			merged[i] = kv
+			continue
+		}
+		index[key] = len(merged)
+		merged = append(merged, kv)
+	}
+
+	return merged
+}
+
+// cgroupsPath returns the cgroup of container id. A parent ending in .slice,
+// optionally followed by :prefix, is a systemd slice and gives the
+// slice:prefix:name form runc's systemd driver takes, the prefix being ns
+// when not given. Any other parent is a cgroupfs path, /ns when not given.
+func cgroupsPath(ns, parent, id string) string {
+	slice, prefix, hasPrefix := strings.Cut(parent, ":")
+	if !strings.HasSuffix(slice, ".slice") {
+		if len(parent) <= 0 {
+			parent = ns
+		}
+		return filepath.Join("/", parent, id)
+	}
+
+	if !hasPrefix {
+		prefix = ns
+		if len(prefix) <= 0 {
+			prefix = defaultCgroupPrefix
+		}
+	}
+	return fmt.Sprintf("%s:%s:%s", slice, prefix, id)
+}
+
+// newSpec builds the default spec of container id running args, with the
+// given mounts added to the default ones.
+func newSpec(ns, cgroupParent, id, pidNsPath string, priv bool, args []string, mounts []specs.Mount) *specs.Spec {
+	caps := defaultUnixCaps()
+
+	if priv {
+		caps = privUnixCaps()
+	}
+
+	spec := populateDefaultUnixSpec(cgroupsPath(ns, cgroupParent, id), pidNsPath, caps)
+
+	spec.Process.Args = args
+	spec.Process.Env = append([]string{}, builtinEnv...)
+
+	// join it with the defaults
+	spec.Mounts = append(spec.Mounts, mounts...)
+
+	return spec
+}
@@ -0,0 +1,667 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	shim "github.com/containerd/containerd/api/runtime/task/v2"
+	"github.com/containerd/ttrpc"
+	"github.com/google/subcommands"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	gproto "google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const (
+	statsMethodName = "Stats"
+
+	// -output values of stats only, a row per sample
+	outputCSV = "csv"
+	outputTSV = "tsv"
+)
+
+// statsRecord is a sample of the metrics of a container. The metrics are
+// decoded into Metrics when their type is compiled into this client or in
+// a -descriptor-set, kept as is in Value otherwise.
+type statsRecord struct {
+	Timestamp   time.Time       `json:"timestamp"`
+	ContainerID string          `json:"container_id,omitempty"`
+	TypeURL     string          `json:"type_url,omitempty"`
+	Metrics     json.RawMessage `json:"metrics,omitempty"`
+	Value       []byte          `json:"value,omitempty"`
+
+	// with -rates, per second since the previous sample
+	Rates      map[string]float64 `json:"rates,omitempty"`
+	CPUPercent *float64           `json:"cpu_percent,omitempty"`
+
+	// with -all, the number of containers summed in a total, which has no
+	// container ID
+	Containers int `json:"containers,omitempty"`
+}
+
+// statsTypes resolves the type of the metrics, which this client isn't
+// compiled with: cgroups v1 or v2 metrics, depending on the guest.
+type statsTypes interface {
+	protoregistry.MessageTypeResolver
+	protoregistry.ExtensionTypeResolver
+}
+
+// fields flattens the decoded metrics into their numeric leaves, named by
+// their path joined with dots, like memory.usage.usage. protojson writes 64
+// bit integers as strings, they are parsed back. Encoded metrics have none.
+func (r *statsRecord) fields() map[string]float64 {
+	fields := map[string]float64{}
+	if len(r.Metrics) <= 0 {
+		return fields
+	}
+
+	var metrics interface{}
+	decoder := json.NewDecoder(bytes.NewReader(r.Metrics))
+	decoder.UseNumber()
+	if err := decoder.Decode(&metrics); err != nil {
+		return fields
+	}
+
+	flattenStats(fields, "", metrics)
+	return fields
+}
+
+func flattenStats(fields map[string]float64, name string, v interface{}) {
+	join := func(key string) string {
+		if len(name) <= 0 {
+			return key
+		}
+		return name + "." + key
+	}
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, e := range v {
+			flattenStats(fields, join(key), e)
+		}
+	case []interface{}:
+		for i, e := range v {
+			flattenStats(fields, join(strconv.Itoa(i)), e)
+		}
+	case json.Number:
+		if f, err := v.Float64(); err == nil {
+			fields[name] = f
+		}
+	case string:
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			fields[name] = f
+		}
+	}
+}
+
+// statsCounters match the fields of cgroups v1 and v2 metrics that only
+// grow, until the container restarts, -rates prints them per second.
+var statsCounters = []string{
+	"cpu.usage.total", "cpu.usage.kernel", "cpu.usage.user", "cpu.usage.per_cpu.*",
+	"cpu.throttling.*",
+	"memory.*.failcnt",
+	"blkio.io_service_bytes_recursive.*.value", "blkio.io_serviced_recursive.*.value",
+	"network.*.rx_bytes", "network.*.tx_bytes", "network.*.rx_packets", "network.*.tx_packets",
+
+	"cpu.usage_usec", "cpu.user_usec", "cpu.system_usec",
+	"cpu.nr_periods", "cpu.nr_throttled", "cpu.throttled_usec",
+	"io.usage.*.rbytes", "io.usage.*.wbytes", "io.usage.*.rios", "io.usage.*.wios",
+}
+
+// statsCPUTime are the counters of the CPU time of the container, with
+// their unit in cgroups v1 and v2.
+var statsCPUTime = map[string]time.Duration{
+	"cpu.usage.total": time.Nanosecond,
+	"cpu.usage_usec":  time.Microsecond,
+}
+
+func isStatsCounter(field string) bool {
+	for _, pattern := range statsCounters {
+		if ok, _ := path.Match(pattern, field); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// addRates sets the rates per second of the counters of the record since
+// prev, and the CPU time used as a percentage of one vCPU. A counter lower
+// than in prev was reset, it has no rate for this interval.
+func (r *statsRecord) addRates(prev *statsRecord) {
+	interval := r.Timestamp.Sub(prev.Timestamp).Seconds()
+	if prev.TypeURL != r.TypeURL || interval <= 0 {
+		return
+	}
+
+	r.Rates = map[string]float64{}
+	before := prev.fields()
+
+	for field, v := range r.fields() {
+		if !isStatsCounter(field) {
+			continue
+		}
+		was, ok := before[field]
+		if !ok || v < was {
+			continue
+		}
+
+		rate := (v - was) / interval
+		r.Rates[field] = rate
+
+		if unit, ok := statsCPUTime[field]; ok {
+			percent := rate * float64(unit) / float64(time.Second) * 100
+			r.CPUPercent = &percent
+		}
+	}
+}
+
+type StatsCmd struct {
+	baseCmd
+
+	containerId    string
+	watch          time.Duration
+	descriptorSets stringList
+	exportTextfile string
+	columns        string
+	rates          bool
+	all            bool
+	byContainer    bool
+	filters        labelFilters
+}
+
+func (*StatsCmd) Name() string     { return "stats" }
+func (*StatsCmd) Synopsis() string { return "Print the metrics of a container" }
+func (*StatsCmd) Usage() string {
+	return `stats -container_id id | -all [-by-container] [-filter label=key[=value]]...
+      [-watch interval] [-descriptor-set file]...
+      [-export-textfile path] [-output csv|tsv [-columns name,...]] [-rates]:
+	Print the metrics of the container, with -watch a sample every interval,
+	one line each, until interrupted. Every sample is written out as soon as
+	it is taken.
+
+	-all samples every container recorded in the -store file for the agent
+	that job didn't delete, listed again every interval, and prints the total of their metrics:
+	each numeric field summed over the containers. -by-container prints
+	each container before the total, -filter only sums those with a label.
+	Recorded containers the agent doesn't know are skipped.
+
+	The metrics are cgroups metrics this client isn't compiled with. Pass the
+	descriptor set of github.com/containerd/cgroups stats.proto, as written
+	by protoc --descriptor_set_out --include_imports, to have them decoded,
+	they are printed encoded otherwise.
+
+	With -export-textfile, every sample also replaces path with its metrics
+	as OpenMetrics gauges, for the node_exporter textfile collector. Each
+	numeric field of the decoded metrics is a gauge named after its path,
+	memory.usage.usage as fc_container_memory_usage_usage, labelled with the
+	target and the container ID. Encoded metrics only export the time of
+	the sample.
+
+	-output csv or tsv prints a row per sample under a header, for
+	spreadsheets. -columns picks the columns among timestamp, container_id,
+	type_url and the numeric fields of the decoded metrics, named by their
+	path like memory.usage.usage. They default to timestamp, container_id
+	and the fields of the first sample, a field a sample lacks is empty.
+
+	-rates, with -watch, prints the counters of the metrics, like the CPU
+	time or the bytes read and written, as rates per second over the last
+	interval instead, and the CPU time as a percentage of one vCPU. The
+	first sample is only taken to compare the next with. A counter lower
+	than in the previous sample, reset by the container restarting, has no
+	rate for that interval. In csv or tsv, the rate of a field is the column
+	of its path followed by /s, the CPU time percentage is cpu_percent.
+  `
+}
+
+func (p *StatsCmd) SetFlags(f *flag.FlagSet) {
+	p.baseCmd.SetFlags(f)
+	f.StringVar(&p.containerId, "container_id", "", "Container ID")
+	f.DurationVar(&p.watch, "watch", 0, "Sample the metrics every interval until interrupted, 0 for one sample")
+	f.Var(&p.descriptorSets, "descriptor-set", "Compiled FileDescriptorSet with the type of the metrics (repeatable)")
+	f.StringVar(&p.exportTextfile, "export-textfile", "", "Write every sample as OpenMetrics gauges to this file, like a node_exporter textfile")
+	f.StringVar(&p.columns, "columns", "", "Comma separated columns of -output csv or tsv")
+	f.BoolVar(&p.rates, "rates", false, "With -watch, print the counters as rates per second")
+	f.BoolVar(&p.all, "all", false, "Sum the metrics of every container in the -store for the agent")
+	f.BoolVar(&p.byContainer, "by-container", false, "With -all, print each container before the total")
+	f.Var(&p.filters, "filter", "With -all, only sum containers with the label key, set to value if given, as label=key[=value] (repeatable)")
+}
+
+func (p *StatsCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	ctx, cancel := p.context(ctx)
+	defer cancel()
+
+	if p.all {
+		if len(p.containerId) > 0 {
+			logf(ctx, "-container_id and -all are exclusive\n")
+			return subcommands.ExitUsageError
+		}
+		if storeFrom(ctx) == nil {
+			logf(ctx, "No -store to list containers from")
+			return subcommands.ExitFailure
+		}
+	} else {
+		if p.byContainer || len(p.filters) > 0 {
+			logf(ctx, "-by-container and -filter need -all\n")
+			return subcommands.ExitUsageError
+		}
+		if len(p.containerId) <= 0 {
+			logf(ctx, "No container ID defined")
+			return subcommands.ExitFailure
+		}
+
+		containerId, err := resolveContainerID(ctx, &p.baseCmd, p.containerId)
+		if err != nil {
+			logf(ctx, "%s\n", err)
+			return subcommands.ExitFailure
+		}
+		p.containerId = containerId
+	}
+
+	if p.watch < 0 {
+		logf(ctx, "-watch can't be negative\n")
+		return subcommands.ExitUsageError
+	}
+
+	if p.rates && p.watch <= 0 {
+		logf(ctx, "-rates needs -watch\n")
+		return subcommands.ExitUsageError
+	}
+
+	var table *statsTable
+	switch p.output {
+	case outputText, outputJSON:
+		if len(p.columns) > 0 {
+			logf(ctx, "-columns needs -output csv or tsv\n")
+			return subcommands.ExitUsageError
+		}
+	case outputCSV:
+		table = newStatsTable(os.Stdout, ',', p.columns)
+	case outputTSV:
+		table = newStatsTable(os.Stdout, '\t', p.columns)
+	default:
+		logf(ctx, "Unknown output %s, stats prints text, json, csv or tsv\n", p.output)
+		return subcommands.ExitUsageError
+	}
+
+	var types statsTypes = protoregistry.GlobalTypes
+	if len(p.descriptorSets) > 0 {
+		files, err := loadDescriptorSets(p.descriptorSets)
+		if err != nil {
+			logf(ctx, "Failure loading descriptor sets: %s\n", err)
+			return subcommands.ExitFailure
+		}
+		types = dynamicpb.NewTypes(files)
+	}
+
+	client, cleanup, err := p.newClient(ctx)
+	if err != nil {
+		logf(ctx, "Failure creating client: %s\n", err)
+		return subcommands.ExitFailure
+	}
+	defer cleanup()
+
+	out := newRecordWriter(os.Stdout, 0)
+	warnedEncoded := false
+	warnedGone := map[string]bool{}
+	prevs := map[string]*statsRecord{}
+
+	for round := 0; ; round++ {
+		var ids []string
+		if p.all {
+			containers, err := storeFrom(ctx).list(p.target(), p.filters)
+			if err != nil {
+				logf(ctx, "Failure reading %s: %s\n", storeFrom(ctx).path, err)
+				return subcommands.ExitFailure
+			}
+			for _, c := range containers {
+				// deleted by job, only its exit is left
+				if c.ExitedAt == nil {
+					ids = append(ids, c.ID)
+				}
+			}
+		} else {
+			ids = []string{p.containerId}
+		}
+
+		at := time.Now().UTC()
+		var records []*statsRecord
+
+		for _, id := range ids {
+			record, err := sampleStats(ctx, client, id, types)
+			if err != nil {
+				if p.watch > 0 && errors.Is(err, context.Canceled) && ctx.Err() != nil {
+					return subcommands.ExitSuccess
+				}
+				// deleted by other means than this client, still recorded
+				if p.all && status.Code(err) == codes.NotFound {
+					if !warnedGone[id] {
+						logf(ctx, "Container %s is recorded but the agent doesn't know it, skipping it\n", id)
+						warnedGone[id] = true
+					}
+					continue
+				}
+				logf(ctx, "Failure getting stats of %s: %s\n", id, err)
+				return subcommands.ExitFailure
+			}
+
+			if p.rates {
+				if prev, ok := prevs[id]; ok {
+					record.addRates(prev)
+				}
+				prevs[id] = record
+			}
+			records = append(records, record)
+		}
+
+		var printed []*statsRecord
+		for _, record := range records {
+			if !p.all || p.byContainer {
+				printed = append(printed, record)
+			}
+		}
+		if p.all {
+			printed = append(printed, sumStats(at, records))
+		}
+
+		// the first samples are only compared with the next ones
+		if !p.rates || round > 0 {
+			for _, record := range printed {
+				if err := p.printStats(out, table, record); err != nil {
+					logf(ctx, "Failure reporting result: %s\n", err)
+					return subcommands.ExitFailure
+				}
+			}
+		}
+
+		if len(p.exportTextfile) > 0 {
+			for _, record := range records {
+				if len(record.Metrics) <= 0 && len(record.Value) > 0 && !warnedEncoded {
+					logf(ctx, "Metrics of type %s aren't decoded, only the time of the samples is exported, pass -descriptor-set\n", record.TypeURL)
+					warnedEncoded = true
+				}
+			}
+			if err := writeTextfile(p.exportTextfile, p.target(), records); err != nil {
+				logf(ctx, "Failure exporting stats: %s\n", err)
+				return subcommands.ExitFailure
+			}
+		}
+
+		if p.watch <= 0 {
+			return subcommands.ExitSuccess
+		}
+
+		select {
+		case <-time.After(p.watch):
+		case <-ctx.Done():
+			return subcommands.ExitSuccess
+		}
+	}
+}
+
+// printStats prints the record as a row of the table, if any, or a line.
+func (p *StatsCmd) printStats(out *recordWriter, table *statsTable, record *statsRecord) error {
+	if table != nil {
+		return table.row(record)
+	}
+
+	metrics := string(record.Metrics)
+	if len(record.Metrics) <= 0 {
+		metrics = record.TypeURL
+	}
+	if len(metrics) <= 0 {
+		metrics = "no metrics"
+	}
+	if p.rates {
+		metrics = formatRates(record)
+	}
+
+	text := fmt.Sprintf("%s %s", record.Timestamp.Format(time.RFC3339Nano), metrics)
+	if p.all {
+		name := record.ContainerID
+		if len(name) <= 0 {
+			name = fmt.Sprintf("total(%d)", record.Containers)
+		}
+		text = fmt.Sprintf("%s %s %s", record.Timestamp.Format(time.RFC3339Nano), name, metrics)
+	}
+
+	return p.printRecord(out, record, text)
+}
+
+// sumStats is the total of the records sampled at, with their numeric
+// fields and their rates summed. The summed fields are its metrics, by the
+// path of each field.
+func sumStats(at time.Time, records []*statsRecord) *statsRecord {
+	total := &statsRecord{Timestamp: at, Containers: len(records)}
+	fields := map[string]float64{}
+
+	for _, record := range records {
+		for field, v := range record.fields() {
+			fields[field] += v
+		}
+
+		for field, v := range record.Rates {
+			if total.Rates == nil {
+				total.Rates = map[string]float64{}
+			}
+			total.Rates[field] += v
+		}
+		if record.CPUPercent != nil {
+			if total.CPUPercent == nil {
+				total.CPUPercent = new(float64)
+			}
+			*total.CPUPercent += *record.CPUPercent
+		}
+	}
+
+	if len(fields) > 0 {
+		// a map of floats always marshals
+		total.Metrics, _ = json.Marshal(fields)
+	}
+	return total
+}
+
+// sampleStats gets the metrics of container id.
+func sampleStats(ctx context.Context, client *ttrpc.Client, id string, types statsTypes) (*statsRecord, error) {
+	res := &shim.StatsResponse{}
+
+	if err := client.Call(ctx, serviceName, statsMethodName, &shim.StatsRequest{ID: id}, res); err != nil {
+		return nil, err
+	}
+
+	record := &statsRecord{
+		Timestamp:   time.Now().UTC(),
+		ContainerID: id,
+	}
+
+	if res.Stats == nil {
+		return record, nil
+	}
+
+	record.TypeURL = res.Stats.TypeUrl
+
+	mt, err := types.FindMessageByURL(res.Stats.TypeUrl)
+	if err == nil {
+		metrics := mt.New().Interface()
+		if err := (gproto.UnmarshalOptions{Resolver: types}).Unmarshal(res.Stats.Value, metrics); err == nil {
+			if out, err := (protojson.MarshalOptions{UseProtoNames: true, Resolver: types}).Marshal(metrics); err == nil {
+				record.Metrics = out
+				return record, nil
+			}
+		}
+	}
+
+	record.Value = res.Stats.Value
+	return record, nil
+}
+
+// formatRates is the text of the rates of the record, as name=value pairs.
+func formatRates(record *statsRecord) string {
+	var pairs []string
+	if record.CPUPercent != nil {
+		pairs = append(pairs, fmt.Sprintf("cpu_percent=%.2f", *record.CPUPercent))
+	}
+
+	fields := make([]string, 0, len(record.Rates))
+	for field := range record.Rates {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	for _, field := range fields {
+		pairs = append(pairs, fmt.Sprintf("%s/s=%s", field, strconv.FormatFloat(record.Rates[field], 'f', -1, 64)))
+	}
+
+	if len(pairs) <= 0 {
+		return "no rates"
+	}
+	return strings.Join(pairs, " ")
+}
+
+// statsTable writes samples as csv or tsv rows, each flushed as it is
+// written, under a header of the columns.
+type statsTable struct {
+	w       *csv.Writer
+	columns []string
+	header  bool
+}
+
+// newStatsTable returns a table of the comma separated columns, or of the
+// fields of the first sample when there are none.
+func newStatsTable(w io.Writer, comma rune, columns string) *statsTable {
+	t := &statsTable{w: csv.NewWriter(w)}
+	t.w.Comma = comma
+	if len(columns) > 0 {
+		for _, column := range strings.Split(columns, ",") {
+			t.columns = append(t.columns, strings.TrimSpace(column))
+		}
+	}
+	return t
+}
+
+func (t *statsTable) row(record *statsRecord) error {
+	fields := record.fields()
+
+	if !t.header {
+		if t.columns == nil {
+			t.columns = []string{"timestamp", "container_id"}
+			if record.CPUPercent != nil {
+				t.columns = append(t.columns, "cpu_percent")
+			}
+			n := len(t.columns)
+			if record.Rates != nil {
+				for field := range record.Rates {
+					t.columns = append(t.columns, field+"/s")
+				}
+			} else {
+				for field := range fields {
+					t.columns = append(t.columns, field)
+				}
+			}
+			sort.Strings(t.columns[n:])
+		}
+		if err := t.w.Write(t.columns); err != nil {
+			return err
+		}
+		t.header = true
+	}
+
+	row := make([]string, len(t.columns))
+	for i, column := range t.columns {
+		switch column {
+		case "timestamp":
+			row[i] = record.Timestamp.Format(time.RFC3339Nano)
+		case "container_id":
+			row[i] = record.ContainerID
+		case "type_url":
+			row[i] = record.TypeURL
+		case "cpu_percent":
+			if record.CPUPercent != nil {
+				row[i] = strconv.FormatFloat(*record.CPUPercent, 'f', -1, 64)
+			}
+		default:
+			v, ok := fields[column]
+			if field, rate := strings.CutSuffix(column, "/s"); rate {
+				v, ok = record.Rates[field]
+			}
+			if ok {
+				row[i] = strconv.FormatFloat(v, 'f', -1, 64)
+			}
+		}
+	}
+
+	if err := t.w.Write(row); err != nil {
+		return err
+	}
+	t.w.Flush()
+	return t.w.Error()
+}
+
+var (
+	metricNameInvalid = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+	labelEscaper      = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+)
+
+// writeTextfile writes the metrics of the records as OpenMetrics gauges to
+// path. The file is renamed over path so the textfile collector never reads
+// half of it.
+func writeTextfile(path, target string, records []*statsRecord) error {
+	var names []string
+	samples := map[string][]string{}
+
+	for _, record := range records {
+		labels := fmt.Sprintf(`{target="%s",container_id="%s"}`, labelEscaper.Replace(target), labelEscaper.Replace(record.ContainerID))
+
+		add := func(name string, v float64) {
+			if _, ok := samples[name]; !ok {
+				names = append(names, name)
+			}
+			samples[name] = append(samples[name], name+labels+" "+strconv.FormatFloat(v, 'g', -1, 64))
+		}
+
+		add("fc_container_stats_timestamp_seconds", float64(record.Timestamp.UnixNano())/float64(time.Second))
+
+		fields := record.fields()
+		keys := make([]string, 0, len(fields))
+		for key := range fields {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			add("fc_container_"+metricNameInvalid.ReplaceAllString(key, "_"), fields[key])
+		}
+	}
+
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&buf, "# TYPE %s gauge\n", name)
+		for _, sample := range samples[name] {
+			buf.WriteString(sample + "\n")
+		}
+	}
+	buf.WriteString("# EOF\n")
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
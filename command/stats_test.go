@@ -0,0 +1,208 @@
+package command
+
+import (
+	"bytes"
+	"maps"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStatsFields(t *testing.T) {
+	record := &statsRecord{
+		Metrics: []byte(`{"cpu":{"usage":{"total":"1500","per_cpu":["1000","500"]}},"memory":{"usage":{"usage":"4096","failcnt":0}},"blkio":{"io_service_bytes_recursive":[{"major":8,"op":"Read","value":"512"}]},"oom":false}`),
+	}
+
+	want := map[string]float64{
+		"cpu.usage.total":                          1500,
+		"cpu.usage.per_cpu.0":                      1000,
+		"cpu.usage.per_cpu.1":                      500,
+		"memory.usage.usage":                       4096,
+		"memory.usage.failcnt":                     0,
+		"blkio.io_service_bytes_recursive.0.major": 8,
+		"blkio.io_service_bytes_recursive.0.value": 512,
+	}
+
+	if got := record.fields(); !maps.Equal(got, want) {
+		t.Errorf("fields() = %v, want %v", got, want)
+	}
+
+	encoded := &statsRecord{TypeURL: "io.containerd.cgroups.v1.Metrics", Value: []byte{1, 2}}
+	if got := encoded.fields(); len(got) != 0 {
+		t.Errorf("fields() of encoded metrics = %v, want none", got)
+	}
+}
+
+func TestWriteTextfile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fc.prom")
+	at := time.Unix(1700000000, 500000000)
+
+	records := []*statsRecord{
+		{Timestamp: at, ContainerID: "a", Metrics: []byte(`{"memory":{"usage":{"usage":"4096"}},"pids":{"current":3}}`)},
+		{Timestamp: at, ContainerID: "b", Metrics: []byte(`{"memory":{"usage":{"usage":"1024"}}}`)},
+		{Timestamp: at, ContainerID: `c"d`, Value: []byte{1}},
+	}
+
+	if err := writeTextfile(path, "vsock:3", records); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# TYPE fc_container_memory_usage_usage gauge
+fc_container_memory_usage_usage{target="vsock:3",container_id="a"} 4096
+fc_container_memory_usage_usage{target="vsock:3",container_id="b"} 1024
+# TYPE fc_container_pids_current gauge
+fc_container_pids_current{target="vsock:3",container_id="a"} 3
+# TYPE fc_container_stats_timestamp_seconds gauge
+fc_container_stats_timestamp_seconds{target="vsock:3",container_id="a"} 1.7000000005e+09
+fc_container_stats_timestamp_seconds{target="vsock:3",container_id="b"} 1.7000000005e+09
+fc_container_stats_timestamp_seconds{target="vsock:3",container_id="c\"d"} 1.7000000005e+09
+# EOF
+`
+	if string(got) != want {
+		t.Errorf("textfile =\n%s\nwant\n%s", got, want)
+	}
+
+	if _, err := os.Stat(path + ".tmp"); err == nil {
+		t.Errorf("%s.tmp left behind", path)
+	}
+}
+
+func TestStatsTable(t *testing.T) {
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	records := []*statsRecord{
+		{Timestamp: at, ContainerID: "a", Metrics: []byte(`{"pids":{"current":3},"memory":{"usage":{"usage":"4096"}}}`)},
+		{Timestamp: at.Add(time.Second), ContainerID: "a", Metrics: []byte(`{"pids":{"current":4}}`)},
+	}
+
+	tests := []struct {
+		name    string
+		comma   rune
+		columns string
+		want    string
+	}{
+		{
+			name:  "default columns",
+			comma: ',',
+			want: "timestamp,container_id,memory.usage.usage,pids.current\n" +
+				"2024-01-02T03:04:05Z,a,4096,3\n" +
+				"2024-01-02T03:04:06Z,a,,4\n",
+		},
+		{
+			name:    "picked columns",
+			comma:   '\t',
+			columns: "pids.current, timestamp,unknown",
+			want: "pids.current\ttimestamp\tunknown\n" +
+				"3\t2024-01-02T03:04:05Z\t\n" +
+				"4\t2024-01-02T03:04:06Z\t\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			table := newStatsTable(&buf, tt.comma, tt.columns)
+			for _, record := range records {
+				if err := table.row(record); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := buf.String(); got != tt.want {
+				t.Errorf("table =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStatsRates(t *testing.T) {
+	at := time.Unix(1700000000, 0)
+	prev := &statsRecord{
+		Timestamp: at,
+		TypeURL:   "io.containerd.cgroups.v2.Metrics",
+		Metrics:   []byte(`{"cpu":{"usage_usec":"1000000","nr_throttled":"10"},"io":{"usage":[{"rbytes":"4096","wbytes":"0"}]},"memory":{"usage":"8192"}}`),
+	}
+	record := &statsRecord{
+		Timestamp: at.Add(2 * time.Second),
+		TypeURL:   "io.containerd.cgroups.v2.Metrics",
+		Metrics:   []byte(`{"cpu":{"usage_usec":"2000000","nr_throttled":"2"},"io":{"usage":[{"rbytes":"8192","wbytes":"1024"}]},"memory":{"usage":"16384"}}`),
+	}
+
+	record.addRates(prev)
+
+	// nr_throttled went down, it was reset, memory.usage isn't a counter
+	want := map[string]float64{
+		"cpu.usage_usec":    500000,
+		"io.usage.0.rbytes": 2048,
+		"io.usage.0.wbytes": 512,
+	}
+	if !maps.Equal(record.Rates, want) {
+		t.Errorf("Rates = %v, want %v", record.Rates, want)
+	}
+	if record.CPUPercent == nil || *record.CPUPercent != 50 {
+		t.Errorf("CPUPercent = %v, want 50", record.CPUPercent)
+	}
+
+	if got, want := formatRates(record), "cpu_percent=50.00 cpu.usage_usec/s=500000 io.usage.0.rbytes/s=2048 io.usage.0.wbytes/s=512"; got != want {
+		t.Errorf("formatRates() = %q, want %q", got, want)
+	}
+
+	var buf bytes.Buffer
+	if err := newStatsTable(&buf, ',', "cpu_percent,io.usage.0.rbytes/s,io.usage.0.rbytes").row(record); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "cpu_percent,io.usage.0.rbytes/s,io.usage.0.rbytes\n50,2048,8192\n"; got != want {
+		t.Errorf("table = %q, want %q", got, want)
+	}
+
+	other := &statsRecord{Timestamp: at.Add(4 * time.Second), TypeURL: "io.containerd.cgroups.v1.Metrics", Metrics: []byte(`{"cpu":{"usage":{"total":"1"}}}`)}
+	other.addRates(record)
+	if other.Rates != nil || other.CPUPercent != nil {
+		t.Errorf("rates across metric types = %v, %v, want none", other.Rates, other.CPUPercent)
+	}
+}
+
+func TestSumStats(t *testing.T) {
+	at := time.Unix(1700000000, 0)
+	percent := func(v float64) *float64 { return &v }
+
+	records := []*statsRecord{
+		{
+			ContainerID: "a",
+			Metrics:     []byte(`{"memory":{"usage":"4096"},"pids":{"current":2}}`),
+			Rates:       map[string]float64{"cpu.usage_usec": 250000},
+			CPUPercent:  percent(25),
+		},
+		{
+			ContainerID: "b",
+			Metrics:     []byte(`{"memory":{"usage":"1024"}}`),
+			Rates:       map[string]float64{"cpu.usage_usec": 500000, "io.usage.0.rbytes": 10},
+			CPUPercent:  percent(50),
+		},
+		// encoded metrics add nothing but the count
+		{ContainerID: "c", Value: []byte{1}},
+	}
+
+	total := sumStats(at, records)
+
+	if total.ContainerID != "" || total.Containers != 3 || !total.Timestamp.Equal(at) {
+		t.Errorf("total = %q, %d containers at %s", total.ContainerID, total.Containers, total.Timestamp)
+	}
+	if got, want := total.fields(), map[string]float64{"memory.usage": 5120, "pids.current": 2}; !maps.Equal(got, want) {
+		t.Errorf("fields() = %v, want %v", got, want)
+	}
+	if want := map[string]float64{"cpu.usage_usec": 750000, "io.usage.0.rbytes": 10}; !maps.Equal(total.Rates, want) {
+		t.Errorf("Rates = %v, want %v", total.Rates, want)
+	}
+	if total.CPUPercent == nil || *total.CPUPercent != 75 {
+		t.Errorf("CPUPercent = %v, want 75", total.CPUPercent)
+	}
+
+	if empty := sumStats(at, nil); empty.Containers != 0 || empty.Metrics != nil || empty.Rates != nil {
+		t.Errorf("sum of no records = %+v", empty)
+	}
+}
@@ -0,0 +1,324 @@
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	shim "github.com/containerd/containerd/api/runtime/task/v2"
+)
+
+const storeFileName = "containers.json"
+
+// storedContainer is the local record of a container created through this
+// client.
+type storedContainer struct {
+	ID        string            `json:"id"`
+	Target    string            `json:"target"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+
+	// Manifest is the absolute path of the manifest declaring the container,
+	// when apply or up created it
+	Manifest string `json:"manifest,omitempty"`
+
+	// how the container ended, once job deleted it, the agent forgets it
+	ExitStatus *uint32    `json:"exit_status,omitempty"`
+	ExitedAt   *time.Time `json:"exited_at,omitempty"`
+}
+
+// containerStore records the containers created through this client, with
+// their labels, in a JSON file. The agent has no call listing containers,
+// list answers from here. Containers deleted behind its back stay recorded
+// until delete is run on them, like the containers job deleted, which keep
+// how they ended.
+type containerStore struct {
+	path string
+}
+
+// defaultStorePath is where -store points by default, empty when there is
+// no cache directory.
+func defaultStorePath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "firecracker-containerd-agent-client", storeFileName)
+}
+
+// storeFrom returns the store of -store, nil when it was set empty.
+func storeFrom(ctx context.Context) *containerStore {
+	path := globalsFrom(ctx).Store
+	if len(path) <= 0 {
+		return nil
+	}
+	return &containerStore{path: path}
+}
+
+// target names the agent b points at in the store.
+func (b *baseCmd) target() string {
+	if len(b.unixSocket) > 0 {
+		if path, err := filepath.Abs(b.unixSocket); err == nil {
+			return "unix:" + path
+		}
+		return "unix:" + b.unixSocket
+	}
+	return fmt.Sprintf("vsock:%d", b.cid)
+}
+
+// update runs fn on the records with the store locked and writes back what
+// fn returns.
+func (s *containerStore) update(fn func([]*storedContainer) []*storedContainer) error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+
+	lock, err := os.OpenFile(s.path+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+
+	// released by closing the lock file
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+
+	records, err := s.read()
+	if err != nil {
+		return err
+	}
+
+	out, err := json.MarshalIndent(fn(records), "", "\t")
+	if err != nil {
+		return err
+	}
+
+	// renamed over the store so readers never see half of it
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, out, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func (s *containerStore) read() ([]*storedContainer, error) {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var records []*storedContainer
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", s.path, err)
+	}
+	return records, nil
+}
+
+// add records container id of target, declared in manifest if not empty,
+// replacing an earlier record of the same container.
+func (s *containerStore) add(target, id string, labels map[string]string, manifest string) error {
+	return s.update(func(records []*storedContainer) []*storedContainer {
+		records = slices.DeleteFunc(records, func(r *storedContainer) bool {
+			return r.Target == target && r.ID == id
+		})
+		return append(records, &storedContainer{
+			ID:        id,
+			Target:    target,
+			Labels:    labels,
+			CreatedAt: time.Now().UTC(),
+			Manifest:  manifest,
+		})
+	})
+}
+
+// remove forgets the containers ids of target.
+func (s *containerStore) remove(target string, ids ...string) error {
+	return s.update(func(records []*storedContainer) []*storedContainer {
+		return slices.DeleteFunc(records, func(r *storedContainer) bool {
+			return r.Target == target && slices.Contains(ids, r.ID)
+		})
+	})
+}
+
+// exited records how container id of target ended, once it was deleted.
+func (s *containerStore) exited(target, id string, exitStatus uint32, exitedAt time.Time) error {
+	return s.update(func(records []*storedContainer) []*storedContainer {
+		for _, r := range records {
+			if r.Target == target && r.ID == id {
+				r.ExitStatus = &exitStatus
+				r.ExitedAt = &exitedAt
+			}
+		}
+		return records
+	})
+}
+
+// list returns the containers of target matching all filters, in the order
+// they were created.
+func (s *containerStore) list(target string, filters labelFilters) ([]*storedContainer, error) {
+	records, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+
+	return slices.DeleteFunc(records, func(r *storedContainer) bool {
+		return r.Target != target || !filters.match(r.Labels)
+	}), nil
+}
+
+// recordContainer adds the container to the store, if any. The container
+// exists by then, failing to record it is only warned about.
+func recordContainer(ctx context.Context, b *baseCmd, id string, labels map[string]string) {
+	store := storeFrom(ctx)
+	if store == nil {
+		return
+	}
+
+	if err := store.add(b.target(), id, labels, ""); err != nil {
+		logf(ctx, "Failure recording container %s in %s: %s\n", id, store.path, err)
+	}
+}
+
+// recordDeclaredContainer adds the container declared in the manifest at
+// path to the store, if any, for apply -reconcile to delete it once the
+// manifest no longer declares it.
+func recordDeclaredContainer(ctx context.Context, b *baseCmd, c *manifestContainer, path string) {
+	store := storeFrom(ctx)
+	if store == nil {
+		return
+	}
+
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+
+	if err := store.add(b.target(), c.ID, c.Labels, path); err != nil {
+		logf(ctx, "Failure recording container %s in %s: %s\n", c.ID, store.path, err)
+	}
+}
+
+// forgetContainer removes the deleted container from the store, if any.
+func forgetContainer(ctx context.Context, b *baseCmd, id string) {
+	store := storeFrom(ctx)
+	if store == nil {
+		return
+	}
+
+	if err := store.remove(b.target(), id); err != nil {
+		logf(ctx, "Failure forgetting container %s in %s: %s\n", id, store.path, err)
+	}
+}
+
+// recordExit records in the store, if any, how the container deleted with
+// res ended. Without res it didn't exist anymore and is forgotten.
+func recordExit(ctx context.Context, b *baseCmd, id string, res *shim.DeleteResponse) {
+	if res == nil {
+		forgetContainer(ctx, b, id)
+		return
+	}
+
+	store := storeFrom(ctx)
+	if store == nil {
+		return
+	}
+
+	if err := store.exited(b.target(), id, res.ExitStatus, res.ExitedAt.AsTime().UTC()); err != nil {
+		logf(ctx, "Failure recording the exit of container %s in %s: %s\n", id, store.path, err)
+	}
+}
+
+// resolveContainerID returns the container recorded for the agent that id
+// is a unique prefix of, like docker and ctr do. Containers job deleted
+// aren't candidates. An id that is no prefix is returned as is, it may name
+// a container created by other means.
+func resolveContainerID(ctx context.Context, b *baseCmd, id string) (string, error) {
+	store := storeFrom(ctx)
+	if store == nil || len(id) <= 0 {
+		return id, nil
+	}
+
+	containers, err := store.list(b.target(), nil)
+	if err != nil {
+		logf(ctx, "Failure reading %s, taking %s as a full container ID: %s\n", store.path, id, err)
+		return id, nil
+	}
+
+	var candidates []string
+	for _, c := range containers {
+		if c.ID == id {
+			return id, nil
+		}
+		if c.ExitedAt == nil && strings.HasPrefix(c.ID, id) {
+			candidates = append(candidates, c.ID)
+		}
+	}
+
+	switch len(candidates) {
+	case 0:
+		return id, nil
+	case 1:
+		return candidates[0], nil
+	}
+	return "", fmt.Errorf("container ID %s is ambiguous, it is a prefix of %s", id, strings.Join(candidates, ", "))
+}
+
+// labelFilter selects containers having label key, set to value unless
+// any is set.
+type labelFilter struct {
+	key   string
+	value string
+	any   bool
+}
+
+// labelFilters collects repeated -filter label=key[=value] flags, a
+// container has to match all of them.
+type labelFilters []labelFilter
+
+func (lf *labelFilters) String() string {
+	var filters []string
+	for _, f := range *lf {
+		if f.any {
+			filters = append(filters, "label="+f.key)
+		} else {
+			filters = append(filters, "label="+f.key+"="+f.value)
+		}
+	}
+	return strings.Join(filters, ",")
+}
+
+func (lf *labelFilters) Set(value string) error {
+	kind, expr, _ := strings.Cut(value, "=")
+	if kind != "label" {
+		return fmt.Errorf("expected label=key[=value], got %q", value)
+	}
+
+	key, val, ok := strings.Cut(expr, "=")
+	if len(key) <= 0 {
+		return fmt.Errorf("expected label=key[=value], got %q", value)
+	}
+
+	*lf = append(*lf, labelFilter{key: key, value: val, any: !ok})
+	return nil
+}
+
+func (lf labelFilters) match(labels map[string]string) bool {
+	for _, f := range lf {
+		v, ok := labels[f.key]
+		if !ok || (!f.any && v != f.value) {
+			return false
+		}
+	}
+	return true
+}
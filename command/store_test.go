@@ -0,0 +1,95 @@
+package command
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestContainerStoreExited(t *testing.T) {
+	store := &containerStore{path: filepath.Join(t.TempDir(), storeFileName)}
+
+	for _, id := range []string{"a", "b"} {
+		if err := store.add("vsock:3", id, nil, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the same ID on another agent is another container
+	if err := store.add("vsock:4", "a", nil, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := store.exited("vsock:3", "a", 137, at); err != nil {
+		t.Fatal(err)
+	}
+
+	containers, err := store.read()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range containers {
+		exited := c.Target == "vsock:3" && c.ID == "a"
+		if (c.ExitStatus != nil) != exited || (c.ExitedAt != nil) != exited {
+			t.Errorf("%s of %s: exit %v at %v, want exited %v", c.ID, c.Target, c.ExitStatus, c.ExitedAt, exited)
+			continue
+		}
+		if exited && (*c.ExitStatus != 137 || !c.ExitedAt.Equal(at)) {
+			t.Errorf("%s of %s exited with %d at %s, want 137 at %s", c.ID, c.Target, *c.ExitStatus, c.ExitedAt, at)
+		}
+	}
+}
+
+func TestResolveContainerID(t *testing.T) {
+	path := filepath.Join(t.TempDir(), storeFileName)
+	store := &containerStore{path: path}
+	b := &baseCmd{unixSocket: "/run/agent.sock"}
+
+	for _, id := range []string{"4f1c2d", "4f1c9a", "7e02aa", "ab", "abc"} {
+		if err := store.add(b.target(), id, nil, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.add("vsock:3", "9d00ff", nil, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.add(b.target(), "c0ffee", nil, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.exited(b.target(), "c0ffee", 0, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := WithGlobals(context.Background(), &Globals{Store: path})
+
+	tests := []struct {
+		id      string
+		want    string
+		wantErr bool
+	}{
+		{id: "7e", want: "7e02aa"},
+		{id: "4f1c2", want: "4f1c2d"},
+		{id: "4f1c", wantErr: true},
+		// an exact match wins over the longer IDs it prefixes
+		{id: "ab", want: "ab"},
+		// not recorded for this agent
+		{id: "9d", want: "9d"},
+		// deleted by job
+		{id: "c0f", want: "c0f"},
+		{id: "c0ffee", want: "c0ffee"},
+		{id: "", want: ""},
+	}
+
+	for _, tt := range tests {
+		got, err := resolveContainerID(ctx, b, tt.id)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("resolveContainerID(%q) = %v, want error %v", tt.id, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("resolveContainerID(%q) = %q, want %q", tt.id, got, tt.want)
+		}
+	}
+}
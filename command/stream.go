@@ -0,0 +1,110 @@
+package command
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/containerd/ttrpc"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// callStream calls the streaming method md. Requests are read from in, one
+// JSON object per line, and responses written to out the same way. A method
+// that only streams responses takes its one request from arg instead.
+func callStream(ctx context.Context, c *ttrpc.Client, md protoreflect.MethodDescriptor, arg string, in io.Reader, out io.Writer) error {
+	desc := &ttrpc.StreamDesc{
+		StreamingClient: md.IsStreamingClient(),
+		StreamingServer: md.IsStreamingServer(),
+	}
+
+	service := string(md.Parent().FullName())
+	method := string(md.Name())
+
+	var first interface{}
+	if !desc.StreamingClient {
+		req := dynamicpb.NewMessage(md.Input())
+		if len(arg) > 0 {
+			if err := protojson.Unmarshal([]byte(arg), req); err != nil {
+				return fmt.Errorf("unmarshalling input: %w", err)
+			}
+		}
+		first = req
+	}
+
+	stream, err := c.NewStream(ctx, desc, service, method, first)
+	if err != nil {
+		return err
+	}
+
+	sendErr := make(chan error, 1)
+	if desc.StreamingClient {
+		go func() {
+			sendErr <- sendLines(stream, md.Input(), in)
+		}()
+	} else {
+		sendErr <- nil
+	}
+
+	w := bufio.NewWriter(out)
+
+	for {
+		res := dynamicpb.NewMessage(md.Output())
+		if err := stream.RecvMsg(res); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return err
+		}
+
+		b, err := protojson.Marshal(res)
+		if err != nil {
+			return err
+		}
+
+		// flushed per response so consumers see each as it comes
+		w.Write(append(b, '\n'))
+		if err := w.Flush(); err != nil {
+			return err
+		}
+
+		if !desc.StreamingServer {
+			break
+		}
+	}
+
+	return <-sendErr
+}
+
+// sendLines sends each JSON line of in as a request, and closes the sending
+// side at the end of in.
+func sendLines(stream ttrpc.ClientStream, input protoreflect.MessageDescriptor, in io.Reader) error {
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) <= 0 {
+			continue
+		}
+
+		req := dynamicpb.NewMessage(input)
+		if err := protojson.Unmarshal(line, req); err != nil {
+			return fmt.Errorf("unmarshalling input: %w", err)
+		}
+
+		if err := stream.SendMsg(req); err != nil {
+			return err
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	return stream.CloseSend()
+}
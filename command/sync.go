@@ -0,0 +1,306 @@
+package command
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/containerd/ttrpc"
+	"github.com/google/subcommands"
+)
+
+// syncDeleteBatch bounds the paths given to one rm, to stay below the
+// argument limit of the guest.
+const syncDeleteBatch = 256
+
+// syncResult lists what sync changed, or would change with -dry-run.
+type syncResult struct {
+	Sent      []string `json:"sent,omitempty"`
+	Deleted   []string `json:"deleted,omitempty"`
+	Unchanged int      `json:"unchanged"`
+	DryRun    bool     `json:"dry_run,omitempty"`
+}
+
+type SyncCmd struct {
+	baseCmd
+
+	containerId string
+	delete      bool
+	dryRun      bool
+}
+
+func (*SyncCmd) Name() string     { return "sync" }
+func (*SyncCmd) Synopsis() string { return "Send changed files of a directory into a container" }
+func (*SyncCmd) Usage() string {
+	return `sync -container_id id [-delete] [-dry-run] <local dir> <container dir>:
+	Compare the sha256 of the files under the local directory with those under
+	the container directory and send only the new and changed ones, as one tar
+	stream. The container needs sh, find, sha256sum and tar, as busybox has.
+  `
+}
+
+func (p *SyncCmd) SetFlags(f *flag.FlagSet) {
+	p.baseCmd.SetFlags(f)
+	f.StringVar(&p.containerId, "container_id", "", "Container ID")
+	f.BoolVar(&p.delete, "delete", false, "Delete the files in the container that aren't in the local directory")
+	f.BoolVar(&p.dryRun, "dry-run", false, "Only list what would be sent and deleted")
+}
+
+func (p *SyncCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	ctx, cancel := p.context(ctx)
+	defer cancel()
+
+	if len(p.containerId) <= 0 {
+		logf(ctx, "No container ID defined")
+		return subcommands.ExitFailure
+	}
+
+	containerId, err := resolveContainerID(ctx, &p.baseCmd, p.containerId)
+	if err != nil {
+		logf(ctx, "%s\n", err)
+		return subcommands.ExitFailure
+	}
+	p.containerId = containerId
+
+	if len(f.Args()) != 2 {
+		logf(ctx, "Expected a local and a container directory")
+		return subcommands.ExitUsageError
+	}
+	local, remote := f.Arg(0), f.Arg(1)
+
+	if err := checkReadOnly(ctx, serviceName, execMethodName); err != nil {
+		logf(ctx, "%s\n", err)
+		return subcommands.ExitFailure
+	}
+
+	localSums, err := localChecksums(local)
+	if err != nil {
+		logf(ctx, "Failure reading %s: %s\n", local, err)
+		return subcommands.ExitFailure
+	}
+
+	client, cleanup, err := p.newClient(ctx)
+	if err != nil {
+		logf(ctx, "Failure creating client: %s\n", err)
+		return subcommands.ExitFailure
+	}
+	defer cleanup()
+
+	remoteSums, err := p.remoteChecksums(ctx, client, remote)
+	if err != nil {
+		logf(ctx, "Failure listing %s in the container: %s\n", remote, err)
+		return subcommands.ExitFailure
+	}
+
+	result := &syncResult{DryRun: p.dryRun}
+
+	for _, path := range sortedPaths(localSums) {
+		if remoteSums[path] == localSums[path] {
+			result.Unchanged++
+			continue
+		}
+		result.Sent = append(result.Sent, path)
+	}
+
+	if p.delete {
+		for _, path := range sortedPaths(remoteSums) {
+			if _, ok := localSums[path]; !ok {
+				result.Deleted = append(result.Deleted, path)
+			}
+		}
+	}
+
+	if !p.dryRun {
+		if err := p.send(ctx, client, local, remote, result.Sent); err != nil {
+			logf(ctx, "Failure sending files: %s\n", err)
+			return subcommands.ExitFailure
+		}
+
+		if err := p.remove(ctx, client, remote, result.Deleted); err != nil {
+			logf(ctx, "Failure deleting files: %s\n", err)
+			return subcommands.ExitFailure
+		}
+	}
+
+	if err := p.report(ctx, result, "Sent %d files, deleted %d, %d unchanged\n", len(result.Sent), len(result.Deleted), result.Unchanged); err != nil {
+		logf(ctx, "Failure reporting result: %s\n", err)
+		return subcommands.ExitFailure
+	}
+
+	return subcommands.ExitSuccess
+}
+
+// localChecksums returns the sha256 of the regular files under dir, by their
+// slash separated path relative to dir.
+func localChecksums(dir string) (map[string]string, error) {
+	sums := map[string]string{}
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		h := sha256.New()
+		if _, err := io.Copy(h, f); err != nil {
+			return err
+		}
+
+		sums[filepath.ToSlash(rel)] = hex.EncodeToString(h.Sum(nil))
+		return nil
+	})
+
+	return sums, err
+}
+
+// remoteChecksums returns the sha256 of the regular files under dir in the
+// container, keyed like localChecksums. A missing dir has no files.
+func (p *SyncCmd) remoteChecksums(ctx context.Context, client *ttrpc.Client, dir string) (map[string]string, error) {
+	var out bytes.Buffer
+
+	script := `[ -d "$1" ] || exit 0; cd "$1" && find . -type f -exec sha256sum {} +`
+	exit, err := execProcess(ctx, &p.baseCmd, client, p.containerId, []string{"sh", "-c", script, "sh", dir}, nil, &out)
+	if err != nil {
+		return nil, err
+	}
+
+	if exit.ExitStatus != 0 {
+		return nil, fmt.Errorf("listing exited with status %d", exit.ExitStatus)
+	}
+
+	sums := map[string]string{}
+
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		// sha256sum escapes names with newlines or backslashes and marks
+		// the line with a leading \, those files are simply sent again
+		sum, path, ok := strings.Cut(scanner.Text(), "  ")
+		if !ok || strings.HasPrefix(sum, `\`) {
+			continue
+		}
+		sums[strings.TrimPrefix(path, "./")] = sum
+	}
+
+	return sums, scanner.Err()
+}
+
+// send writes the files to a tar stream that tar unpacks under remote.
+func (p *SyncCmd) send(ctx context.Context, client *ttrpc.Client, local, remote string, paths []string) error {
+	if len(paths) <= 0 {
+		return nil
+	}
+
+	stream, err := os.CreateTemp("", "sync")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(stream.Name())
+	defer stream.Close()
+
+	tw := tar.NewWriter(stream)
+	for _, path := range paths {
+		if err := addToTar(tw, filepath.Join(local, filepath.FromSlash(path)), path); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+
+	if _, err := stream.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	script := `mkdir -p "$1" && tar -xf - -C "$1"`
+	exit, err := execProcess(ctx, &p.baseCmd, client, p.containerId, []string{"sh", "-c", script, "sh", remote}, stream, nil)
+	if err != nil {
+		return err
+	}
+
+	if exit.ExitStatus != 0 {
+		return fmt.Errorf("tar exited with status %d", exit.ExitStatus)
+	}
+
+	return nil
+}
+
+// addToTar adds the file at path to tw as name, the directories leading to
+// it are created by tar.
+func addToTar(tw *tar.Writer, path, name string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	hdr, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// remove deletes the files under remote.
+func (p *SyncCmd) remove(ctx context.Context, client *ttrpc.Client, remote string, paths []string) error {
+	for len(paths) > 0 {
+		batch := paths
+		if len(batch) > syncDeleteBatch {
+			batch = batch[:syncDeleteBatch]
+		}
+		paths = paths[len(batch):]
+
+		args := []string{"sh", "-c", `cd "$1" && shift && rm -f -- "$@"`, "sh", remote}
+		exit, err := execProcess(ctx, &p.baseCmd, client, p.containerId, append(args, batch...), nil, nil)
+		if err != nil {
+			return err
+		}
+
+		if exit.ExitStatus != 0 {
+			return fmt.Errorf("rm exited with status %d", exit.ExitStatus)
+		}
+	}
+
+	return nil
+}
+
+func sortedPaths(sums map[string]string) []string {
+	paths := make([]string, 0, len(sums))
+	for path := range sums {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
@@ -0,0 +1,68 @@
+package command
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+
+	"sigs.k8s.io/yaml"
+)
+
+// templateVars collects repeated -var name=value flags.
+type templateVars map[string]string
+
+func (v templateVars) String() string {
+	return fmt.Sprint(map[string]string(v))
+}
+
+func (v templateVars) Set(value string) error {
+	name, val, ok := strings.Cut(value, "=")
+	if !ok || len(name) <= 0 {
+		return fmt.Errorf("expected name=value, got %q", value)
+	}
+	v[name] = val
+	return nil
+}
+
+// loadTemplates reads a file of named commands, like
+//
+//	diag: ["sh", "-c", "dmesg | tail -n {{.lines}}"]
+func loadTemplates(path string) (map[string][]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	templates := map[string][]string{}
+	if err := yaml.UnmarshalStrict(b, &templates); err != nil {
+		return nil, fmt.Errorf("parsing templates %s: %w", path, err)
+	}
+
+	return templates, nil
+}
+
+// expandTemplate fills vars into each argument of the template name, a var
+// the template uses but wasn't given is an error.
+func expandTemplate(templates map[string][]string, name string, vars templateVars) ([]string, error) {
+	args, ok := templates[name]
+	if !ok {
+		return nil, fmt.Errorf("no template named %s", name)
+	}
+
+	expanded := make([]string, 0, len(args))
+	for _, arg := range args {
+		t, err := template.New(name).Option("missingkey=error").Parse(arg)
+		if err != nil {
+			return nil, fmt.Errorf("parsing template %s: %w", name, err)
+		}
+
+		var sb strings.Builder
+		if err := t.Execute(&sb, map[string]string(vars)); err != nil {
+			return nil, fmt.Errorf("expanding template %s: %w", name, err)
+		}
+		expanded = append(expanded, sb.String())
+	}
+
+	return expanded, nil
+}
@@ -0,0 +1,48 @@
+package command
+
+import (
+	"errors"
+	"flag"
+
+	"github.com/dehydr8/firecracker-containerd-agent-client/client"
+)
+
+// tlsFlags configures the optional TLS layer on top of the vsock connection.
+type tlsFlags struct {
+	cert       string
+	key        string
+	ca         string
+	serverName string
+}
+
+func (t *tlsFlags) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&t.cert, "tls-cert", "", "TLS client certificate")
+	f.StringVar(&t.key, "tls-key", "", "TLS client key")
+	f.StringVar(&t.ca, "tls-ca", "", "TLS CA bundle used to verify the agent")
+	f.StringVar(&t.serverName, "tls-server-name", "", "TLS server name to verify, only the chain is verified if empty")
+}
+
+func (t *tlsFlags) enabled() bool {
+	return len(t.cert) > 0 || len(t.key) > 0 || len(t.ca) > 0 || len(t.serverName) > 0
+}
+
+// transport wraps base in TLS if any of the TLS flags were given.
+func (t *tlsFlags) transport(base client.Transport) (client.Transport, error) {
+	if !t.enabled() {
+		return base, nil
+	}
+
+	if (len(t.cert) > 0) != (len(t.key) > 0) {
+		return nil, errors.New("-tls-cert and -tls-key must be given together")
+	}
+
+	config, err := client.NewTLSConfig(t.cert, t.key, t.ca, t.serverName)
+	if err != nil {
+		return nil, err
+	}
+
+	return &client.TLSTransport{
+		Transport: base,
+		Config:    config,
+	}, nil
+}
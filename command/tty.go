@@ -0,0 +1,40 @@
+package command
+
+import (
+	"fmt"
+	"strconv"
+)
+
+const ttyAuto = "auto"
+
+// ttyFlag is -tty: true or false, or auto to decide from whether stdin and
+// stdout are terminals, like docker exec -it is used. A bare -tty is true.
+type ttyFlag struct {
+	auto  bool
+	value bool
+}
+
+func (t *ttyFlag) String() string {
+	if t.auto {
+		return ttyAuto
+	}
+	return strconv.FormatBool(t.value)
+}
+
+func (t *ttyFlag) Set(s string) error {
+	if s == ttyAuto {
+		t.auto = true
+		return nil
+	}
+
+	value, err := strconv.ParseBool(s)
+	if err != nil {
+		return fmt.Errorf("expected true, false or %s", ttyAuto)
+	}
+
+	t.auto = false
+	t.value = value
+	return nil
+}
+
+func (*ttyFlag) IsBoolFlag() bool { return true }
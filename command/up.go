@@ -0,0 +1,263 @@
+package command
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	shim "github.com/containerd/containerd/api/runtime/task/v2"
+	"github.com/containerd/ttrpc"
+	"github.com/dehydr8/firecracker-containerd-agent-client/proto"
+	"github.com/google/subcommands"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+const (
+	driveMounterServiceName = "DriveMounter"
+	mountDriveMethodName    = "MountDrive"
+	unmountDriveMethodName  = "UnmountDrive"
+
+	defaultAgentTimeout = 30 * time.Second
+	agentPollInterval   = 500 * time.Millisecond
+
+	// agentProbeTimeout bounds one call of the wait for the agent, a guest
+	// still booting may accept the connection without answering
+	agentProbeTimeout = 2 * time.Second
+)
+
+// upResult is reported once everything of the manifest runs.
+type upResult struct {
+	Drives     []string       `json:"drives"`
+	Containers []*applyResult `json:"containers"`
+}
+
+type UpCmd struct {
+	baseCmd
+
+	manifest string
+	detach   bool
+}
+
+func (*UpCmd) Name() string     { return "up" }
+func (*UpCmd) Synopsis() string { return "Bring up the VM, drives and containers of a manifest" }
+func (*UpCmd) Usage() string {
+	return `up -f manifest.yaml [-detach]:
+	Wait for the agent of the manifest's VM, running its launch command
+	first if the agent doesn't answer, mount its drives, bring its
+	containers to running like apply -reconcile and attach to the primary
+	container. Running it again only does what is left to do.
+  `
+}
+
+func (p *UpCmd) SetFlags(f *flag.FlagSet) {
+	p.baseCmd.SetFlags(f)
+	f.StringVar(&p.manifest, "f", "", "Manifest file")
+	f.BoolVar(&p.detach, "detach", false, "Don't attach to the primary container")
+}
+
+func (*UpCmd) lateTarget() {}
+
+func (p *UpCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	ctx, cancel := p.context(ctx)
+	defer cancel()
+
+	if len(p.manifest) <= 0 {
+		logf(ctx, "No manifest defined")
+		return subcommands.ExitFailure
+	}
+
+	m, err := loadManifest(p.manifest)
+	if err != nil {
+		logf(ctx, "Failure loading manifest: %s\n", err)
+		return subcommands.ExitFailure
+	}
+
+	containers, err := m.ordered()
+	if err != nil {
+		logf(ctx, "Failure ordering manifest: %s\n", err)
+		return subcommands.ExitFailure
+	}
+
+	if len(m.Drives) > 0 {
+		if err := checkReadOnly(ctx, driveMounterServiceName, mountDriveMethodName); err != nil {
+			logf(ctx, "%s\n", err)
+			return subcommands.ExitFailure
+		}
+	}
+
+	methods := []string{createMethodName, startMethodName, killMethodName, deleteMethodName}
+	if m.Primary != nil && !p.detach {
+		methods = append(methods, execMethodName)
+	}
+
+	for _, method := range methods {
+		if err := checkReadOnly(ctx, serviceName, method); err != nil {
+			logf(ctx, "%s\n", err)
+			return subcommands.ExitFailure
+		}
+	}
+
+	if err := m.VM.locate(ctx, &p.baseCmd, f); err != nil {
+		logf(ctx, "%s\n", err)
+		return subcommands.ExitUsageError
+	}
+
+	client, cleanup, err := p.waitForAgent(ctx, m.VM)
+	if err != nil {
+		logf(ctx, "Failure reaching the agent: %s\n", err)
+		return subcommands.ExitFailure
+	}
+	defer cleanup()
+
+	result := &upResult{
+		Drives:     []string{},
+		Containers: []*applyResult{},
+	}
+
+	for _, d := range m.Drives {
+		if err := mountDrive(ctx, client, d); err != nil {
+			logf(ctx, "Failure mounting drive %s: %s\n", d.ID, err)
+			return subcommands.ExitFailure
+		}
+		result.Drives = append(result.Drives, d.ID)
+	}
+
+	for _, c := range containers {
+		applied, err := reconcileContainer(ctx, client, c)
+		if err != nil {
+			logf(ctx, "Failure applying container %s: %s\n", c.ID, err)
+			return subcommands.ExitFailure
+		}
+
+		logf(ctx, "Container %s %s, PID: %d\n", c.ID, applied.Action, applied.Pid)
+
+		if applied.Action == applyCreated || applied.Action == applyRecreated {
+			recordDeclaredContainer(ctx, &p.baseCmd, c, p.manifest)
+		}
+
+		result.Containers = append(result.Containers, applied)
+	}
+
+	if err := p.report(ctx, result, "Up: %d drives, %d containers from %s\n", len(result.Drives), len(result.Containers), p.manifest); err != nil {
+		logf(ctx, "Failure reporting result: %s\n", err)
+		return subcommands.ExitFailure
+	}
+
+	if m.Primary == nil || p.detach {
+		return subcommands.ExitSuccess
+	}
+
+	args := m.Primary.Args
+	if len(args) <= 0 {
+		args = []string{"sh"}
+	}
+
+	logf(ctx, "Attaching to container %s: %s\n", m.Primary.ID, strings.Join(args, " "))
+
+	exit, err := execProcess(ctx, &p.baseCmd, client, m.Primary.ID, args, os.Stdin, os.Stdout)
+	if err != nil {
+		logf(ctx, "Failure attaching to container %s: %s\n", m.Primary.ID, err)
+		return subcommands.ExitFailure
+	}
+
+	if exit.ExitStatus != 0 {
+		logf(ctx, "Process exited with status: %d\n", exit.ExitStatus)
+		return subcommands.ExitFailure
+	}
+
+	return subcommands.ExitSuccess
+}
+
+// waitForAgent dials the agent until it answers. If it doesn't at first and
+// the VM has a launch command, that is run once and the wait starts over.
+func (p *UpCmd) waitForAgent(ctx context.Context, vm *manifestVM) (*ttrpc.Client, func(), error) {
+	timeout := defaultAgentTimeout
+	var launch []string
+
+	if vm != nil {
+		launch = vm.Launch
+		if len(vm.AgentTimeout) > 0 {
+			// checked by loadManifest
+			timeout, _ = time.ParseDuration(vm.AgentTimeout)
+		}
+	}
+
+	deadline := time.Now().Add(timeout)
+
+	for {
+		client, cleanup, err := p.newClient(ctx)
+		if err == nil {
+			if err = agentAnswers(ctx, client); err == nil {
+				return client, cleanup, nil
+			}
+			cleanup()
+		}
+
+		if len(launch) > 0 {
+			logf(ctx, "Agent not answering, launching the VM: %s\n", strings.Join(launch, " "))
+
+			cmd := exec.CommandContext(ctx, launch[0], launch[1:]...)
+			cmd.Stdout = os.Stderr
+			cmd.Stderr = os.Stderr
+			if err := cmd.Run(); err != nil {
+				return nil, nil, fmt.Errorf("launching the VM: %w", err)
+			}
+
+			launch = nil
+			deadline = time.Now().Add(timeout)
+			continue
+		}
+
+		if time.Now().After(deadline) {
+			return nil, nil, fmt.Errorf("no answer after %s: %w", timeout, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-time.After(agentPollInterval):
+		}
+	}
+}
+
+// agentAnswers tells whether the agent behind client answers calls, an
+// error it returns is an answer too.
+func agentAnswers(ctx context.Context, client *ttrpc.Client) error {
+	ctx, cancel := context.WithTimeout(ctx, agentProbeTimeout)
+	defer cancel()
+
+	err := client.Call(ctx, serviceName, connectMethodName, &shim.ConnectRequest{}, &shim.ConnectResponse{})
+	if _, ok := status.FromError(err); ok {
+		return nil
+	}
+	return err
+}
+
+// mountDrive has the agent mount the drive, a drive already mounted is left
+// as it is.
+func mountDrive(ctx context.Context, client *ttrpc.Client, d *manifestDrive) error {
+	req := &proto.MountDriveRequest{
+		DriveID:         d.ID,
+		DestinationPath: d.Path,
+		FilesytemType:   d.FSType,
+		Options:         d.Options,
+	}
+
+	err := client.Call(ctx, driveMounterServiceName, mountDriveMethodName, req, &emptypb.Empty{})
+	if status.Code(err) == codes.AlreadyExists {
+		logf(ctx, "Drive %s already mounted\n", d.ID)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	logf(ctx, "Drive %s mounted at %s\n", d.ID, d.Path)
+	return nil
+}
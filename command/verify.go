@@ -0,0 +1,71 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	shim "github.com/containerd/containerd/api/runtime/task/v2"
+	"github.com/containerd/containerd/api/types/task"
+	"github.com/containerd/ttrpc"
+)
+
+// specDifference is a setting the agent reports differently from how it was
+// sent in Create.
+type specDifference struct {
+	Field     string `json:"field"`
+	Sent      string `json:"sent"`
+	Effective string `json:"effective"`
+}
+
+// verifyCreated compares what the agent reports about the container created
+// by req with what req asked for. The agent doesn't give the spec back, so
+// only the settings State reports can be compared.
+func verifyCreated(ctx context.Context, client *ttrpc.Client, req *shim.CreateTaskRequest) ([]specDifference, error) {
+	stateReq := &shim.StateRequest{
+		ID: req.ID,
+	}
+
+	stateRes := &shim.StateResponse{}
+
+	if err := client.Call(ctx, serviceName, stateMethodName, stateReq, stateRes); err != nil {
+		return nil, err
+	}
+
+	var diffs []specDifference
+
+	compare := func(field, sent, effective string) {
+		if sent != effective {
+			diffs = append(diffs, specDifference{
+				Field:     field,
+				Sent:      sent,
+				Effective: effective,
+			})
+		}
+	}
+
+	compare("bundle", req.Bundle, stateRes.Bundle)
+	compare("stdin", req.Stdin, stateRes.Stdin)
+	compare("stdout", req.Stdout, stateRes.Stdout)
+	compare("stderr", req.Stderr, stateRes.Stderr)
+	compare("terminal", strconv.FormatBool(req.Terminal), strconv.FormatBool(stateRes.Terminal))
+	compare("status", task.Status_CREATED.String(), stateRes.Status.String())
+
+	return diffs, nil
+}
+
+// verifySpec reports the differences verifyCreated finds for container id.
+func (p *CreateCmd) verifySpec(ctx context.Context, req *shim.CreateTaskRequest) ([]specDifference, error) {
+	client, cleanup, err := p.newClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	diffs, err := verifyCreated(ctx, client, req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching state of %s: %w", req.ID, err)
+	}
+
+	return diffs, nil
+}
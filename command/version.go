@@ -0,0 +1,182 @@
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/google/subcommands"
+)
+
+// apiModules are the modules the agent APIs are compiled in from, the ttrpc
+// protos of firecracker-containerd are copied into ./proto.
+var apiModules = []struct {
+	module string
+	api    string
+}{
+	{"github.com/containerd/containerd", "containerd.task.v2"},
+	{"github.com/containerd/ttrpc", "ttrpc"},
+}
+
+// agentCompatibility lists agent versions known not to work with this
+// client, checked in order with the agent version as reported by Connect.
+// None are known yet, entries go here as breaking combinations turn up.
+var agentCompatibility = []struct {
+	match   func(version string) bool
+	warning string
+}{}
+
+type versionResult struct {
+	Version   string            `json:"version"`
+	Revision  string            `json:"revision,omitempty"`
+	BuiltAt   string            `json:"built_at,omitempty"`
+	GoVersion string            `json:"go_version"`
+	APIs      map[string]string `json:"apis"`
+
+	Agent         *agentInfo `json:"agent,omitempty"`
+	AgentWarnings []string   `json:"agent_warnings,omitempty"`
+}
+
+type VersionCmd struct {
+	baseCmd
+
+	checkAgent  bool
+	containerId string
+}
+
+func (*VersionCmd) Name() string     { return "version" }
+func (*VersionCmd) Synopsis() string { return "Print the client version and the agent APIs it speaks" }
+func (*VersionCmd) Usage() string {
+	return `version [-check-agent] [-container_id id]:
+	Print the build of the client and the versions of the agent APIs compiled
+	in. With -check-agent, also ask the agent for its version and warn about
+	combinations known not to work.
+  `
+}
+
+func (p *VersionCmd) SetFlags(f *flag.FlagSet) {
+	p.baseCmd.SetFlags(f)
+	f.BoolVar(&p.checkAgent, "check-agent", false, "Ask the agent for its version and check it against the compatibility matrix")
+	f.StringVar(&p.containerId, "container_id", "", "Container ID to send with Connect, for agents that require one")
+}
+
+func (p *VersionCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	ctx, cancel := p.context(ctx)
+	defer cancel()
+
+	result := buildVersion()
+
+	if p.checkAgent {
+		client, cleanup, err := p.newClient(ctx)
+		if err != nil {
+			logf(ctx, "Failure creating client: %s\n", err)
+			return subcommands.ExitFailure
+		}
+		defer cleanup()
+
+		info := &InfoCmd{baseCmd: p.baseCmd, containerId: p.containerId}
+		if result.Agent, err = info.agentInfo(ctx, client); err != nil {
+			logf(ctx, "Failure asking the agent for its version: %s\n", err)
+			return subcommands.ExitFailure
+		}
+
+		result.AgentWarnings = agentWarnings(result.Agent.Version)
+	}
+
+	for _, warning := range result.AgentWarnings {
+		logf(ctx, "Warning: %s\n", warning)
+	}
+
+	printed, err := p.printExtract(result)
+	if err != nil {
+		logf(ctx, "Failure extracting from result: %s\n", err)
+		return subcommands.ExitFailure
+	}
+
+	if printed {
+		return subcommands.ExitSuccess
+	}
+
+	if p.output == outputJSON {
+		out, _ := json.Marshal(result)
+		fmt.Fprintln(os.Stdout, string(out))
+		return subcommands.ExitSuccess
+	}
+
+	fmt.Printf("Version:    %s\n", result.Version)
+	if len(result.Revision) > 0 {
+		fmt.Printf("Revision:   %s\n", result.Revision)
+	}
+	if len(result.BuiltAt) > 0 {
+		fmt.Printf("Built:      %s\n", result.BuiltAt)
+	}
+	fmt.Printf("Go version: %s\n", result.GoVersion)
+	for _, m := range apiModules {
+		fmt.Printf("API:        %s %s\n", m.api, result.APIs[m.api])
+	}
+	if result.Agent != nil {
+		fmt.Printf("Agent:      %q\n", result.Agent.Version)
+	}
+
+	return subcommands.ExitSuccess
+}
+
+// agentWarnings returns the warnings of agentCompatibility that apply to
+// the agent version.
+func agentWarnings(version string) []string {
+	var warnings []string
+	for _, c := range agentCompatibility {
+		if c.match(version) {
+			warnings = append(warnings, c.warning)
+		}
+	}
+	return warnings
+}
+
+// buildVersion reads the version of the client and of the API modules from
+// the build info the go toolchain embeds.
+func buildVersion() *versionResult {
+	result := &versionResult{
+		Version:   "unknown",
+		GoVersion: runtime.Version(),
+		APIs:      map[string]string{},
+	}
+
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return result
+	}
+
+	result.Version = info.Main.Version
+
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			result.Revision = setting.Value
+		case "vcs.time":
+			result.BuiltAt = setting.Value
+		case "vcs.modified":
+			if setting.Value == "true" {
+				result.Revision += "-dirty"
+			}
+		}
+	}
+
+	for _, dep := range info.Deps {
+		for _, m := range apiModules {
+			if dep.Path != m.module {
+				continue
+			}
+			if dep.Replace != nil {
+				dep = dep.Replace
+			}
+			result.APIs[m.api] = dep.Version
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,41 @@
+package command
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestAgentWarnings(t *testing.T) {
+	saved := agentCompatibility
+	agentCompatibility = []struct {
+		match   func(version string) bool
+		warning string
+	}{
+		{
+			match:   func(version string) bool { return strings.HasPrefix(version, "v0.") },
+			warning: "agents before v1 don't report exit events",
+		},
+		{
+			match:   func(version string) bool { return version == "v0.9.1" },
+			warning: "v0.9.1 drops stdin",
+		},
+	}
+	t.Cleanup(func() { agentCompatibility = saved })
+
+	tests := []struct {
+		version string
+		want    []string
+	}{
+		{"v0.9.1", []string{"agents before v1 don't report exit events", "v0.9.1 drops stdin"}},
+		{"v0.8.0", []string{"agents before v1 don't report exit events"}},
+		{"v1.2.0", nil},
+		{"", nil},
+	}
+
+	for _, tt := range tests {
+		if got := agentWarnings(tt.version); !slices.Equal(got, tt.want) {
+			t.Errorf("agentWarnings(%q) = %q, want %q", tt.version, got, tt.want)
+		}
+	}
+}
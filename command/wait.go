@@ -0,0 +1,339 @@
+package command
+
+import (
+	"context"
+	"flag"
+	"strings"
+	"time"
+
+	apievents "github.com/containerd/containerd/api/events"
+	shim "github.com/containerd/containerd/api/runtime/task/v2"
+	events "github.com/containerd/containerd/api/services/events/v1"
+	"github.com/containerd/ttrpc"
+	"github.com/dehydr8/firecracker-containerd-agent-client/util"
+	"github.com/google/subcommands"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+const (
+	eventServiceName   = "aws.firecracker.containerd.eventbridge.getter"
+	getEventMethodName = "GetEvent"
+	taskExitEventTopic = "/tasks/exit"
+
+	// the event bridge answers GetEvent with an empty envelope when it has
+	// nothing queued, so polls back off between these while it stays quiet
+	eventPollMinInterval     = 10 * time.Millisecond
+	defaultEventPollInterval = time.Second
+
+	waitModeAny = "any"
+	waitModeAll = "all"
+)
+
+// waitResult is reported once the process exited.
+type waitResult struct {
+	ContainerID string    `json:"container_id"`
+	ExecID      string    `json:"exec_id,omitempty"`
+	ExitStatus  uint32    `json:"exit_status"`
+	ExitedAt    time.Time `json:"exited_at"`
+}
+
+// waitOutcome is how one of several processes waited on ended, Exited is
+// false for those still running once wait -mode any returned.
+type waitOutcome struct {
+	ContainerID string     `json:"container_id"`
+	ExecID      string     `json:"exec_id,omitempty"`
+	Exited      bool       `json:"exited"`
+	ExitStatus  uint32     `json:"exit_status"`
+	ExitedAt    *time.Time `json:"exited_at,omitempty"`
+	Error       string     `json:"error,omitempty"`
+}
+
+func (o *waitOutcome) name() string {
+	if len(o.ExecID) > 0 {
+		return o.ContainerID + "/" + o.ExecID
+	}
+	return o.ContainerID
+}
+
+// multiWaitResult is reported when waiting on several processes, First
+// names the one that exited first.
+type multiWaitResult struct {
+	Mode     string         `json:"mode"`
+	First    string         `json:"first,omitempty"`
+	Outcomes []*waitOutcome `json:"outcomes"`
+}
+
+type WaitCmd struct {
+	baseCmd
+
+	containerId string
+	execId      string
+	ids         stringList
+	mode        string
+	viaEvents   bool
+	keepalive   time.Duration
+	pollMax     time.Duration
+}
+
+func (*WaitCmd) Name() string     { return "wait" }
+func (*WaitCmd) Synopsis() string { return "Wait for a container or exec'd process to exit" }
+func (*WaitCmd) Usage() string {
+	return `wait -container_id id [-exec_id id] [-via-events]
+wait -id container[/exec] -id ... [-mode any|all]:
+	Wait for the process to exit and print its exit status. Several
+	processes are waited on at once over one connection, until any or all
+	of them exited.
+  `
+}
+
+func (p *WaitCmd) SetFlags(f *flag.FlagSet) {
+	p.baseCmd.SetFlags(f)
+	f.StringVar(&p.containerId, "container_id", "", "Container ID")
+	f.StringVar(&p.execId, "exec_id", "", "Execution ID")
+	f.Var(&p.ids, "id", "Process to wait on, as container[/exec], together with the others given (repeatable)")
+	f.StringVar(&p.mode, "mode", waitModeAll, "With several processes, return once any of them or all of them exited (any, all)")
+	f.BoolVar(&p.viaEvents, "via-events", false, "Poll the event bridge for the exit event instead of holding a Wait call open")
+	f.DurationVar(&p.keepalive, "keepalive-interval", 0, "Interval of keepalive calls to detect a dead agent, 0 to disable")
+	f.DurationVar(&p.pollMax, "event-poll-max-interval", defaultEventPollInterval, "Longest pause between event bridge polls while no events come, with -via-events")
+}
+
+func (p *WaitCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	ctx, cancel := p.context(ctx)
+	defer cancel()
+
+	if len(p.ids) > 0 {
+		return p.executeMany(ctx, cancel)
+	}
+
+	if len(p.containerId) <= 0 {
+		logf(ctx, "No container ID defined")
+		return subcommands.ExitFailure
+	}
+
+	containerId, err := resolveContainerID(ctx, &p.baseCmd, p.containerId)
+	if err != nil {
+		logf(ctx, "%s\n", err)
+		return subcommands.ExitFailure
+	}
+	p.containerId = containerId
+
+	client, cleanup, err := p.newClient(ctx)
+	if err != nil {
+		logf(ctx, "Failure creating client: %s\n", err)
+		return subcommands.ExitFailure
+	}
+	defer cleanup()
+
+	keepAlive(ctx, client, p.containerId, p.keepalive, cancel)
+
+	var result *waitResult
+
+	if p.viaEvents {
+		result, err = waitForExitEvent(ctx, client, p.containerId, p.execId, util.NewBackoff(eventPollMinInterval, p.pollMax))
+	} else {
+		result, err = waitForExit(ctx, client, p.containerId, p.execId)
+	}
+
+	if err != nil {
+		logf(ctx, "Failure waiting for exit: %s\n", err)
+		return subcommands.ExitFailure
+	}
+
+	if err := p.report(ctx, result, "Process exited with status: %d\n", result.ExitStatus); err != nil {
+		logf(ctx, "Failure reporting result: %s\n", err)
+		return subcommands.ExitFailure
+	}
+
+	return subcommands.ExitSuccess
+}
+
+// executeMany waits on the processes of -id, and -container_id if given,
+// concurrently.
+func (p *WaitCmd) executeMany(ctx context.Context, cancel context.CancelFunc) subcommands.ExitStatus {
+	if p.mode != waitModeAny && p.mode != waitModeAll {
+		logf(ctx, "Unknown wait mode: %s\n", p.mode)
+		return subcommands.ExitFailure
+	}
+
+	// the event bridge hands each event out once, concurrent pollers
+	// would take each other's
+	if p.viaEvents {
+		logf(ctx, "-via-events waits on one process, not on several -id\n")
+		return subcommands.ExitFailure
+	}
+
+	var outcomes []*waitOutcome
+
+	if len(p.containerId) > 0 {
+		outcomes = append(outcomes, &waitOutcome{ContainerID: p.containerId, ExecID: p.execId})
+	}
+
+	for _, id := range p.ids {
+		containerId, execId, _ := strings.Cut(id, "/")
+		if len(containerId) <= 0 {
+			logf(ctx, "No container ID in -id %s\n", id)
+			return subcommands.ExitFailure
+		}
+		outcomes = append(outcomes, &waitOutcome{ContainerID: containerId, ExecID: execId})
+	}
+
+	for _, o := range outcomes {
+		containerId, err := resolveContainerID(ctx, &p.baseCmd, o.ContainerID)
+		if err != nil {
+			logf(ctx, "%s\n", err)
+			return subcommands.ExitFailure
+		}
+		o.ContainerID = containerId
+	}
+
+	client, cleanup, err := p.newClient(ctx)
+	if err != nil {
+		logf(ctx, "Failure creating client: %s\n", err)
+		return subcommands.ExitFailure
+	}
+	defer cleanup()
+
+	keepAlive(ctx, client, outcomes[0].ContainerID, p.keepalive, cancel)
+
+	result := waitForAll(ctx, client, outcomes, p.mode == waitModeAny)
+	result.Mode = p.mode
+
+	if err := p.report(ctx, result, "First to exit: %s\n", result.First); err != nil {
+		logf(ctx, "Failure reporting result: %s\n", err)
+		return subcommands.ExitFailure
+	}
+
+	failed := false
+	for _, o := range result.Outcomes {
+		if len(o.Error) > 0 {
+			failed = true
+		}
+	}
+
+	if len(result.First) <= 0 || (p.mode == waitModeAll && failed) {
+		return subcommands.ExitFailure
+	}
+
+	return subcommands.ExitSuccess
+}
+
+// waitForAll waits on the processes of outcomes concurrently and fills in
+// how each ended, or only the first to exit with firstOnly.
+func waitForAll(ctx context.Context, client *ttrpc.Client, outcomes []*waitOutcome, firstOnly bool) *multiWaitResult {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type done struct {
+		outcome *waitOutcome
+		result  *waitResult
+		err     error
+	}
+
+	doneCh := make(chan done, len(outcomes))
+	for _, o := range outcomes {
+		go func(o *waitOutcome) {
+			result, err := waitForExit(ctx, client, o.ContainerID, o.ExecID)
+			doneCh <- done{o, result, err}
+		}(o)
+	}
+
+	result := &multiWaitResult{
+		Outcomes: outcomes,
+	}
+
+	for range outcomes {
+		d := <-doneCh
+
+		if d.err != nil {
+			logf(ctx, "Failure waiting for %s: %s\n", d.outcome.name(), d.err)
+			d.outcome.Error = d.err.Error()
+			continue
+		}
+
+		d.outcome.Exited = true
+		d.outcome.ExitStatus = d.result.ExitStatus
+		d.outcome.ExitedAt = &d.result.ExitedAt
+
+		logf(ctx, "Process %s exited with status: %d\n", d.outcome.name(), d.result.ExitStatus)
+
+		if len(result.First) <= 0 {
+			result.First = d.outcome.name()
+		}
+
+		if firstOnly {
+			break
+		}
+	}
+
+	return result
+}
+
+// waitForExit blocks in Task/Wait until the process exits.
+func waitForExit(ctx context.Context, client *ttrpc.Client, containerId, execId string) (*waitResult, error) {
+	req := &shim.WaitRequest{
+		ID:     containerId,
+		ExecID: execId,
+	}
+
+	res := &shim.WaitResponse{}
+
+	if err := client.Call(ctx, serviceName, waitMethodName, req, res); err != nil {
+		return nil, err
+	}
+
+	return &waitResult{
+		ContainerID: containerId,
+		ExecID:      execId,
+		ExitStatus:  res.ExitStatus,
+		ExitedAt:    res.ExitedAt.AsTime(),
+	}, nil
+}
+
+// waitForExitEvent pulls events from the event bridge until the TaskExit
+// event of the process shows up. GetEvent removes the event from the queue,
+// so events of other processes read here are lost to other consumers.
+func waitForExitEvent(ctx context.Context, client *ttrpc.Client, containerId, execId string, backoff *util.Backoff) (*waitResult, error) {
+	// the init process of a container is reported with its container ID
+	id := execId
+	if len(id) <= 0 {
+		id = containerId
+	}
+
+	for {
+		envelope := &events.Envelope{}
+
+		if err := client.Call(ctx, eventServiceName, getEventMethodName, &emptypb.Empty{}, envelope); err != nil {
+			return nil, err
+		}
+
+		if envelope.Event == nil {
+			if err := backoff.Wait(ctx); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		backoff.Reset()
+
+		if envelope.Topic != taskExitEventTopic {
+			continue
+		}
+
+		exit := &apievents.TaskExit{}
+		if err := proto.Unmarshal(envelope.Event.Value, exit); err != nil {
+			return nil, err
+		}
+
+		if exit.ContainerID != containerId || exit.ID != id {
+			continue
+		}
+
+		return &waitResult{
+			ContainerID: containerId,
+			ExecID:      execId,
+			ExitStatus:  exit.ExitStatus,
+			ExitedAt:    exit.ExitedAt.AsTime(),
+		}, nil
+	}
+}
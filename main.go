@@ -2,22 +2,115 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
+	"fmt"
+	"log"
 	"os"
+	"runtime/debug"
 
 	"github.com/dehydr8/firecracker-containerd-agent-client/command"
 	"github.com/google/subcommands"
 )
 
+// crashExitCode is EX_SOFTWARE of sysexits.h, set apart from the exit
+// codes of failing commands.
+const crashExitCode = 70
+
+// crashReport is written to stderr instead of the stack trace of a panic.
+type crashReport struct {
+	Crash   string `json:"crash"`
+	Command string `json:"command,omitempty"`
+	Version string `json:"version,omitempty"`
+	Stack   string `json:"stack"`
+}
+
+// recoverCrash turns a panic of the main goroutine into a crashReport and
+// exits with crashExitCode. It must be deferred by main.
+func recoverCrash() {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	report := &crashReport{
+		Crash:   fmt.Sprint(r),
+		Command: flag.Arg(0),
+		Stack:   string(debug.Stack()),
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		report.Version = info.Main.Version
+	}
+
+	out, _ := json.Marshal(report)
+	fmt.Fprintln(os.Stderr, string(out))
+	os.Exit(crashExitCode)
+}
+
 func main() {
+	defer recoverCrash()
+
 	subcommands.Register(subcommands.HelpCommand(), "")
 	subcommands.Register(subcommands.FlagsCommand(), "")
 	subcommands.Register(subcommands.CommandsCommand(), "")
-	subcommands.Register(&command.CallCmd{}, "")
-	subcommands.Register(&command.ExecCmd{}, "")
-	subcommands.Register(&command.CreateCmd{}, "")
+	subcommands.Register(command.WithPolicy(&command.CallCmd{}), "")
+	subcommands.Register(command.WithPolicy(&command.ExecCmd{}), "")
+	subcommands.Register(command.WithPolicy(&command.CreateCmd{}), "")
+	subcommands.Register(command.WithPolicy(&command.WaitCmd{}), "")
+	subcommands.Register(command.WithPolicy(&command.EventsCmd{}), "")
+	subcommands.Register(command.WithPolicy(&command.StatsCmd{}), "")
+	subcommands.Register(command.WithPolicy(&command.CloseIOCmd{}), "")
+	subcommands.Register(command.WithPolicy(&command.ApplyCmd{}), "")
+	subcommands.Register(command.WithPolicy(&command.DeleteCmd{}), "")
+	subcommands.Register(command.WithPolicy(&command.ListCmd{}), "")
+	subcommands.Register(command.WithPolicy(&command.JobCmd{}), "")
+	subcommands.Register(command.WithPolicy(&command.BenchCmd{}), "")
+	subcommands.Register(command.WithPolicy(&command.BundleCmd{}), "")
+	subcommands.Register(command.WithPolicy(&command.ImageCmd{}), "")
+	subcommands.Register(command.WithPolicy(&command.DriveCmd{}), "")
+	subcommands.Register(command.WithPolicy(&command.InfoCmd{}), "")
+	subcommands.Register(command.WithPolicy(&command.DiagnoseCmd{}), "")
+	subcommands.Register(command.WithPolicy(&command.VersionCmd{}), "")
+	subcommands.Register(command.WithPolicy(&command.SyncCmd{}), "")
+	subcommands.Register(command.WithPolicy(&command.UpCmd{}), "")
+	subcommands.Register(command.WithPolicy(&command.DownCmd{}), "")
+
+	// hidden commands are left out of the help output
+	hidden := subcommands.NewCommander(flag.CommandLine, os.Args[0])
+	hidden.Register(command.WithPolicy(&command.MockAgentCmd{}), "")
+
+	globals := &command.Globals{}
+	globals.SetFlags(flag.CommandLine)
+
+	// hidden flags are left out of flag.CommandLine, which the help output
+	// lists, and only known to the set the command line is parsed with
+	parsed := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	parsed.Usage = flag.CommandLine.Usage
+	flag.CommandLine.VisitAll(func(fl *flag.Flag) {
+		parsed.Var(fl.Value, fl.Name, fl.Usage)
+	})
+	globals.SetHiddenFlags(parsed)
+
+	parsed.Parse(os.Args[1:])
+	flag.CommandLine.Parse(parsed.Args())
+	ctx, err := globals.WithLogger(command.WithGlobals(context.Background(), globals))
+	if err != nil {
+		log.Fatalf("%s\n", err)
+	}
+
+	if err := globals.StartProfiling(ctx); err != nil {
+		log.Fatalf("Failure serving pprof: %s\n", err)
+	}
+
+	commander := subcommands.DefaultCommander
+	hidden.VisitCommands(func(_ *subcommands.CommandGroup, cmd subcommands.Command) {
+		if cmd.Name() == flag.Arg(0) {
+			commander = hidden
+		}
+	})
+
+	status := commander.Execute(ctx)
+	globals.ReportTimings()
 
-	flag.Parse()
-	ctx := context.Background()
-	os.Exit(int(subcommands.Execute(ctx)))
+	os.Exit(int(status))
 }
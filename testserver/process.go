@@ -0,0 +1,250 @@
+package testserver
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/dehydr8/firecracker-containerd-agent-client/proto"
+	"github.com/sirupsen/logrus"
+	gproto "google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+type container struct {
+	id     string
+	bundle string
+
+	// processes holds the init process under "" and exec'd ones under
+	// their exec ID.
+	processes map[string]*process
+}
+
+type process struct {
+	containerId string
+	execId      string
+	pid         uint32
+	terminal    bool
+
+	stdin  *stream
+	stdout *stream
+	stderr *stream
+
+	mu         sync.Mutex
+	conns      []net.Conn
+	stdinConn  net.Conn
+	started    bool
+	exitStatus uint32
+	exitedAt   time.Time
+	exited     chan struct{}
+}
+
+// stream accepts the single connection the client makes to an IO port.
+type stream struct {
+	path     string
+	listener net.Listener
+	conn     chan net.Conn
+}
+
+func listenStream(socketPath string, port uint32) (*stream, error) {
+	path := fmt.Sprintf("%s_%d", socketPath, port)
+	os.Remove(path)
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	st := &stream{
+		path:     path,
+		listener: listener,
+		conn:     make(chan net.Conn, 1),
+	}
+
+	go func() {
+		conn, err := listener.Accept()
+		listener.Close()
+		os.Remove(path)
+		if err != nil {
+			close(st.conn)
+			return
+		}
+		st.conn <- conn
+	}()
+
+	return st, nil
+}
+
+// accept waits for the client to connect, it returns nil if the stream
+// isn't used or was closed before the client connected.
+func (st *stream) accept() net.Conn {
+	if st == nil {
+		return nil
+	}
+	return <-st.conn
+}
+
+func (st *stream) close() {
+	if st == nil {
+		return
+	}
+	st.listener.Close()
+	os.Remove(st.path)
+}
+
+// newProcess sets up the IO ports the client asked for in the ExtraData
+// options. Like the agent, a stream is only served if its stdio name is set.
+func (s *Server) newProcess(containerId, execId string, options *anypb.Any, terminal bool, stdin, stdout, stderr string) (*process, error) {
+	s.mu.Lock()
+	s.nextPid++
+	pid := s.nextPid
+	s.mu.Unlock()
+
+	p := &process{
+		containerId: containerId,
+		execId:      execId,
+		pid:         pid,
+		terminal:    terminal,
+		exited:      make(chan struct{}),
+	}
+
+	if options == nil {
+		return p, nil
+	}
+
+	extraData := &proto.ExtraData{}
+	if err := gproto.Unmarshal(options.Value, extraData); err != nil {
+		return nil, err
+	}
+
+	var err error
+
+	if len(stdin) > 0 && extraData.StdinPort > 0 {
+		if p.stdin, err = listenStream(s.socketPath, extraData.StdinPort); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(stdout) > 0 && extraData.StdoutPort > 0 {
+		if p.stdout, err = listenStream(s.socketPath, extraData.StdoutPort); err != nil {
+			p.closeStreams()
+			return nil, err
+		}
+	}
+
+	if len(stderr) > 0 && extraData.StderrPort > 0 {
+		if p.stderr, err = listenStream(s.socketPath, extraData.StderrPort); err != nil {
+			p.closeStreams()
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+// closeStdin ends the stdin of the process like CloseIO, the process reads
+// EOF from it.
+func (p *process) closeStdin() {
+	p.stdin.close()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if conn, ok := p.stdinConn.(*net.UnixConn); ok {
+		conn.CloseRead()
+	}
+}
+
+func (p *process) closeStreams() {
+	p.stdin.close()
+	p.stdout.close()
+	p.stderr.close()
+}
+
+// run plays the scripted behavior on the streams of the process and then
+// exits it.
+func (p *process) run(behavior Behavior, onExit func(*process)) {
+	stdin := p.track(p.stdin.accept())
+
+	p.mu.Lock()
+	p.stdinConn = stdin
+	p.mu.Unlock()
+	stdout := p.track(p.stdout.accept())
+	stderr := p.track(p.stderr.accept())
+
+	if stdout != nil {
+		stdout.Write(behavior.Stdout)
+	}
+
+	if stderr != nil {
+		stderr.Write(behavior.Stderr)
+	}
+
+	if stdin != nil && stdout != nil && behavior.EchoStdin {
+		if _, err := io.Copy(stdout, stdin); err != nil {
+			logrus.WithError(err).Debug("echoing stdin")
+		}
+	}
+
+	if len(p.execId) <= 0 && behavior.Lifetime > 0 {
+		select {
+		case <-time.After(behavior.Lifetime):
+		case <-p.exited:
+		}
+	}
+
+	if p.exit(behavior.ExitStatus) {
+		onExit(p)
+	}
+}
+
+// track remembers conn so it is closed once the process exits.
+func (p *process) track(conn net.Conn) net.Conn {
+	if conn == nil {
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.hasExited() {
+		conn.Close()
+	} else {
+		p.conns = append(p.conns, conn)
+	}
+	return conn
+}
+
+// exit marks the process as exited and closes its streams, it returns false
+// if it already was.
+func (p *process) exit(exitStatus uint32) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.hasExited() {
+		return false
+	}
+
+	p.exitStatus = exitStatus
+	p.exitedAt = time.Now()
+	close(p.exited)
+	p.closeStreams()
+
+	for _, conn := range p.conns {
+		conn.Close()
+	}
+
+	return true
+}
+
+func (p *process) hasExited() bool {
+	select {
+	case <-p.exited:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,138 @@
+// Package testserver is a mock of the firecracker-containerd agent. It serves
+// the Task, IOProxy, DriveMounter and event bridge ttrpc services over a unix
+// socket, so the client can be tested without Firecracker.
+package testserver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	events "github.com/containerd/containerd/api/services/events/v1"
+	"github.com/containerd/ttrpc"
+	"github.com/dehydr8/firecracker-containerd-agent-client/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const eventQueueSize = 128
+
+// Behavior scripts how the mock agent answers.
+type Behavior struct {
+	// Delay is waited before answering every call.
+	Delay time.Duration
+
+	// Errors maps "Service/Method" to the message of an error the method
+	// fails with.
+	Errors map[string]string
+
+	// Stdout and Stderr are written by every started process.
+	Stdout []byte
+	Stderr []byte
+
+	// EchoStdin copies the stdin of processes to their stdout.
+	EchoStdin bool
+
+	// ExitStatus is the status processes exit with.
+	ExitStatus uint32
+
+	// Lifetime is how long the init processes of containers run before
+	// exiting, unless killed. Exec'd processes exit right away.
+	Lifetime time.Duration
+}
+
+// Server is the mock agent. IO ports of processes are served on unix sockets
+// named "<socket>_<port>", next to the socket of the ttrpc services.
+type Server struct {
+	socketPath string
+	behavior   Behavior
+
+	mu         sync.Mutex
+	containers map[string]*container
+	mounts     map[string]*proto.MountDriveRequest
+	nextPid    uint32
+
+	events chan *events.Envelope
+}
+
+func New(socketPath string, behavior Behavior) *Server {
+	return &Server{
+		socketPath: socketPath,
+		behavior:   behavior,
+		containers: map[string]*container{},
+		mounts:     map[string]*proto.MountDriveRequest{},
+		nextPid:    1000,
+		events:     make(chan *events.Envelope, eventQueueSize),
+	}
+}
+
+// Serve listens on the socket and answers calls until ctx is done.
+func (s *Server) Serve(ctx context.Context) error {
+	os.Remove(s.socketPath)
+
+	listener, err := net.Listen("unix", s.socketPath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(s.socketPath)
+
+	server, err := ttrpc.NewServer()
+	if err != nil {
+		listener.Close()
+		return err
+	}
+
+	s.registerTask(server)
+	s.registerIOProxy(server)
+	s.registerDriveMounter(server)
+	s.registerEvents(server)
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	err = server.Serve(ctx, listener)
+	if ctx.Err() != nil || errors.Is(err, ttrpc.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// method builds a ttrpc method that applies the scripted delay and errors of
+// service/method before handing the request to fn.
+func method[T any](s *Server, service, name string, fn func(context.Context, *T) (interface{}, error)) ttrpc.Method {
+	key := service + "/" + name
+
+	return func(ctx context.Context, unmarshal func(interface{}) error) (interface{}, error) {
+		req := new(T)
+		if err := unmarshal(req); err != nil {
+			return nil, err
+		}
+
+		if s.behavior.Delay > 0 {
+			select {
+			case <-time.After(s.behavior.Delay):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		if msg, ok := s.behavior.Errors[key]; ok {
+			return nil, status.Error(codes.Unknown, msg)
+		}
+
+		return fn(ctx, req)
+	}
+}
+
+func (s *Server) publish(envelope *events.Envelope) {
+	select {
+	case s.events <- envelope:
+	default:
+		// nobody is consuming events, drop them like a full bridge would
+	}
+}
@@ -0,0 +1,365 @@
+package testserver
+
+import (
+	"context"
+	"os"
+
+	apievents "github.com/containerd/containerd/api/events"
+	shim "github.com/containerd/containerd/api/runtime/task/v2"
+	events "github.com/containerd/containerd/api/services/events/v1"
+	"github.com/containerd/containerd/api/types/task"
+	"github.com/containerd/ttrpc"
+	"github.com/dehydr8/firecracker-containerd-agent-client/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	taskService   = "containerd.task.v2.Task"
+	mockVersion   = "mock"
+	exitTopic     = "/tasks/exit"
+	killedOffset  = 128
+	eventsService = "aws.firecracker.containerd.eventbridge.getter"
+)
+
+func (s *Server) registerTask(server *ttrpc.Server) {
+	server.Register(taskService, map[string]ttrpc.Method{
+		"Create":     method(s, taskService, "Create", s.create),
+		"Start":      method(s, taskService, "Start", s.start),
+		"State":      method(s, taskService, "State", s.state),
+		"Delete":     method(s, taskService, "Delete", s.delete),
+		"Pids":       method(s, taskService, "Pids", s.pids),
+		"Kill":       method(s, taskService, "Kill", s.kill),
+		"Exec":       method(s, taskService, "Exec", s.exec),
+		"Wait":       method(s, taskService, "Wait", s.wait),
+		"Connect":    method(s, taskService, "Connect", s.connect),
+		"CloseIO":    method(s, taskService, "CloseIO", s.closeIO),
+		"Stats":      method(s, taskService, "Stats", empty[shim.StatsRequest](&shim.StatsResponse{})),
+		"Pause":      method(s, taskService, "Pause", empty[shim.PauseRequest](&emptypb.Empty{})),
+		"Resume":     method(s, taskService, "Resume", empty[shim.ResumeRequest](&emptypb.Empty{})),
+		"Checkpoint": method(s, taskService, "Checkpoint", empty[shim.CheckpointTaskRequest](&emptypb.Empty{})),
+		"ResizePty":  method(s, taskService, "ResizePty", empty[shim.ResizePtyRequest](&emptypb.Empty{})),
+		"Update":     method(s, taskService, "Update", empty[shim.UpdateTaskRequest](&emptypb.Empty{})),
+		"Shutdown":   method(s, taskService, "Shutdown", empty[shim.ShutdownRequest](&emptypb.Empty{})),
+	})
+}
+
+func (s *Server) registerEvents(server *ttrpc.Server) {
+	server.Register(eventsService, map[string]ttrpc.Method{
+		"GetEvent": method(s, eventsService, "GetEvent", s.getEvent),
+	})
+}
+
+// empty answers every request with res.
+func empty[T any](res interface{}) func(context.Context, *T) (interface{}, error) {
+	return func(context.Context, *T) (interface{}, error) {
+		return res, nil
+	}
+}
+
+func (s *Server) lookup(containerId, execId string) (*process, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.containers[containerId]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "container %s not found", containerId)
+	}
+
+	p, ok := c.processes[execId]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "process %s not found in container %s", execId, containerId)
+	}
+
+	return p, nil
+}
+
+func (s *Server) create(_ context.Context, req *shim.CreateTaskRequest) (interface{}, error) {
+	s.mu.Lock()
+	_, exists := s.containers[req.ID]
+	s.mu.Unlock()
+
+	if exists {
+		return nil, status.Errorf(codes.AlreadyExists, "container %s already exists", req.ID)
+	}
+
+	p, err := s.newProcess(req.ID, "", req.Options, req.Terminal, req.Stdin, req.Stdout, req.Stderr)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.containers[req.ID] = &container{
+		id:        req.ID,
+		bundle:    req.Bundle,
+		processes: map[string]*process{"": p},
+	}
+	s.mu.Unlock()
+
+	return &shim.CreateTaskResponse{Pid: p.pid}, nil
+}
+
+func (s *Server) exec(_ context.Context, req *shim.ExecProcessRequest) (interface{}, error) {
+	s.mu.Lock()
+	c, ok := s.containers[req.ID]
+	s.mu.Unlock()
+
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "container %s not found", req.ID)
+	}
+
+	p, err := s.newProcess(req.ID, req.ExecID, req.Spec, req.Terminal, req.Stdin, req.Stdout, req.Stderr)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := c.processes[req.ExecID]; exists {
+		p.closeStreams()
+		return nil, status.Errorf(codes.AlreadyExists, "exec %s already exists", req.ExecID)
+	}
+	c.processes[req.ExecID] = p
+
+	return &emptypb.Empty{}, nil
+}
+
+func (s *Server) start(_ context.Context, req *shim.StartRequest) (interface{}, error) {
+	p, err := s.lookup(req.ID, req.ExecID)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	if p.started {
+		p.mu.Unlock()
+		return nil, status.Errorf(codes.FailedPrecondition, "process already started")
+	}
+	p.started = true
+	p.mu.Unlock()
+
+	go p.run(s.behavior, s.exited)
+
+	return &shim.StartResponse{Pid: p.pid}, nil
+}
+
+// exited publishes the exit event of p.
+func (s *Server) exited(p *process) {
+	id := p.execId
+	if len(id) <= 0 {
+		id = p.containerId
+	}
+
+	exit, _ := anypb.New(&apievents.TaskExit{
+		ContainerID: p.containerId,
+		ID:          id,
+		Pid:         p.pid,
+		ExitStatus:  p.exitStatus,
+		ExitedAt:    timestamppb.New(p.exitedAt),
+	})
+
+	s.publish(&events.Envelope{
+		Timestamp: timestamppb.Now(),
+		Topic:     exitTopic,
+		Event:     exit,
+	})
+}
+
+func (s *Server) state(_ context.Context, req *shim.StateRequest) (interface{}, error) {
+	p, err := s.lookup(req.ID, req.ExecID)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	res := &shim.StateResponse{
+		ID:       req.ID,
+		ExecID:   req.ExecID,
+		Pid:      p.pid,
+		Terminal: p.terminal,
+		Status:   task.Status_CREATED,
+	}
+
+	if p.started {
+		res.Status = task.Status_RUNNING
+	}
+
+	if p.hasExited() {
+		res.Status = task.Status_STOPPED
+		res.ExitStatus = p.exitStatus
+		res.ExitedAt = timestamppb.New(p.exitedAt)
+	}
+
+	return res, nil
+}
+
+func (s *Server) kill(_ context.Context, req *shim.KillRequest) (interface{}, error) {
+	p, err := s.lookup(req.ID, req.ExecID)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.exit(killedOffset + req.Signal) {
+		s.exited(p)
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (s *Server) wait(ctx context.Context, req *shim.WaitRequest) (interface{}, error) {
+	p, err := s.lookup(req.ID, req.ExecID)
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case <-p.exited:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return &shim.WaitResponse{
+		ExitStatus: p.exitStatus,
+		ExitedAt:   timestamppb.New(p.exitedAt),
+	}, nil
+}
+
+func (s *Server) delete(_ context.Context, req *shim.DeleteRequest) (interface{}, error) {
+	p, err := s.lookup(req.ID, req.ExecID)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	running := p.started && !p.hasExited()
+	p.mu.Unlock()
+
+	if running {
+		return nil, status.Errorf(codes.FailedPrecondition, "process is still running")
+	}
+
+	p.exit(0)
+
+	s.mu.Lock()
+	if len(req.ExecID) > 0 {
+		delete(s.containers[req.ID].processes, req.ExecID)
+	} else {
+		delete(s.containers, req.ID)
+	}
+	s.mu.Unlock()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return &shim.DeleteResponse{
+		Pid:        p.pid,
+		ExitStatus: p.exitStatus,
+		ExitedAt:   timestamppb.New(p.exitedAt),
+	}, nil
+}
+
+func (s *Server) pids(_ context.Context, req *shim.PidsRequest) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.containers[req.ID]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "container %s not found", req.ID)
+	}
+
+	res := &shim.PidsResponse{}
+	for _, p := range c.processes {
+		if !p.hasExited() {
+			res.Processes = append(res.Processes, &task.ProcessInfo{Pid: p.pid})
+		}
+	}
+
+	return res, nil
+}
+
+func (s *Server) connect(_ context.Context, req *shim.ConnectRequest) (interface{}, error) {
+	res := &shim.ConnectResponse{
+		ShimPid: uint32(os.Getpid()),
+		Version: mockVersion,
+	}
+
+	if p, err := s.lookup(req.ID, ""); err == nil {
+		res.TaskPid = p.pid
+	}
+
+	return res, nil
+}
+
+func (s *Server) closeIO(_ context.Context, req *shim.CloseIORequest) (interface{}, error) {
+	p, err := s.lookup(req.ID, req.ExecID)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Stdin {
+		p.closeStdin()
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (s *Server) getEvent(ctx context.Context, _ *emptypb.Empty) (interface{}, error) {
+	select {
+	case envelope := <-s.events:
+		return envelope, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (s *Server) registerIOProxy(server *ttrpc.Server) {
+	server.Register("IOProxy", map[string]ttrpc.Method{
+		"State": method(s, "IOProxy", "State", func(_ context.Context, req *proto.StateRequest) (interface{}, error) {
+			p, err := s.lookup(req.ID, req.ExecID)
+			if err != nil {
+				return nil, err
+			}
+			return &proto.StateResponse{IsOpen: !p.hasExited()}, nil
+		}),
+		"Attach": method(s, "IOProxy", "Attach", func(_ context.Context, req *proto.AttachRequest) (interface{}, error) {
+			if _, err := s.lookup(req.ID, req.ExecID); err != nil {
+				return nil, err
+			}
+			return &emptypb.Empty{}, nil
+		}),
+	})
+}
+
+func (s *Server) registerDriveMounter(server *ttrpc.Server) {
+	server.Register("DriveMounter", map[string]ttrpc.Method{
+		"MountDrive": method(s, "DriveMounter", "MountDrive", func(_ context.Context, req *proto.MountDriveRequest) (interface{}, error) {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+
+			if _, ok := s.mounts[req.DriveID]; ok {
+				return nil, status.Errorf(codes.AlreadyExists, "drive %s already mounted", req.DriveID)
+			}
+			s.mounts[req.DriveID] = req
+			return &emptypb.Empty{}, nil
+		}),
+		"UnmountDrive": method(s, "DriveMounter", "UnmountDrive", func(_ context.Context, req *proto.UnmountDriveRequest) (interface{}, error) {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+
+			if _, ok := s.mounts[req.DriveID]; !ok {
+				return nil, status.Errorf(codes.NotFound, "drive %s not mounted", req.DriveID)
+			}
+			delete(s.mounts, req.DriveID)
+			return &emptypb.Empty{}, nil
+		}),
+	})
+}
@@ -0,0 +1,115 @@
+package util
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"math"
+	mrand "math/rand"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// MinVsockIOPort is the lowest port handed out for IO, the ones below are
+// left to services like the agent itself.
+const MinVsockIOPort = uint32(12000)
+
+// IDGenerator makes the IDs of containers, processes and IO streams.
+type IDGenerator interface {
+	NewID() string
+}
+
+// UUIDGenerator makes random UUIDs, the default.
+type UUIDGenerator struct{}
+
+func (UUIDGenerator) NewID() string {
+	return uuid.NewString()
+}
+
+// RandomIDGenerator makes random hex IDs of Bytes bytes, 16 if 0.
+type RandomIDGenerator struct {
+	Bytes int
+}
+
+func (g RandomIDGenerator) NewID() string {
+	n := g.Bytes
+	if n <= 0 {
+		n = 16
+	}
+
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// SequentialIDGenerator makes Prefix followed by a counter starting at 1,
+// for tests and for embedders that want readable IDs.
+type SequentialIDGenerator struct {
+	Prefix string
+
+	mu   sync.Mutex
+	next uint64
+}
+
+func (g *SequentialIDGenerator) NewID() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.next++
+	return fmt.Sprintf("%s%d", g.Prefix, g.next)
+}
+
+// PortAllocator hands out the vsock ports the agent connects the IO of a
+// process back to. The n ports of one call are consecutive.
+type PortAllocator interface {
+	AllocatePorts(n int) (uint32, error)
+}
+
+// RandomPortAllocator picks a random run of ports at or above Min,
+// MinVsockIOPort if 0, the default.
+type RandomPortAllocator struct {
+	Min uint32
+}
+
+func (a RandomPortAllocator) AllocatePorts(n int) (uint32, error) {
+	min := a.Min
+	if min == 0 {
+		min = MinVsockIOPort
+	}
+
+	if n <= 0 || uint64(min)+uint64(n) > math.MaxInt32 {
+		return 0, fmt.Errorf("can't allocate %d ports above %d", n, min)
+	}
+
+	p := mrand.Int63n(int64(math.MaxInt32) - int64(min) - int64(n) + 1)
+	return uint32(p) + min, nil
+}
+
+// SequentialPortAllocator hands out ports from Start upwards, wrapping back
+// to Start after End, so tools sharing a CID can each be given their own
+// range.
+type SequentialPortAllocator struct {
+	Start uint32
+	End   uint32
+
+	mu   sync.Mutex
+	next uint32
+}
+
+func (a *SequentialPortAllocator) AllocatePorts(n int) (uint32, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if n <= 0 || a.End < a.Start || uint64(a.End-a.Start)+1 < uint64(n) {
+		return 0, fmt.Errorf("can't allocate %d ports in %d-%d", n, a.Start, a.End)
+	}
+
+	if a.next < a.Start || uint64(a.next)+uint64(n)-1 > uint64(a.End) {
+		a.next = a.Start
+	}
+
+	p := a.next
+	a.next += uint32(n)
+	return p, nil
+}
@@ -0,0 +1,76 @@
+package util
+
+import (
+	"io"
+)
+
+const (
+	ansiText = iota
+	ansiEscape
+	ansiCSI
+	ansiOSC
+	ansiOSCEscape
+)
+
+// StripANSIConnector wraps the connection of connector so that terminal
+// escape sequences written to it are dropped, leaving plain text. Sequences
+// may be split across writes.
+func StripANSIConnector(connector IOConnector) IOConnector {
+	return wrapConnector(connector, func(rwc io.ReadWriteCloser) io.ReadWriteCloser {
+		return &ansiStripper{
+			ReadWriteCloser: rwc,
+		}
+	})
+}
+
+type ansiStripper struct {
+	io.ReadWriteCloser
+	state int
+	buf   []byte
+}
+
+func (a *ansiStripper) Write(p []byte) (int, error) {
+	a.buf = a.buf[:0]
+
+	for _, b := range p {
+		switch a.state {
+		case ansiText:
+			if b == 0x1b {
+				a.state = ansiEscape
+			} else {
+				a.buf = append(a.buf, b)
+			}
+		case ansiEscape:
+			switch {
+			case b == '[':
+				a.state = ansiCSI
+			case b == ']':
+				a.state = ansiOSC
+			case b >= 0x20 && b <= 0x2f:
+				// intermediate bytes, the sequence ends with the next final byte
+			default:
+				a.state = ansiText
+			}
+		case ansiCSI:
+			if b >= 0x40 && b <= 0x7e {
+				a.state = ansiText
+			}
+		case ansiOSC:
+			// OSC ends with BEL or ST (ESC \)
+			if b == 0x07 {
+				a.state = ansiText
+			} else if b == 0x1b {
+				a.state = ansiOSCEscape
+			}
+		case ansiOSCEscape:
+			a.state = ansiText
+		}
+	}
+
+	if len(a.buf) > 0 {
+		if _, err := a.ReadWriteCloser.Write(a.buf); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
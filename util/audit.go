@@ -0,0 +1,20 @@
+package util
+
+import (
+	"io"
+	"log/syslog"
+	"os"
+)
+
+// AuditSyslog is the audit log destination that sends records to syslog.
+const AuditSyslog = "syslog"
+
+// OpenAuditLog opens dest for appending audit records, dest is either a file
+// path or AuditSyslog.
+func OpenAuditLog(dest string) (io.WriteCloser, error) {
+	if dest == AuditSyslog {
+		return syslog.New(syslog.LOG_INFO|syslog.LOG_AUTH, "firecracker-containerd-agent-client")
+	}
+
+	return os.OpenFile(dest, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+}
@@ -0,0 +1,61 @@
+package util
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// Backoff paces a polling loop: polls follow each other after Min while they
+// bring something back, and the pause doubles, up to Max, while they don't.
+// Each pause is jittered so several pollers don't fall into step.
+type Backoff struct {
+	Min time.Duration
+	Max time.Duration
+
+	cur time.Duration
+}
+
+func NewBackoff(min, max time.Duration) *Backoff {
+	if max < min {
+		max = min
+	}
+	return &Backoff{
+		Min: min,
+		Max: max,
+	}
+}
+
+// Reset goes back to polling fast, once a poll brought something back.
+func (b *Backoff) Reset() {
+	b.cur = 0
+}
+
+// Wait pauses after a poll that brought nothing back, it returns early with
+// the error of ctx once it is done.
+func (b *Backoff) Wait(ctx context.Context) error {
+	if b.cur < b.Min {
+		b.cur = b.Min
+	} else if b.cur < b.Max {
+		b.cur *= 2
+		if b.cur > b.Max {
+			b.cur = b.Max
+		}
+	}
+
+	// jitter within [cur/2, cur]
+	pause := b.cur
+	if half := int64(pause / 2); half > 0 {
+		pause = time.Duration(half + rand.Int63n(half+1))
+	}
+
+	timer := time.NewTimer(pause)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,103 @@
+package util
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// ErrChaosSevered is returned by reads and writes of connections severed by
+// Chaos.
+var ErrChaosSevered = errors.New("chaos: connection severed")
+
+// Chaos randomly delays and severs connections, to test how callers cope with
+// a flaky vsock link.
+type Chaos struct {
+	// DropRate is the probability of each read or write severing the
+	// connection.
+	DropRate float64
+
+	// Latency is the upper bound of the random delay added to each read or
+	// write.
+	Latency time.Duration
+}
+
+// Enabled reports whether any fault is configured.
+func (c *Chaos) Enabled() bool {
+	return c.DropRate > 0 || c.Latency > 0
+}
+
+// disturb delays the caller and decides whether the connection survives.
+func (c *Chaos) disturb() error {
+	if c.Latency > 0 {
+		time.Sleep(time.Duration(rand.Int63n(int64(c.Latency))))
+	}
+
+	if c.DropRate > 0 && rand.Float64() < c.DropRate {
+		return ErrChaosSevered
+	}
+
+	return nil
+}
+
+// Conn wraps conn in the chaos.
+func (c *Chaos) Conn(conn net.Conn) net.Conn {
+	return &chaosConn{
+		Conn:  conn,
+		chaos: c,
+	}
+}
+
+// Connector wraps the connections of connector in the chaos.
+func (c *Chaos) Connector(connector IOConnector) IOConnector {
+	return wrapConnector(connector, func(rwc io.ReadWriteCloser) io.ReadWriteCloser {
+		return &chaosReadWriteCloser{
+			ReadWriteCloser: rwc,
+			chaos:           c,
+		}
+	})
+}
+
+type chaosConn struct {
+	net.Conn
+	chaos *Chaos
+}
+
+func (c *chaosConn) Read(p []byte) (int, error) {
+	if err := c.chaos.disturb(); err != nil {
+		c.Conn.Close()
+		return 0, err
+	}
+	return c.Conn.Read(p)
+}
+
+func (c *chaosConn) Write(p []byte) (int, error) {
+	if err := c.chaos.disturb(); err != nil {
+		c.Conn.Close()
+		return 0, err
+	}
+	return c.Conn.Write(p)
+}
+
+type chaosReadWriteCloser struct {
+	io.ReadWriteCloser
+	chaos *Chaos
+}
+
+func (c *chaosReadWriteCloser) Read(p []byte) (int, error) {
+	if err := c.chaos.disturb(); err != nil {
+		c.ReadWriteCloser.Close()
+		return 0, err
+	}
+	return c.ReadWriteCloser.Read(p)
+}
+
+func (c *chaosReadWriteCloser) Write(p []byte) (int, error) {
+	if err := c.chaos.disturb(); err != nil {
+		c.ReadWriteCloser.Close()
+		return 0, err
+	}
+	return c.ReadWriteCloser.Write(p)
+}
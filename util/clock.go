@@ -0,0 +1,75 @@
+package util
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock runs the timers of the IO proxy. Tests can swap in a FakeClock to
+// control when the flush timeout fires.
+type Clock interface {
+	AfterFunc(d time.Duration, f func())
+}
+
+type realClock struct{}
+
+func (realClock) AfterFunc(d time.Duration, f func()) {
+	time.AfterFunc(d, f)
+}
+
+type fakeTimer struct {
+	at time.Time
+	f  func()
+}
+
+// FakeClock only moves when Advance is called.
+type FakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []fakeTimer
+}
+
+func NewFakeClock() *FakeClock {
+	return &FakeClock{
+		now: time.Unix(0, 0),
+	}
+}
+
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.timers = append(c.timers, fakeTimer{at: c.now.Add(d), f: f})
+}
+
+// Pending returns the number of timers that haven't fired yet.
+func (c *FakeClock) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.timers)
+}
+
+// Advance moves the clock forward by d and runs the timers that became due,
+// in the order they were due.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+
+	var due, pending []fakeTimer
+	for _, timer := range c.timers {
+		if timer.at.After(c.now) {
+			pending = append(pending, timer)
+		} else {
+			due = append(due, timer)
+		}
+	}
+	c.timers = pending
+	c.mu.Unlock()
+
+	sort.SliceStable(due, func(i, j int) bool { return due[i].at.Before(due[j].at) })
+	for _, timer := range due {
+		timer.f()
+	}
+}
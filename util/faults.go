@@ -0,0 +1,159 @@
+package util
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// PipeConnector connects to one end of an in-memory pipe, the other end is
+// returned for the caller to play the remote side.
+func PipeConnector() (IOConnector, net.Conn) {
+	local, remote := net.Pipe()
+
+	return func(procCtx context.Context, logger *logrus.Entry) <-chan IOConnectorResult {
+		returnCh := make(chan IOConnectorResult, 1)
+		defer close(returnCh)
+
+		returnCh <- IOConnectorResult{
+			ReadWriteCloser: local,
+		}
+		return returnCh
+	}, remote
+}
+
+// ErrorConnector fails to connect with err.
+func ErrorConnector(err error) IOConnector {
+	return func(procCtx context.Context, logger *logrus.Entry) <-chan IOConnectorResult {
+		returnCh := make(chan IOConnectorResult, 1)
+		defer close(returnCh)
+
+		returnCh <- IOConnectorResult{
+			Err: err,
+		}
+		return returnCh
+	}
+}
+
+// FaultyConnector wraps the connection of connector so that reads and writes
+// fail with err once limit bytes went through it in total.
+func FaultyConnector(connector IOConnector, limit int64, err error) IOConnector {
+	return wrapConnector(connector, func(rwc io.ReadWriteCloser) io.ReadWriteCloser {
+		return &faultyReadWriteCloser{
+			ReadWriteCloser: rwc,
+			remaining:       limit,
+			err:             err,
+		}
+	})
+}
+
+// HangingConnector wraps the connection of connector so that reads and writes
+// block, until the connection is closed, once limit bytes went through it.
+func HangingConnector(connector IOConnector, limit int64) IOConnector {
+	return wrapConnector(connector, func(rwc io.ReadWriteCloser) io.ReadWriteCloser {
+		return &faultyReadWriteCloser{
+			ReadWriteCloser: rwc,
+			remaining:       limit,
+			hang:            make(chan struct{}),
+		}
+	})
+}
+
+func wrapConnector(connector IOConnector, wrap func(io.ReadWriteCloser) io.ReadWriteCloser) IOConnector {
+	return func(procCtx context.Context, logger *logrus.Entry) <-chan IOConnectorResult {
+		returnCh := make(chan IOConnectorResult, 1)
+		resultCh := connector(procCtx, logger)
+
+		go func() {
+			defer close(returnCh)
+
+			result := <-resultCh
+			if result.Err == nil {
+				result.ReadWriteCloser = wrap(result.ReadWriteCloser)
+			}
+			returnCh <- result
+		}()
+
+		return returnCh
+	}
+}
+
+type faultyReadWriteCloser struct {
+	io.ReadWriteCloser
+
+	mu        sync.Mutex
+	remaining int64
+
+	// err is returned once the limit is reached, unless hang is set, in
+	// which case the call blocks until hang is closed by Close.
+	err       error
+	hang      chan struct{}
+	closeOnce sync.Once
+}
+
+// allow returns how many of n bytes may go through, and the error to return
+// once none may.
+func (f *faultyReadWriteCloser) allow(n int) (int, error) {
+	f.mu.Lock()
+	remaining := f.remaining
+	f.mu.Unlock()
+
+	if remaining > 0 {
+		if int64(n) > remaining {
+			n = int(remaining)
+		}
+		return n, nil
+	}
+
+	if f.hang != nil {
+		<-f.hang
+		return 0, io.ErrClosedPipe
+	}
+
+	return 0, f.err
+}
+
+func (f *faultyReadWriteCloser) consume(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.remaining -= int64(n)
+}
+
+func (f *faultyReadWriteCloser) Read(p []byte) (int, error) {
+	n, err := f.allow(len(p))
+	if err != nil {
+		return 0, err
+	}
+
+	n, err = f.ReadWriteCloser.Read(p[:n])
+	f.consume(n)
+	return n, err
+}
+
+func (f *faultyReadWriteCloser) Write(p []byte) (int, error) {
+	n, err := f.allow(len(p))
+	if err != nil {
+		return 0, err
+	}
+
+	n, err = f.ReadWriteCloser.Write(p[:n])
+	f.consume(n)
+	if err == nil && n < len(p) {
+		// the rest of p crosses the limit
+		var rest int
+		rest, err = f.Write(p[n:])
+		n += rest
+	}
+	return n, err
+}
+
+func (f *faultyReadWriteCloser) Close() error {
+	if f.hang != nil {
+		f.closeOnce.Do(func() { close(f.hang) })
+	}
+	return f.ReadWriteCloser.Close()
+}
@@ -0,0 +1,61 @@
+package util
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// FIFOScheme prefixes the host fifo paths accepted in place of stdio URIs.
+const FIFOScheme = "fifo://"
+
+// FIFOPath returns the path of uri if it names a host fifo.
+func FIFOPath(uri string) (string, bool) {
+	if !strings.HasPrefix(uri, FIFOScheme) {
+		return "", false
+	}
+	return strings.TrimPrefix(uri, FIFOScheme), true
+}
+
+// FIFOConnector opens the fifo at path, flag is os.O_RDONLY for the stdin
+// side and os.O_WRONLY for the output side. Opening a fifo blocks until its
+// other end is opened too, like containerd's shims do to the fifos it creates.
+func FIFOConnector(path string, flag int) IOConnector {
+	return func(procCtx context.Context, logger *logrus.Entry) <-chan IOConnectorResult {
+		returnCh := make(chan IOConnectorResult, 1)
+
+		go func() {
+			defer close(returnCh)
+
+			opened := make(chan IOConnectorResult, 1)
+
+			go func() {
+				f, err := os.OpenFile(path, flag, 0)
+				if err != nil {
+					opened <- IOConnectorResult{Err: err}
+					return
+				}
+				opened <- IOConnectorResult{ReadWriteCloser: f}
+			}()
+
+			select {
+			case result := <-opened:
+				returnCh <- result
+			case <-procCtx.Done():
+				returnCh <- IOConnectorResult{Err: procCtx.Err()}
+
+				// the open is stuck until a peer shows up, close the fifo
+				// then so it isn't leaked
+				go func() {
+					if result := <-opened; result.Err == nil {
+						result.Close()
+					}
+				}()
+			}
+		}()
+
+		return returnCh
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"io"
 	"os"
+	"strings"
 
 	"github.com/sirupsen/logrus"
 )
@@ -17,18 +18,58 @@ func (r *ReadWriteNopCloserWrapper) Close() error {
 	return nil
 }
 
+// fileNopCloser leaves the file open on Close. Unlike
+// ReadWriteNopCloserWrapper it keeps the ReadFrom of the *os.File visible to
+// io.Copy, so copies into the file can take the zero-copy path.
+type fileNopCloser struct {
+	*os.File
+}
+
+func (f *fileNopCloser) Close() error {
+	return nil
+}
+
 func FileConnector(file *os.File) IOConnector {
 	return func(procCtx context.Context, logger *logrus.Entry) <-chan IOConnectorResult {
 		returnCh := make(chan IOConnectorResult, 1)
 		defer close(returnCh)
 
 		returnCh <- IOConnectorResult{
-			ReadWriteCloser: &ReadWriteNopCloserWrapper{
-				Reader: file,
-				Writer: file,
+			ReadWriteCloser: &fileNopCloser{
+				File: file,
 			},
 			Err: nil,
 		}
 		return returnCh
 	}
 }
+
+// AppendScheme prefixes the host files output is appended to in place of
+// stdio URIs.
+const AppendScheme = "append://"
+
+// AppendPath returns the path of uri if it names a host file to append to.
+func AppendPath(uri string) (string, bool) {
+	if !strings.HasPrefix(uri, AppendScheme) {
+		return "", false
+	}
+	return strings.TrimPrefix(uri, AppendScheme), true
+}
+
+// AppendFileConnector appends what is written to the file at path, creating
+// it if needed.
+func AppendFileConnector(path string) IOConnector {
+	return func(procCtx context.Context, logger *logrus.Entry) <-chan IOConnectorResult {
+		returnCh := make(chan IOConnectorResult, 1)
+		defer close(returnCh)
+
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			returnCh <- IOConnectorResult{Err: err}
+			return returnCh
+		}
+
+		returnCh <- IOConnectorResult{ReadWriteCloser: f}
+		return returnCh
+	}
+}
@@ -0,0 +1,33 @@
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+)
+
+// MakeExt4 builds an ext4 image of sizeMB at image, populated with the
+// contents of dir. It runs mkfs.ext4, whose -d option needs e2fsprogs 1.43 or
+// newer.
+func MakeExt4(dir, image string, sizeMB int) error {
+	return run(exec.Command("mkfs.ext4", "-q", "-F", "-d", dir, image, fmt.Sprintf("%dM", sizeMB)))
+}
+
+// MakeSquashfs builds a read-only squashfs image at image from the contents
+// of dir. It runs mksquashfs from squashfs-tools.
+func MakeSquashfs(dir, image string) error {
+	return run(exec.Command("mksquashfs", dir, image, "-noappend", "-quiet"))
+}
+
+// run runs cmd and puts its output, if any, in the returned error.
+func run(cmd *exec.Cmd) error {
+	out, err := cmd.CombinedOutput()
+	if err == nil {
+		return nil
+	}
+
+	if out = bytes.TrimSpace(out); len(out) > 0 {
+		return fmt.Errorf("%s: %w: %s", cmd.Args[0], err, out)
+	}
+	return fmt.Errorf("%s: %w", cmd.Args[0], err)
+}
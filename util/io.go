@@ -15,8 +15,11 @@ package util
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"net"
+	"os"
 	"strings"
 	"sync"
 	"time"
@@ -27,12 +30,20 @@ import (
 )
 
 const (
-	// By default, once the task exits, wait defaultIOFlushTimeout for
+	// By default, once the task exits, wait DefaultIOFlushTimeout for
 	// the IO streams to close on their own before forcibly closing them.
-	defaultIOFlushTimeout = 5 * time.Second
-	defaultBufferSize     = 1024
+	DefaultIOFlushTimeout = 5 * time.Second
+	defaultBufferSize     = 64 * 1024
 )
 
+// bufferPool holds the copy buffers shared by all proxied streams.
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, defaultBufferSize)
+		return &buf
+	},
+}
+
 type IOProxy interface {
 	Start(procCtx context.Context, logger *logrus.Logger) (ioInitDone <-chan error, ioCopyDone <-chan error)
 	Close()
@@ -56,18 +67,56 @@ type ioConnectorSet struct {
 	stdout *IOConnectorPair
 	stderr *IOConnectorPair
 
+	// flushTimeout is how long stdout and stderr are given to drain after
+	// the process exits before they are forcibly closed.
+	flushTimeout time.Duration
+	clock        Clock
+
 	// closeMu is needed since Close() will be called from different goroutines.
 	closeMu sync.Mutex
 	closed  bool
 }
 
-func NewIOConnectorProxy(stdin, stdout, stderr *IOConnectorPair) IOProxy {
-	return &ioConnectorSet{
-		stdin:  stdin,
-		stdout: stdout,
-		stderr: stderr,
-		closed: false,
+// IOProxyOpt configures the proxy built by NewIOConnectorProxy.
+type IOProxyOpt func(*ioConnectorSet)
+
+// WithFlushTimeout sets how long stdout and stderr are given to drain once
+// the proc context is done, instead of DefaultIOFlushTimeout.
+func WithFlushTimeout(flushTimeout time.Duration) IOProxyOpt {
+	return func(ioConnectorSet *ioConnectorSet) {
+		ioConnectorSet.flushTimeout = flushTimeout
+	}
+}
+
+// WithClock replaces the clock the flush timeout runs on.
+func WithClock(clock Clock) IOProxyOpt {
+	return func(ioConnectorSet *ioConnectorSet) {
+		ioConnectorSet.clock = clock
+	}
+}
+
+func NewIOConnectorProxy(stdin, stdout, stderr *IOConnectorPair, opts ...IOProxyOpt) IOProxy {
+	ioConnectorSet := &ioConnectorSet{
+		stdin:        stdin,
+		stdout:       stdout,
+		stderr:       stderr,
+		flushTimeout: DefaultIOFlushTimeout,
+		clock:        realClock{},
+		closed:       false,
+	}
+
+	for _, opt := range opts {
+		opt(ioConnectorSet)
 	}
+
+	return ioConnectorSet
+}
+
+// NewIOConnectorProxyWithFlushTimeout is like NewIOConnectorProxy but waits
+// flushTimeout, instead of DefaultIOFlushTimeout, for stdout and stderr to
+// drain once the proc context is done.
+func NewIOConnectorProxyWithFlushTimeout(stdin, stdout, stderr *IOConnectorPair, flushTimeout time.Duration) IOProxy {
+	return NewIOConnectorProxy(stdin, stdout, stderr, WithFlushTimeout(flushTimeout))
 }
 
 func (ioConnectorSet *ioConnectorSet) Close() {
@@ -88,6 +137,7 @@ func (connectorPair *IOConnectorPair) proxy(
 	ctx context.Context,
 	logger *logrus.Entry,
 	timeoutAfterExit time.Duration,
+	clock Clock,
 ) (ioInitDone <-chan error, ioCopyDone <-chan error) {
 	// initDone might not have to be buffered. We only send ioInitErr once.
 	initDone := make(chan error, 2)
@@ -141,36 +191,62 @@ func (connectorPair *IOConnectorPair) proxy(
 
 		// IO streams have been initialized successfully
 
+		// the streams are closed by whichever comes first, the end of the
+		// copy or the timeout below, only once so the other isn't reported
+		// as an error
+		var closeOnce sync.Once
+		closeStreams := func() {
+			closeOnce.Do(func() {
+				logClose(logger, reader, writer)
+			})
+		}
+
 		// Once the proc exits, wait the provided time before forcibly closing io streams.
 		// If the io streams close on their own before the timeout, the Close calls here
 		// should just be no-ops.
 		go func() {
 			<-ctx.Done()
-			time.AfterFunc(timeoutAfterExit, func() {
-				logClose(logger, reader, writer)
-			})
+			clock.AfterFunc(timeoutAfterExit, closeStreams)
 		}()
 
 		logger.Debug("begin copying io")
 		defer logger.Debug("end copying io")
 
-		size, err := io.CopyBuffer(writer, reader, make([]byte, defaultBufferSize))
+		// CopyBuffer prefers the ReaderFrom/WriterTo of either end over the
+		// buffer, which lets the runtime splice when both ends support it
+		buf := bufferPool.Get().(*[]byte)
+		size, err := io.CopyBuffer(writer, reader, *buf)
+		bufferPool.Put(buf)
 		logger.Debugf("copied %d", size)
 		if err != nil {
-			if strings.Contains(err.Error(), "use of closed network connection") ||
-				strings.Contains(err.Error(), "file already closed") {
+			if isClosedErr(err) {
 				logger.Infof("connection was closed: %v", err)
+				if ctx.Err() != nil {
+					// the proc has exited and the stream was closed after the
+					// flush timeout, this is the expected way for it to end
+					return
+				}
 			} else {
 				logger.WithError(err).Error("error copying io")
 			}
 			copyDone <- err
 		}
-		defer logClose(logger, reader, writer)
+		closeStreams()
 	}()
 
 	return initDone, copyDone
 }
 
+// isClosedErr reports whether err comes from using a stream after it was
+// closed.
+func isClosedErr(err error) bool {
+	return errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, os.ErrClosed) ||
+		errors.Is(err, io.ErrClosedPipe) ||
+		strings.Contains(err.Error(), "use of closed network connection") ||
+		strings.Contains(err.Error(), "file already closed")
+}
+
 func logClose(logger *logrus.Entry, streams ...io.Closer) {
 	var closeErr error
 	for _, stream := range streams {
@@ -206,19 +282,19 @@ func (ioConnectorSet *ioConnectorSet) Start(procCtx context.Context, logger *log
 	if ioConnectorSet.stdin != nil {
 		// For Stdin only, provide 0 as the timeout to wait after the proc exits before closing IO streams.
 		// There's no reason to send stdin data to a proc that's already dead.
-		waitErrs(ioConnectorSet.stdin.proxy(ctx, logger.WithField("stream", "stdin"), 0))
+		waitErrs(ioConnectorSet.stdin.proxy(ctx, logger.WithField("stream", "stdin"), 0, ioConnectorSet.clock))
 	} else {
 		logger.Debug("skipping proxy io for unset stdin")
 	}
 
 	if ioConnectorSet.stdout != nil {
-		waitErrs(ioConnectorSet.stdout.proxy(ctx, logger.WithField("stream", "stdout"), defaultIOFlushTimeout))
+		waitErrs(ioConnectorSet.stdout.proxy(ctx, logger.WithField("stream", "stdout"), ioConnectorSet.flushTimeout, ioConnectorSet.clock))
 	} else {
 		logger.Debug("skipping proxy io for unset stdout")
 	}
 
 	if ioConnectorSet.stderr != nil {
-		waitErrs(ioConnectorSet.stderr.proxy(ctx, logger.WithField("stream", "stderr"), defaultIOFlushTimeout))
+		waitErrs(ioConnectorSet.stderr.proxy(ctx, logger.WithField("stream", "stderr"), ioConnectorSet.flushTimeout, ioConnectorSet.clock))
 	} else {
 		logger.Debug("skipping proxy io for unset stderr")
 	}
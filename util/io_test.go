@@ -0,0 +1,196 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const testFlushTimeout = time.Second
+
+func testLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+// waitFor fails the test if nothing is received from ch in time.
+func waitFor(t *testing.T, ch <-chan error, what string) error {
+	t.Helper()
+
+	select {
+	case err := <-ch:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for %s", what)
+		return nil
+	}
+}
+
+// waitPending waits until n timers are armed on clock.
+func waitPending(t *testing.T, clock *FakeClock, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for clock.Pending() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d pending timers, want %d", clock.Pending(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestProxyCopiesUntilEOF(t *testing.T) {
+	reader, readerRemote := PipeConnector()
+	writer, writerRemote := PipeConnector()
+
+	proxy := NewIOConnectorProxy(nil, &IOConnectorPair{
+		ReadConnector:  reader,
+		WriteConnector: writer,
+	}, nil, WithClock(NewFakeClock()))
+
+	initDone, copyDone := proxy.Start(context.Background(), testLogger())
+	if err := waitFor(t, initDone, "init"); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+
+	go func() {
+		readerRemote.Write([]byte("hello"))
+		readerRemote.Close()
+	}()
+
+	got, err := io.ReadAll(writerRemote)
+	if err != nil {
+		t.Fatalf("reading the copy: %v", err)
+	}
+	if string(got) != "hello" {
+		t.Errorf("got %q, want %q", got, "hello")
+	}
+
+	if err := waitFor(t, copyDone, "copy"); err != nil {
+		t.Errorf("copy: %v", err)
+	}
+}
+
+func TestProxyInitError(t *testing.T) {
+	errConnect := errors.New("connect failed")
+	writer, writerRemote := PipeConnector()
+
+	proxy := NewIOConnectorProxy(nil, &IOConnectorPair{
+		ReadConnector:  ErrorConnector(errConnect),
+		WriteConnector: writer,
+	}, nil, WithClock(NewFakeClock()))
+
+	initDone, copyDone := proxy.Start(context.Background(), testLogger())
+	if err := waitFor(t, initDone, "init"); !errors.Is(err, errConnect) {
+		t.Errorf("got init error %v, want %v", err, errConnect)
+	}
+	if err := waitFor(t, copyDone, "copy"); err != nil {
+		t.Errorf("copy: %v", err)
+	}
+
+	// the end that did connect is closed
+	if _, err := writerRemote.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("got %v reading the connected end, want EOF", err)
+	}
+}
+
+func TestProxyCopyError(t *testing.T) {
+	errBroken := errors.New("broken stream")
+	reader, readerRemote := PipeConnector()
+	writer, writerRemote := PipeConnector()
+
+	proxy := NewIOConnectorProxy(nil, &IOConnectorPair{
+		ReadConnector:  reader,
+		WriteConnector: FaultyConnector(writer, 3, errBroken),
+	}, nil, WithClock(NewFakeClock()))
+
+	initDone, copyDone := proxy.Start(context.Background(), testLogger())
+	if err := waitFor(t, initDone, "init"); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+
+	go readerRemote.Write([]byte("hello"))
+
+	got := make([]byte, 5)
+	n, _ := io.ReadAtLeast(writerRemote, got, 3)
+	if string(got[:n]) != "hel" {
+		t.Errorf("got %q before the fault, want %q", got[:n], "hel")
+	}
+
+	if err := waitFor(t, copyDone, "copy"); !errors.Is(err, errBroken) {
+		t.Errorf("got copy error %v, want %v", err, errBroken)
+	}
+}
+
+func TestProxyClosesHangingStreamAfterFlushTimeout(t *testing.T) {
+	clock := NewFakeClock()
+	reader, readerRemote := PipeConnector()
+	writer, writerRemote := PipeConnector()
+
+	proxy := NewIOConnectorProxy(nil, &IOConnectorPair{
+		ReadConnector:  HangingConnector(reader, 5),
+		WriteConnector: writer,
+	}, nil, WithClock(clock), WithFlushTimeout(testFlushTimeout))
+
+	procCtx, procExit := context.WithCancel(context.Background())
+	defer procExit()
+
+	initDone, copyDone := proxy.Start(procCtx, testLogger())
+	if err := waitFor(t, initDone, "init"); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+
+	go readerRemote.Write([]byte("hello"))
+
+	got := make([]byte, 5)
+	if _, err := io.ReadFull(writerRemote, got); err != nil {
+		t.Fatalf("reading the copy: %v", err)
+	}
+
+	// the stream hangs, the proc exiting arms the flush timeout
+	procExit()
+	waitPending(t, clock, 1)
+
+	clock.Advance(testFlushTimeout / 2)
+	select {
+	case err := <-copyDone:
+		t.Fatalf("copy ended before the flush timeout: %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	clock.Advance(testFlushTimeout / 2)
+	if err := waitFor(t, copyDone, "copy"); err != nil {
+		t.Errorf("got copy error %v after the flush timeout, want none", err)
+	}
+}
+
+func TestProxyClosesStdinOnExit(t *testing.T) {
+	clock := NewFakeClock()
+	reader, _ := PipeConnector()
+	writer, _ := PipeConnector()
+
+	proxy := NewIOConnectorProxy(&IOConnectorPair{
+		ReadConnector:  HangingConnector(reader, 0),
+		WriteConnector: writer,
+	}, nil, nil, WithClock(clock), WithFlushTimeout(testFlushTimeout))
+
+	procCtx, procExit := context.WithCancel(context.Background())
+	initDone, copyDone := proxy.Start(procCtx, testLogger())
+	if err := waitFor(t, initDone, "init"); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+
+	// stdin isn't given the flush timeout
+	procExit()
+	waitPending(t, clock, 1)
+	clock.Advance(0)
+
+	if err := waitFor(t, copyDone, "copy"); err != nil {
+		t.Errorf("copy: %v", err)
+	}
+}
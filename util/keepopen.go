@@ -0,0 +1,36 @@
+package util
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// KeepOpenConnector wraps connector so that closing what it connects to is
+// put off until ctx is done. On the stdin side the process keeps its pipe
+// when the host's stdin ends, until it exits or a CloseIO call ends it.
+func KeepOpenConnector(ctx context.Context, connector IOConnector) IOConnector {
+	return wrapConnector(connector, func(rwc io.ReadWriteCloser) io.ReadWriteCloser {
+		return &keepOpenCloser{
+			ReadWriteCloser: rwc,
+			ctx:             ctx,
+		}
+	})
+}
+
+type keepOpenCloser struct {
+	io.ReadWriteCloser
+
+	ctx       context.Context
+	closeOnce sync.Once
+}
+
+func (k *keepOpenCloser) Close() error {
+	k.closeOnce.Do(func() {
+		go func() {
+			<-k.ctx.Done()
+			k.ReadWriteCloser.Close()
+		}()
+	})
+	return nil
+}
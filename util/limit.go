@@ -0,0 +1,58 @@
+package util
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// LimitedConnector wraps the connection of connector so that only the first
+// limit bytes written to it go through. A marker is written in place of the
+// rest, which is discarded rather than refused so the process producing it
+// doesn't block.
+func LimitedConnector(connector IOConnector, limit int64) IOConnector {
+	return wrapConnector(connector, func(rwc io.ReadWriteCloser) io.ReadWriteCloser {
+		return &limitedWriteCloser{
+			ReadWriteCloser: rwc,
+			limit:           limit,
+			remaining:       limit,
+		}
+	})
+}
+
+type limitedWriteCloser struct {
+	io.ReadWriteCloser
+
+	mu        sync.Mutex
+	limit     int64
+	remaining int64
+	truncated bool
+}
+
+func (l *limitedWriteCloser) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.truncated {
+		return len(p), nil
+	}
+
+	if int64(len(p)) <= l.remaining {
+		n, err := l.ReadWriteCloser.Write(p)
+		l.remaining -= int64(n)
+		return n, err
+	}
+
+	if _, err := l.ReadWriteCloser.Write(p[:l.remaining]); err != nil {
+		return 0, err
+	}
+
+	l.remaining = 0
+	l.truncated = true
+
+	if _, err := fmt.Fprintf(l.ReadWriteCloser, "\n[output truncated after %d bytes]\n", l.limit); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
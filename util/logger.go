@@ -0,0 +1,59 @@
+package util
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Logger is what this module logs through. The standard library's
+// *log.Logger, logrus and most other loggers satisfy it, or are a small
+// adapter away from it, so embedders can route the logs into their own
+// logging stack with WithLogger.
+type Logger interface {
+	Printf(format string, args ...interface{})
+}
+
+type loggerKey struct{}
+
+// WithLogger returns a context whose commands and IO proxies log to l.
+func WithLogger(ctx context.Context, l Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// LoggerFrom returns the logger of ctx, the standard library's default
+// logger when there is none.
+func LoggerFrom(ctx context.Context) Logger {
+	if l, ok := ctx.Value(loggerKey{}).(Logger); ok {
+		return l
+	}
+	return log.Default()
+}
+
+// ProxyLogger returns a logrus logger for the IO proxy, whose connectors log
+// through logrus, that writes each of its lines to the logger of ctx.
+func ProxyLogger(ctx context.Context) *logrus.Logger {
+	switch l := ctx.Value(loggerKey{}).(type) {
+	case nil:
+		return logrus.New()
+	case *logrus.Logger:
+		return l
+	case interface{ Logrus() *logrus.Logger }:
+		return l.Logrus()
+	default:
+		proxyLogger := logrus.New()
+		proxyLogger.SetOutput(loggerWriter{l.(Logger)})
+		return proxyLogger
+	}
+}
+
+type loggerWriter struct {
+	logger Logger
+}
+
+func (w loggerWriter) Write(p []byte) (int, error) {
+	w.logger.Printf("%s", strings.TrimSuffix(string(p), "\n"))
+	return len(p), nil
+}
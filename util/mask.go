@@ -0,0 +1,142 @@
+package util
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// maxPromptLength bounds the output kept to match prompts against.
+const maxPromptLength = 1024
+
+// InputMasker turns off the echo of the local terminal once the output of a
+// process ends with a prompt for a secret, and turns it back on once the
+// answer was entered, so the secret isn't shown or recorded.
+type InputMasker struct {
+	prompt *regexp.Regexp
+	fd     int
+	echo   io.Writer
+
+	mu     sync.Mutex
+	tail   []byte
+	masked *unix.Termios
+}
+
+// NewInputMasker masks the input of the terminal fd after output matching
+// prompt. The newline ending the masked input is written to echo, as the
+// terminal doesn't show it.
+func NewInputMasker(prompt *regexp.Regexp, fd int, echo io.Writer) *InputMasker {
+	return &InputMasker{
+		prompt: prompt,
+		fd:     fd,
+		echo:   echo,
+	}
+}
+
+// OutputConnector wraps the connection of connector, which the output of the
+// process is written to, to look for prompts in it.
+func (m *InputMasker) OutputConnector(connector IOConnector) IOConnector {
+	return wrapConnector(connector, func(rwc io.ReadWriteCloser) io.ReadWriteCloser {
+		return &maskOutput{
+			ReadWriteCloser: rwc,
+			masker:          m,
+		}
+	})
+}
+
+// InputConnector wraps the connection of connector, which the input of the
+// process is read from, to tell when the answer to a prompt was entered.
+func (m *InputMasker) InputConnector(connector IOConnector) IOConnector {
+	return wrapConnector(connector, func(rwc io.ReadWriteCloser) io.ReadWriteCloser {
+		return &maskInput{
+			ReadWriteCloser: rwc,
+			masker:          m,
+		}
+	})
+}
+
+func (m *InputMasker) output(p []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// prompts are on the last line
+	if i := bytes.LastIndexByte(p, '\n'); i >= 0 {
+		m.tail = m.tail[:0]
+		p = p[i+1:]
+	}
+
+	m.tail = append(m.tail, p...)
+	if len(m.tail) > maxPromptLength {
+		m.tail = append(m.tail[:0], m.tail[len(m.tail)-maxPromptLength:]...)
+	}
+
+	if m.masked != nil || len(m.tail) <= 0 || !m.prompt.Match(m.tail) {
+		return
+	}
+
+	termios, err := unix.IoctlGetTermios(m.fd, unix.TCGETS)
+	if err != nil {
+		return
+	}
+
+	masked := *termios
+	masked.Lflag &^= unix.ECHO
+	if err := unix.IoctlSetTermios(m.fd, unix.TCSETS, &masked); err != nil {
+		return
+	}
+
+	m.masked = termios
+	m.tail = m.tail[:0]
+}
+
+func (m *InputMasker) input(p []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.masked == nil || bytes.IndexByte(p, '\n') < 0 {
+		return
+	}
+
+	m.restore()
+	m.echo.Write([]byte("\n"))
+}
+
+func (m *InputMasker) restore() {
+	unix.IoctlSetTermios(m.fd, unix.TCSETS, m.masked)
+	m.masked = nil
+}
+
+// Restore turns the echo back on if the input is still masked.
+func (m *InputMasker) Restore() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.masked != nil {
+		m.restore()
+	}
+}
+
+type maskOutput struct {
+	io.ReadWriteCloser
+	masker *InputMasker
+}
+
+func (o *maskOutput) Write(p []byte) (int, error) {
+	n, err := o.ReadWriteCloser.Write(p)
+	o.masker.output(p[:n])
+	return n, err
+}
+
+type maskInput struct {
+	io.ReadWriteCloser
+	masker *InputMasker
+}
+
+func (i *maskInput) Read(p []byte) (int, error) {
+	n, err := i.ReadWriteCloser.Read(p)
+	i.masker.input(p[:n])
+	return n, err
+}
@@ -0,0 +1,172 @@
+package util
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// errRingBufferClosed is io.ErrClosedPipe, so the proxy sees a stream closed
+// at the exit of the process like any other.
+var errRingBufferClosed = io.ErrClosedPipe
+
+// ringBuffer is a bounded byte queue between one producer and one consumer.
+// Writes block while it is full, which pushes back on the producer instead of
+// growing without bound.
+type ringBuffer struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	buf    []byte
+	start  int
+	length int
+
+	// err is returned by Read once the buffer is drained, it is set when the
+	// producer is done.
+	err    error
+	closed bool
+
+	logger *logrus.Entry
+}
+
+func newRingBuffer(size int, logger *logrus.Entry) *ringBuffer {
+	r := &ringBuffer{
+		buf:    make([]byte, size),
+		logger: logger,
+	}
+	r.cond = sync.NewCond(&r.mu)
+	return r
+}
+
+func (r *ringBuffer) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	written := 0
+	for written < len(p) {
+		if r.length == len(r.buf) && !r.closed {
+			r.logger.Debug("buffer full, waiting for the consumer to catch up")
+		}
+
+		for r.length == len(r.buf) && !r.closed {
+			r.cond.Wait()
+		}
+
+		if r.closed {
+			return written, errRingBufferClosed
+		}
+
+		end := (r.start + r.length) % len(r.buf)
+		n := len(r.buf) - r.length
+		if end+n > len(r.buf) {
+			n = len(r.buf) - end
+		}
+
+		n = copy(r.buf[end:end+n], p[written:])
+		r.length += n
+		written += n
+		r.cond.Broadcast()
+	}
+
+	return written, nil
+}
+
+func (r *ringBuffer) Read(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for r.length == 0 && r.err == nil && !r.closed {
+		r.cond.Wait()
+	}
+
+	if r.closed {
+		return 0, errRingBufferClosed
+	}
+
+	if r.length == 0 {
+		return 0, r.err
+	}
+
+	n := r.length
+	if r.start+n > len(r.buf) {
+		n = len(r.buf) - r.start
+	}
+
+	n = copy(p, r.buf[r.start:r.start+n])
+	r.start = (r.start + n) % len(r.buf)
+	r.length -= n
+	r.cond.Broadcast()
+
+	return n, nil
+}
+
+// CloseWithError marks the producer as done, Read returns err once the
+// buffered data was consumed.
+func (r *ringBuffer) CloseWithError(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.err = err
+	r.cond.Broadcast()
+}
+
+// Close discards the buffered data and unblocks both sides.
+func (r *ringBuffer) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.closed = true
+	r.cond.Broadcast()
+	return nil
+}
+
+type bufferedReadCloser struct {
+	*ringBuffer
+	source io.ReadWriteCloser
+}
+
+func (b *bufferedReadCloser) Close() error {
+	b.ringBuffer.Close()
+	return b.source.Close()
+}
+
+// BufferedReadConnector reads from the connector ahead of the consumer into a
+// buffer of the given size. A slow consumer only holds up the source once the
+// buffer is full.
+func BufferedReadConnector(connector IOConnector, size int) IOConnector {
+	return func(procCtx context.Context, logger *logrus.Entry) <-chan IOConnectorResult {
+		returnCh := make(chan IOConnectorResult, 1)
+		resultCh := connector(procCtx, logger)
+
+		go func() {
+			defer close(returnCh)
+
+			result := <-resultCh
+			if result.Err != nil {
+				returnCh <- result
+				return
+			}
+
+			ring := newRingBuffer(size, logger)
+
+			go func() {
+				_, err := io.Copy(ring, result.ReadWriteCloser)
+				if err == nil {
+					err = io.EOF
+				}
+				ring.CloseWithError(err)
+			}()
+
+			returnCh <- IOConnectorResult{
+				ReadWriteCloser: &bufferedReadCloser{
+					ringBuffer: ring,
+					source:     result.ReadWriteCloser,
+				},
+			}
+		}()
+
+		return returnCh
+	}
+}
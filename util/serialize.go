@@ -0,0 +1,105 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// maxLineLength bounds the partial line buffered per stream, longer lines
+// are written in pieces.
+const maxLineLength = 64 * 1024
+
+// LineSerializer writes several streams a whole line at a time, so the lines
+// of stdout and stderr shown on the same terminal don't get garbled.
+type LineSerializer struct {
+	mu sync.Mutex
+}
+
+func NewLineSerializer() *LineSerializer {
+	return &LineSerializer{}
+}
+
+// Connector connects to a stream passing whole lines on to w, each prefixed
+// with tag if it isn't empty.
+func (s *LineSerializer) Connector(w io.Writer, tag string) IOConnector {
+	return func(procCtx context.Context, logger *logrus.Entry) <-chan IOConnectorResult {
+		returnCh := make(chan IOConnectorResult, 1)
+		defer close(returnCh)
+
+		returnCh <- IOConnectorResult{
+			ReadWriteCloser: &serializedStream{
+				serializer: s,
+				w:          w,
+				tag:        []byte(tag),
+			},
+		}
+		return returnCh
+	}
+}
+
+type serializedStream struct {
+	serializer *LineSerializer
+	w          io.Writer
+	tag        []byte
+	buf        []byte
+}
+
+func (st *serializedStream) Read(p []byte) (int, error) {
+	return 0, io.EOF
+}
+
+func (st *serializedStream) Write(p []byte) (int, error) {
+	st.buf = append(st.buf, p...)
+
+	start := 0
+	var err error
+
+	for err == nil {
+		i := bytes.IndexByte(st.buf[start:], '\n')
+		if i < 0 {
+			if len(st.buf)-start < maxLineLength {
+				break
+			}
+			i = maxLineLength - 1
+		}
+
+		end := start + i + 1
+		err = st.writeLine(st.buf[start:end])
+		start = end
+	}
+
+	// keep the partial line at the start of the buffer
+	st.buf = append(st.buf[:0], st.buf[start:]...)
+
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (st *serializedStream) writeLine(line []byte) error {
+	st.serializer.mu.Lock()
+	defer st.serializer.mu.Unlock()
+
+	if len(st.tag) > 0 {
+		line = append(append([]byte{}, st.tag...), line...)
+	}
+
+	_, err := st.w.Write(line)
+	return err
+}
+
+// Close writes out the last line, even if it isn't terminated.
+func (st *serializedStream) Close() error {
+	if len(st.buf) <= 0 {
+		return nil
+	}
+
+	err := st.writeLine(st.buf)
+	st.buf = nil
+	return err
+}
@@ -0,0 +1,66 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// SlogLogger is a Logger writing slog records. The IO proxy's logrus entries
+// are turned into records too, their fields becoming attributes.
+type SlogLogger struct {
+	*slog.Logger
+}
+
+func (l SlogLogger) Printf(format string, args ...interface{}) {
+	l.Info(strings.TrimSpace(fmt.Sprintf(format, args...)))
+}
+
+// Logrus returns a logrus logger that writes nothing itself and hands its
+// entries to l.
+func (l SlogLogger) Logrus() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	logger.AddHook(slogHook{l.Logger})
+	return logger
+}
+
+type slogHook struct {
+	logger *slog.Logger
+}
+
+func (slogHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h slogHook) Fire(entry *logrus.Entry) error {
+	level := slog.LevelInfo
+	switch {
+	case entry.Level <= logrus.ErrorLevel:
+		level = slog.LevelError
+	case entry.Level == logrus.WarnLevel:
+		level = slog.LevelWarn
+	case entry.Level >= logrus.DebugLevel:
+		level = slog.LevelDebug
+	}
+
+	attrs := make([]slog.Attr, 0, len(entry.Data))
+	for k, v := range entry.Data {
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		attrs = append(attrs, slog.Any(k, v))
+	}
+
+	ctx := entry.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	h.logger.LogAttrs(ctx, level, entry.Message, attrs...)
+	return nil
+}
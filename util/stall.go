@@ -0,0 +1,78 @@
+package util
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// StallDetector notices when no bytes moved on any of the streams it
+// watches, which is how a hung vsock connection shows up.
+type StallDetector struct {
+	// last is the unix nano time bytes last moved
+	last atomic.Int64
+}
+
+func NewStallDetector() *StallDetector {
+	d := &StallDetector{}
+	d.last.Store(time.Now().UnixNano())
+	return d
+}
+
+// Connector wraps the connection of connector so the bytes moving through it
+// count as activity.
+func (d *StallDetector) Connector(connector IOConnector) IOConnector {
+	return wrapConnector(connector, func(rwc io.ReadWriteCloser) io.ReadWriteCloser {
+		return &activityReadWriteCloser{
+			ReadWriteCloser: rwc,
+			detector:        d,
+		}
+	})
+}
+
+func (d *StallDetector) touch() {
+	d.last.Store(time.Now().UnixNano())
+}
+
+// Watch calls onStall each time timeout passes without activity, until ctx is
+// done.
+func (d *StallDetector) Watch(ctx context.Context, timeout time.Duration, onStall func(idle time.Duration)) {
+	ticker := time.NewTicker(timeout / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		idle := time.Since(time.Unix(0, d.last.Load()))
+		if idle >= timeout {
+			onStall(idle)
+			d.touch()
+		}
+	}
+}
+
+type activityReadWriteCloser struct {
+	io.ReadWriteCloser
+	detector *StallDetector
+}
+
+func (a *activityReadWriteCloser) Read(p []byte) (int, error) {
+	n, err := a.ReadWriteCloser.Read(p)
+	if n > 0 {
+		a.detector.touch()
+	}
+	return n, err
+}
+
+func (a *activityReadWriteCloser) Write(p []byte) (int, error) {
+	n, err := a.ReadWriteCloser.Write(p)
+	if n > 0 {
+		a.detector.touch()
+	}
+	return n, err
+}
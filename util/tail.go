@@ -0,0 +1,225 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// TailScheme prefixes the host files followed like tail -F in place of a
+// stdin URI.
+const TailScheme = "tail://"
+
+// tailPollMillis bounds how long a read waits for inotify before checking
+// for rotation and Close on its own, inotify misses renames onto the
+// directory from elsewhere.
+const tailPollMillis = 250
+
+// TailPath returns the path of uri if it names a file to follow.
+func TailPath(uri string) (string, bool) {
+	if !strings.HasPrefix(uri, TailScheme) {
+		return "", false
+	}
+	return strings.TrimPrefix(uri, TailScheme), true
+}
+
+// TailFileConnector follows the file at path from its start like tail -F:
+// reads block at its end until more is written, and once it is rotated or
+// truncated the new content is read. It is meant for the stdin side, the
+// read only ends when the proxy closes it.
+func TailFileConnector(path string) IOConnector {
+	return func(procCtx context.Context, logger *logrus.Entry) <-chan IOConnectorResult {
+		returnCh := make(chan IOConnectorResult, 1)
+		defer close(returnCh)
+
+		t, err := newTailReader(procCtx, path, logger)
+		if err != nil {
+			returnCh <- IOConnectorResult{Err: err}
+			return returnCh
+		}
+
+		returnCh <- IOConnectorResult{ReadWriteCloser: t}
+		go func() {
+			<-procCtx.Done()
+			t.Close()
+		}()
+		return returnCh
+	}
+}
+
+type tailReader struct {
+	ctx    context.Context
+	path   string
+	logger *logrus.Entry
+
+	// mu is held by Read for as long as it waits, so Close can't release
+	// the descriptors under it
+	mu      sync.Mutex
+	file    *os.File
+	ino     uint64
+	inotify int
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func newTailReader(ctx context.Context, path string, logger *logrus.Entry) (*tailReader, error) {
+	fd, err := unix.InotifyInit1(unix.IN_NONBLOCK | unix.IN_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+
+	// the directory is watched, so the file being replaced is seen too
+	mask := uint32(unix.IN_MODIFY | unix.IN_CREATE | unix.IN_MOVED_TO | unix.IN_CLOSE_WRITE | unix.IN_ATTRIB)
+	if _, err := unix.InotifyAddWatch(fd, filepath.Dir(path), mask); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+
+	t := &tailReader{
+		ctx:     ctx,
+		path:    path,
+		logger:  logger,
+		inotify: fd,
+		closed:  make(chan struct{}),
+	}
+
+	if err := t.open(); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// open switches to the file now at path.
+func (t *tailReader) open() error {
+	f, err := os.Open(t.path)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	if t.file != nil {
+		t.file.Close()
+	}
+
+	t.file = f
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		t.ino = stat.Ino
+	}
+	return nil
+}
+
+func (t *tailReader) Read(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for {
+		select {
+		case <-t.closed:
+			return 0, io.EOF
+		default:
+		}
+
+		n, err := t.file.Read(p)
+		if n > 0 {
+			return n, nil
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			return 0, err
+		}
+
+		if t.rotated() {
+			continue
+		}
+
+		if err := t.wait(); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// rotated checks, at the end of the file, whether path was replaced or the
+// file truncated, and if so starts reading from the start of the new content.
+func (t *tailReader) rotated() bool {
+	info, err := os.Stat(t.path)
+	if err != nil {
+		// moved away and not replaced yet
+		return false
+	}
+
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && stat.Ino != t.ino {
+		if err := t.open(); err != nil {
+			t.logger.WithError(err).Warn("failed reopening rotated file")
+			return false
+		}
+		return true
+	}
+
+	offset, err := t.file.Seek(0, io.SeekCurrent)
+	if err == nil && info.Size() < offset {
+		t.logger.WithField("path", t.path).Debug("file truncated, reading from the start")
+		t.file.Seek(0, io.SeekStart)
+		return true
+	}
+
+	return false
+}
+
+// wait blocks until inotify reports a change, the poll interval passes or
+// the reader is closed.
+func (t *tailReader) wait() error {
+	fds := []unix.PollFd{{Fd: int32(t.inotify), Events: unix.POLLIN}}
+
+	if _, err := unix.Poll(fds, tailPollMillis); err != nil && !errors.Is(err, unix.EINTR) {
+		return err
+	}
+
+	// the events only say to look again, drain them
+	buf := make([]byte, 4096)
+	for {
+		if n, err := unix.Read(t.inotify, buf); n <= 0 || err != nil {
+			break
+		}
+	}
+
+	select {
+	case <-t.closed:
+		return io.EOF
+	case <-t.ctx.Done():
+		return io.EOF
+	default:
+		return nil
+	}
+}
+
+func (t *tailReader) Write([]byte) (int, error) {
+	return 0, errors.New("followed files are read only")
+}
+
+func (t *tailReader) Close() error {
+	t.closeOnce.Do(func() {
+		close(t.closed)
+
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		t.file.Close()
+		unix.Close(t.inotify)
+	})
+	return nil
+}
@@ -0,0 +1,144 @@
+package util
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// ExtractTar unpacks the tar stream r under dir. Entries that would land
+// outside of dir are rejected, lexically or through a symlink unpacked
+// earlier, device nodes and fifos are skipped since they can't be created
+// without privileges and runc creates the ones it needs.
+func ExtractTar(r io.Reader, dir string) error {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(r)
+
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(dir, hdr.Name)
+		if target != dir && !strings.HasPrefix(target, dir+string(filepath.Separator)) {
+			return fmt.Errorf("tar entry %q escapes %s", hdr.Name, dir)
+		}
+		if err := checkParents(dir, target); err != nil {
+			return fmt.Errorf("tar entry %q: %w", hdr.Name, err)
+		}
+
+		mode := hdr.FileInfo().Mode()
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			// a symlink unpacked earlier is replaced, not followed
+			if fi, err := os.Lstat(target); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+				os.Remove(target)
+			}
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			if err := writeFile(target, tr); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			os.Remove(target)
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return err
+			}
+			os.Lchown(target, hdr.Uid, hdr.Gid)
+			continue
+		case tar.TypeLink:
+			link := filepath.Join(dir, hdr.Linkname)
+			if !strings.HasPrefix(link, dir+string(filepath.Separator)) {
+				return fmt.Errorf("tar hard link %q escapes %s", hdr.Linkname, dir)
+			}
+			if err := checkParents(dir, link); err != nil {
+				return fmt.Errorf("tar hard link %q: %w", hdr.Linkname, err)
+			}
+			os.Remove(target)
+			if err := os.Link(link, target); err != nil {
+				return err
+			}
+			continue
+		default:
+			continue
+		}
+
+		// only root can give files away, a rootless extraction keeps its own
+		os.Lchown(target, hdr.Uid, hdr.Gid)
+
+		// set the mode last, the umask and chown both strip bits of it
+		if err := os.Chmod(target, mode); err != nil {
+			return err
+		}
+	}
+}
+
+// checkParents fails if a directory between dir and target is a symlink,
+// writing through it could land anywhere. Parents that don't exist yet are
+// created as directories.
+func checkParents(dir, target string) error {
+	rel, err := filepath.Rel(dir, filepath.Dir(target))
+	if err != nil {
+		return err
+	}
+	if rel == "." {
+		return nil
+	}
+
+	path := dir
+	for _, name := range strings.Split(rel, string(filepath.Separator)) {
+		path = filepath.Join(path, name)
+
+		fi, err := os.Lstat(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("parent %s is a symlink", path)
+		}
+	}
+
+	return nil
+}
+
+func writeFile(path string, r io.Reader) error {
+	os.Remove(path)
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|syscall.O_NOFOLLOW, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
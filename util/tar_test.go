@@ -0,0 +1,177 @@
+package util
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// tarEntry is an entry of a test tarball, the content of regular files is
+// their name.
+type tarEntry struct {
+	name     string
+	typeflag byte
+	linkname string
+}
+
+func buildTar(t *testing.T, entries []tarEntry) *bytes.Buffer {
+	t.Helper()
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+
+	for _, e := range entries {
+		hdr := &tar.Header{
+			Name:     e.name,
+			Typeflag: e.typeflag,
+			Linkname: e.linkname,
+			Mode:     0644,
+		}
+		if e.typeflag == tar.TypeDir {
+			hdr.Mode = 0755
+		}
+		if e.typeflag == tar.TypeReg {
+			hdr.Size = int64(len(e.name))
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if e.typeflag == tar.TypeReg {
+			if _, err := tw.Write([]byte(e.name)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func TestExtractTarConfinement(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []tarEntry
+		wantErr bool
+		// files expected under the root, relative to it
+		want []string
+	}{
+		{
+			name:    "dot dot",
+			entries: []tarEntry{{name: "../escaped", typeflag: tar.TypeReg}},
+			wantErr: true,
+		},
+		{
+			name:    "dot dot inside the name",
+			entries: []tarEntry{{name: "a/../../escaped", typeflag: tar.TypeReg}},
+			wantErr: true,
+		},
+		{
+			name:    "absolute name",
+			entries: []tarEntry{{name: "/etc/escaped", typeflag: tar.TypeReg}},
+			want:    []string{"etc/escaped"},
+		},
+		{
+			name: "write through a symlinked directory",
+			entries: []tarEntry{
+				{name: "link", typeflag: tar.TypeSymlink, linkname: "OUTSIDE"},
+				{name: "link/escaped", typeflag: tar.TypeReg},
+			},
+			wantErr: true,
+		},
+		{
+			name: "write over a symlinked file",
+			entries: []tarEntry{
+				{name: "link", typeflag: tar.TypeSymlink, linkname: "OUTSIDE/escaped"},
+				{name: "link", typeflag: tar.TypeReg},
+			},
+			want: []string{"link"},
+		},
+		{
+			name: "symlink replaced by a directory",
+			entries: []tarEntry{
+				{name: "link", typeflag: tar.TypeSymlink, linkname: "OUTSIDE"},
+				{name: "link", typeflag: tar.TypeDir},
+				{name: "link/escaped", typeflag: tar.TypeReg},
+			},
+			want: []string{"link/escaped"},
+		},
+		{
+			name: "hard link out of the root",
+			entries: []tarEntry{
+				{name: "link", typeflag: tar.TypeLink, linkname: "../outside/secret"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "hard link through a symlinked directory",
+			entries: []tarEntry{
+				{name: "dir", typeflag: tar.TypeSymlink, linkname: "OUTSIDE"},
+				{name: "link", typeflag: tar.TypeLink, linkname: "dir/secret"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "hard link inside the root",
+			entries: []tarEntry{
+				{name: "file", typeflag: tar.TypeReg},
+				{name: "link", typeflag: tar.TypeLink, linkname: "file"},
+			},
+			want: []string{"file", "link"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base := t.TempDir()
+			root := filepath.Join(base, "root")
+			outside := filepath.Join(base, "outside")
+			for _, dir := range []string{root, outside} {
+				if err := os.Mkdir(dir, 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := os.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			for i := range tt.entries {
+				if tt.entries[i].linkname == "OUTSIDE" {
+					tt.entries[i].linkname = outside
+				} else if tt.entries[i].linkname == "OUTSIDE/escaped" {
+					tt.entries[i].linkname = filepath.Join(outside, "escaped")
+				}
+			}
+
+			err := ExtractTar(buildTar(t, tt.entries), root)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ExtractTar() = %v, want error %v", err, tt.wantErr)
+			}
+
+			// nothing may land next to the root, nor change what is there
+			for _, name := range []string{"escaped", filepath.Join("outside", "escaped")} {
+				if _, err := os.Lstat(filepath.Join(base, name)); err == nil {
+					t.Errorf("%s was written outside of the root", name)
+				}
+			}
+			if secret, err := os.ReadFile(filepath.Join(outside, "secret")); err != nil || string(secret) != "secret" {
+				t.Errorf("outside/secret changed: %q, %v", secret, err)
+			}
+
+			for _, name := range tt.want {
+				fi, err := os.Lstat(filepath.Join(root, name))
+				if err != nil {
+					t.Errorf("%s not extracted: %v", name, err)
+					continue
+				}
+				if !fi.Mode().IsRegular() {
+					t.Errorf("%s is %s, want a regular file", name, fi.Mode())
+				}
+			}
+		})
+	}
+}
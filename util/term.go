@@ -4,10 +4,14 @@ package util
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
 	"syscall"
+	"time"
 
 	shim "github.com/containerd/containerd/api/runtime/task/v2"
 	"github.com/containerd/ttrpc"
@@ -15,6 +19,27 @@ import (
 	"google.golang.org/protobuf/types/known/emptypb"
 )
 
+const (
+	// DefaultTerm is the TERM of a session when the host doesn't set one.
+	DefaultTerm = "xterm"
+
+	// maxTermDimension bounds the columns and rows sent to the guest, some
+	// multiplexers report absurd sizes while they resize
+	maxTermDimension = 4096
+
+	// a zero size is queried again this many times, this far apart
+	termSizeRetries    = 3
+	termSizeRetryDelay = 20 * time.Millisecond
+
+	// resizeDebounce coalesces the SIGWINCH bursts of a drag-resize into
+	// one ResizePty call
+	resizeDebounce = 100 * time.Millisecond
+)
+
+// localeEnv are the variables telling programs which charset and colors the
+// terminal supports, the first set of LC_ALL, LC_CTYPE and LANG wins.
+var localeEnv = []string{"LC_ALL", "LC_CTYPE", "LANG", "COLORTERM"}
+
 type FdReader interface {
 	io.Reader
 	Fd() uintptr
@@ -43,10 +68,60 @@ func ResizePty(ctx context.Context, containerId, executionId string, width, heig
 	return client.Call(ctx, "containerd.task.v2.Task", "ResizePty", sizeReq, sizeRes)
 }
 
-func WatchWindowSize(ctx context.Context, fd int, containerId, executionId string, client *ttrpc.Client) error {
+// TermSize returns the size of the terminal fd. A zero size, which some
+// multiplexers report mid-resize, is queried again a few times before it is
+// an error, and sizes are clamped to maxTermDimension.
+func TermSize(fd int) (width, height int, err error) {
+	for i := 0; ; i++ {
+		width, height, err = term.GetSize(fd)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		if width > 0 && height > 0 {
+			break
+		}
+
+		if i >= termSizeRetries {
+			return 0, 0, fmt.Errorf("terminal reports a size of %dx%d", width, height)
+		}
+		time.Sleep(termSizeRetryDelay)
+	}
+
+	return min(width, maxTermDimension), min(height, maxTermDimension), nil
+}
+
+// WatchWindowSize resizes the pty of the process whenever the terminal fd is
+// resized, until ctx is done or the returned stop is called. stop unregisters
+// the SIGWINCH handler, waits for the watch to end and returns the error
+// that ended it, if any. It can be called more than once.
+func WatchWindowSize(ctx context.Context, fd int, containerId, executionId string, client *ttrpc.Client) (stop func() error) {
+	ctx, cancel := context.WithCancel(ctx)
+
 	sigc := make(chan os.Signal, 1)
 	signal.Notify(sigc, syscall.SIGWINCH)
 
+	done := make(chan error, 1)
+	go func() {
+		defer signal.Stop(sigc)
+		done <- watchWindowSize(ctx, sigc, fd, containerId, executionId, client)
+	}()
+
+	var once sync.Once
+	var err error
+
+	return func() error {
+		once.Do(func() {
+			cancel()
+			err = <-done
+		})
+		return err
+	}
+}
+
+func watchWindowSize(ctx context.Context, sigc <-chan os.Signal, fd int, containerId, executionId string, client *ttrpc.Client) error {
+	lastWidth, lastHeight := -1, -1
+
 	for {
 		select {
 		case <-sigc:
@@ -54,15 +129,94 @@ func WatchWindowSize(ctx context.Context, fd int, containerId, executionId strin
 			return nil
 		}
 
-		width, height, err := term.GetSize(fd)
-		if err != nil {
-			return err
+		if !debounce(ctx, sigc, resizeDebounce) {
+			return nil
+		}
+
+		// a bad size isn't fatal, the next SIGWINCH may bring a good one
+		width, height, err := TermSize(fd)
+		if err != nil || (width == lastWidth && height == lastHeight) {
+			continue
 		}
 
 		err = ResizePty(ctx, containerId, executionId, width, height, client)
 
 		if err != nil {
+			// a call cut short by stop isn't a failure
+			if ctx.Err() != nil {
+				return nil
+			}
 			return err
 		}
+
+		lastWidth, lastHeight = width, height
+	}
+}
+
+// debounce waits until no signal came on sigc for quiet, it returns false
+// if ctx is done first.
+func debounce(ctx context.Context, sigc <-chan os.Signal, quiet time.Duration) bool {
+	timer := time.NewTimer(quiet)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-sigc:
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(quiet)
+		case <-timer.C:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// TermEnv is the environment of a terminal session like the host's: TERM set
+// to termName, or the host's TERM if empty, and if locale is set the host's
+// locale and COLORTERM.
+func TermEnv(termName string, locale bool) []string {
+	if len(termName) <= 0 {
+		termName = os.Getenv("TERM")
+	}
+	if len(termName) <= 0 {
+		termName = DefaultTerm
 	}
+
+	env := []string{"TERM=" + termName}
+
+	if locale {
+		for _, name := range localeEnv {
+			if value, ok := os.LookupEnv(name); ok && len(value) > 0 {
+				env = append(env, name+"="+value)
+			}
+		}
+	}
+
+	return env
+}
+
+// HostLocale is the locale the host terminal runs with, empty if none is set.
+func HostLocale() string {
+	for _, name := range localeEnv[:3] {
+		if value := os.Getenv(name); len(value) > 0 {
+			return value
+		}
+	}
+	return ""
+}
+
+// IsUTF8Locale tells whether locale, like en_US.UTF-8, uses the UTF-8
+// charset. The C and POSIX locales are plain ASCII, which UTF-8 covers.
+func IsUTF8Locale(locale string) bool {
+	if locale == "C" || locale == "POSIX" {
+		return true
+	}
+
+	_, charset, _ := strings.Cut(locale, ".")
+	charset, _, _ = strings.Cut(charset, "@")
+	charset = strings.ToLower(strings.ReplaceAll(charset, "-", ""))
+	return charset == "utf8"
 }
@@ -0,0 +1,29 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/sirupsen/logrus"
+)
+
+// UnixDialConnector connects to the IO port of an agent served on a unix
+// socket, which listens on "<socketPath>_<port>" for each port.
+func UnixDialConnector(socketPath string, port uint32) IOConnector {
+	return func(procCtx context.Context, logger *logrus.Entry) <-chan IOConnectorResult {
+		returnCh := make(chan IOConnectorResult)
+
+		go func() {
+			defer close(returnCh)
+
+			conn, err := net.Dial("unix", fmt.Sprintf("%s_%d", socketPath, port))
+			returnCh <- IOConnectorResult{
+				ReadWriteCloser: conn,
+				Err:             err,
+			}
+		}()
+
+		return returnCh
+	}
+}
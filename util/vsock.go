@@ -11,6 +11,24 @@ func VSockDial(cid uint32, port uint32) (*vsock.Conn, error) {
 	return vsock.Dial(cid, port, &vsock.Config{})
 }
 
+// fullWriteConn retries short writes, so a partially accepted write to the
+// vsock connection doesn't end the copy with io.ErrShortWrite.
+type fullWriteConn struct {
+	*vsock.Conn
+}
+
+func (c *fullWriteConn) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		n, err := c.Conn.Write(p[written:])
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
 func VSockDialConnector(cid uint32, port uint32) IOConnector {
 	return func(procCtx context.Context, logger *logrus.Entry) <-chan IOConnectorResult {
 		returnCh := make(chan IOConnectorResult)
@@ -19,9 +37,17 @@ func VSockDialConnector(cid uint32, port uint32) IOConnector {
 			defer close(returnCh)
 
 			conn, err := VSockDial(cid, port)
+			if err != nil {
+				returnCh <- IOConnectorResult{
+					Err: err,
+				}
+				return
+			}
+
 			returnCh <- IOConnectorResult{
-				ReadWriteCloser: conn,
-				Err:             err,
+				ReadWriteCloser: &fullWriteConn{
+					Conn: conn,
+				},
 			}
 		}()
 